@@ -9,7 +9,10 @@ import (
 	"github.com/sabirov8872/bookstore/internal/repository"
 	"github.com/sabirov8872/bookstore/internal/routes"
 	"github.com/sabirov8872/bookstore/internal/service"
+	"github.com/sabirov8872/bookstore/pkg/mailer"
 	"github.com/sabirov8872/bookstore/pkg/minio"
+	"github.com/sabirov8872/bookstore/pkg/oidc"
+	"github.com/sabirov8872/bookstore/pkg/payment"
 	"github.com/sabirov8872/bookstore/pkg/postgres"
 	"github.com/sabirov8872/bookstore/pkg/redis"
 )
@@ -35,10 +38,21 @@ func Run() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	pp, err := payment.NewProvider(cfg.Payment)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ml, err := mailer.NewMailer(cfg.Mailer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	op := oidc.NewProvider(cfg.OIDC)
 	fmt.Println("START")
 
 	repo := repository.NewRepository(db)
-	serv := service.NewService(repo, rc, mc)
+	serv := service.NewService(repo, rc, mc, pp, ml, cfg.Session, cfg.TwoFactor, cfg.Login, op, cfg.OIDC, cfg.Tokens)
+	go serv.RunUploadCleanup()
+
 	hand := handler.NewHandler(serv)
-	routes.Run(hand, cfg.Server.Port, repo)
+	routes.Run(hand, cfg.Server.Port, serv)
 }
@@ -3,7 +3,11 @@ package config
 import (
 	"os"
 
+	"github.com/sabirov8872/bookstore/internal/service"
+	"github.com/sabirov8872/bookstore/pkg/mailer"
 	"github.com/sabirov8872/bookstore/pkg/minio"
+	"github.com/sabirov8872/bookstore/pkg/oidc"
+	"github.com/sabirov8872/bookstore/pkg/payment"
 	"github.com/sabirov8872/bookstore/pkg/postgres"
 	"github.com/sabirov8872/bookstore/pkg/redis"
 	"gopkg.in/yaml.v3"
@@ -13,9 +17,16 @@ type Config struct {
 	Server struct {
 		Port int `yaml:"port"`
 	} `yaml:"server"`
-	Postgres postgres.Config `yaml:"postgres"`
-	Minio    minio.Config    `yaml:"minio"`
-	Redis    redis.Config    `yaml:"redis"`
+	Postgres  postgres.Config         `yaml:"postgres"`
+	Minio     minio.Config            `yaml:"minio"`
+	Redis     redis.Config            `yaml:"redis"`
+	Payment   payment.Config          `yaml:"payment"`
+	Mailer    mailer.Config           `yaml:"mailer"`
+	Session   service.SessionConfig   `yaml:"session"`
+	TwoFactor service.TwoFactorConfig `yaml:"twoFactor"`
+	Login     service.LoginConfig     `yaml:"login"`
+	OIDC      oidc.Config             `yaml:"oidc"`
+	Tokens    service.TokenConfig     `yaml:"tokens"`
 }
 
 func Load() (*Config, error) {
@@ -1,17 +1,29 @@
 package handler
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sabirov8872/bookstore/internal/service"
 	"github.com/sabirov8872/bookstore/internal/types"
+	"github.com/sabirov8872/bookstore/pkg/payment"
 )
 
+// tusVersion is the version of the tus resumable upload protocol that the
+// upload routes speak.
+const tusVersion = "1.0.0"
+
 type Handler struct {
 	service service.IService
 }
@@ -19,7 +31,37 @@ type Handler struct {
 type IHandler interface {
 	CreateUser(w http.ResponseWriter, r *http.Request)
 	GetSessionIdByUsername(w http.ResponseWriter, r *http.Request)
+	LoginTwoFactor(w http.ResponseWriter, r *http.Request)
+	CreateToken(w http.ResponseWriter, r *http.Request)
+	CreateTokenTwoFactor(w http.ResponseWriter, r *http.Request)
+	RefreshToken(w http.ResponseWriter, r *http.Request)
+	RevokeToken(w http.ResponseWriter, r *http.Request)
+	GetJWKS(w http.ResponseWriter, r *http.Request)
+	StartOIDCLogin(w http.ResponseWriter, r *http.Request)
+	CompleteOIDCLogin(w http.ResponseWriter, r *http.Request)
 	DeleteSessionId(w http.ResponseWriter, r *http.Request)
+	RequestPasswordReset(w http.ResponseWriter, r *http.Request)
+	ResetPassword(w http.ResponseWriter, r *http.Request)
+	VerifyEmail(w http.ResponseWriter, r *http.Request)
+	ResendEmailVerification(w http.ResponseWriter, r *http.Request)
+	GetSessions(w http.ResponseWriter, r *http.Request)
+	DeleteSession(w http.ResponseWriter, r *http.Request)
+	DeleteOtherSessions(w http.ResponseWriter, r *http.Request)
+	UnlockUser(w http.ResponseWriter, r *http.Request)
+	GetAPIKeys(w http.ResponseWriter, r *http.Request)
+	CreateAPIKey(w http.ResponseWriter, r *http.Request)
+	DeleteAPIKey(w http.ResponseWriter, r *http.Request)
+	GetAuditLog(w http.ResponseWriter, r *http.Request)
+	SetupTwoFactor(w http.ResponseWriter, r *http.Request)
+	ConfirmTwoFactor(w http.ResponseWriter, r *http.Request)
+	DisableTwoFactor(w http.ResponseWriter, r *http.Request)
+
+	GetAllPermissions(w http.ResponseWriter, r *http.Request)
+	GetAllRoles(w http.ResponseWriter, r *http.Request)
+	GetRoleById(w http.ResponseWriter, r *http.Request)
+	CreateRole(w http.ResponseWriter, r *http.Request)
+	UpdateRole(w http.ResponseWriter, r *http.Request)
+	DeleteRole(w http.ResponseWriter, r *http.Request)
 
 	GetAllUsers(w http.ResponseWriter, r *http.Request)
 	GetUserById(w http.ResponseWriter, r *http.Request)
@@ -32,6 +74,8 @@ type IHandler interface {
 	CreateBook(w http.ResponseWriter, r *http.Request)
 	UpdateBookById(w http.ResponseWriter, r *http.Request)
 	DeleteBookById(w http.ResponseWriter, r *http.Request)
+	GetBookPrices(w http.ResponseWriter, r *http.Request)
+	GetBookPriceAt(w http.ResponseWriter, r *http.Request)
 
 	GetAllAuthors(w http.ResponseWriter, r *http.Request)
 	GetAuthorById(w http.ResponseWriter, r *http.Request)
@@ -46,6 +90,44 @@ type IHandler interface {
 
 	UploadFileByBookId(w http.ResponseWriter, r *http.Request)
 	GetFileByBookId(w http.ResponseWriter, r *http.Request)
+	GetFileURL(w http.ResponseWriter, r *http.Request)
+	CreateFileUpload(w http.ResponseWriter, r *http.Request)
+	FinalizeFileUpload(w http.ResponseWriter, r *http.Request)
+	GetFileMetadata(w http.ResponseWriter, r *http.Request)
+	FillBookFromFile(w http.ResponseWriter, r *http.Request)
+	GetUploadOptions(w http.ResponseWriter, r *http.Request)
+	CreateUpload(w http.ResponseWriter, r *http.Request)
+	GetUpload(w http.ResponseWriter, r *http.Request)
+	WriteUpload(w http.ResponseWriter, r *http.Request)
+	DeleteUpload(w http.ResponseWriter, r *http.Request)
+
+	GetCart(w http.ResponseWriter, r *http.Request)
+	AddCartItem(w http.ResponseWriter, r *http.Request)
+	UpdateCartItem(w http.ResponseWriter, r *http.Request)
+	DeleteCartItem(w http.ResponseWriter, r *http.Request)
+	ClearCart(w http.ResponseWriter, r *http.Request)
+	ApplyCoupon(w http.ResponseWriter, r *http.Request)
+	RemoveCoupon(w http.ResponseWriter, r *http.Request)
+
+	Checkout(w http.ResponseWriter, r *http.Request)
+	GetOrdersBySessionId(w http.ResponseWriter, r *http.Request)
+	GetOrderBySessionId(w http.ResponseWriter, r *http.Request)
+	GetAllOrders(w http.ResponseWriter, r *http.Request)
+	UpdateOrderStatus(w http.ResponseWriter, r *http.Request)
+	PayOrder(w http.ResponseWriter, r *http.Request)
+	PaymentWebhook(w http.ResponseWriter, r *http.Request)
+
+	GetInventory(w http.ResponseWriter, r *http.Request)
+	UpdateInventory(w http.ResponseWriter, r *http.Request)
+	CreateInventoryAdjustment(w http.ResponseWriter, r *http.Request)
+	GetInventoryAdjustments(w http.ResponseWriter, r *http.Request)
+	GetLowStock(w http.ResponseWriter, r *http.Request)
+
+	GetAllPromotions(w http.ResponseWriter, r *http.Request)
+	GetPromotionById(w http.ResponseWriter, r *http.Request)
+	CreatePromotion(w http.ResponseWriter, r *http.Request)
+	UpdatePromotion(w http.ResponseWriter, r *http.Request)
+	DeletePromotion(w http.ResponseWriter, r *http.Request)
 }
 
 func NewHandler(service service.IService) *Handler {
@@ -79,198 +161,323 @@ func (h *Handler) GetSessionIdByUsername(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	req.UserAgent = r.UserAgent()
+	req.IP, _, _ = net.SplitHostPort(r.RemoteAddr)
+
 	res, err := h.service.GetSessionIdByUsername(req)
+	if errors.Is(err, service.ErrAccountLocked) {
+		writeJSON(w, http.StatusLocked, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrTooManyRequests) {
+		writeRetry(w, err)
+		return
+	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: "invalid username"})
 		return
 	}
 
-	cookie := &http.Cookie{
-		Name:     "sessionId",
-		Value:    res.SessionId,
-		HttpOnly: true,
-		Secure:   false,
-		Path:     "/",
+	// with two-factor authentication there is no session until the
+	// challenge is answered at /login/2fa
+	if res.SessionId != "" {
+		setSessionCookie(w, res)
 	}
 
-	http.SetCookie(w, cookie)
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) DeleteSessionId(w http.ResponseWriter, r *http.Request) {
-	cookie, _ := r.Cookie("sessionId")
-
-	err := h.service.DeleteSessionId(cookie.Value)
+func (h *Handler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req types.LoginTwoFactorRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: "invalid sessionId"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
-}
 
-func (h *Handler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	res, err := h.service.GetAllUsers()
+	req.UserAgent = r.UserAgent()
+	req.IP, _, _ = net.SplitHostPort(r.RemoteAddr)
+
+	res, err := h.service.LoginTwoFactor(req)
+	if errors.Is(err, service.ErrAccountLocked) {
+		writeJSON(w, http.StatusLocked, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrTooManyRequests) {
+		writeRetry(w, err)
+		return
+	}
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
+	setSessionCookie(w, res)
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) UpdateUserBySessionId(w http.ResponseWriter, r *http.Request) {
-	var req types.UpdateUserRequest
+// CreateToken is the login for clients without cookies. The access token
+// goes in the Authorization header as a bearer token.
+func (h *Handler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	var req types.GetSessionIdByUsernameRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	cookie, _ := r.Cookie("sessionId")
+	req.UserAgent = r.UserAgent()
+	req.IP, _, _ = net.SplitHostPort(r.RemoteAddr)
 
-	err = h.service.UpdateUserBySessionId(req, cookie.Value)
+	res, err := h.service.CreateToken(req)
+	if errors.Is(err, service.ErrAccountLocked) {
+		writeJSON(w, http.StatusLocked, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrTooManyRequests) {
+		writeRetry(w, err)
+		return
+	}
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: "invalid username or password"})
 		return
 	}
+
+	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) GetUserById(w http.ResponseWriter, r *http.Request) {
-	id, err := getID(r)
+func (h *Handler) CreateTokenTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req types.LoginTwoFactorRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	res, err := h.service.GetUserById(id)
+	req.UserAgent = r.UserAgent()
+	req.IP, _, _ = net.SplitHostPort(r.RemoteAddr)
+
+	res, err := h.service.CreateTokenTwoFactor(req)
+	if errors.Is(err, service.ErrAccountLocked) {
+		writeJSON(w, http.StatusLocked, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrTooManyRequests) {
+		writeRetry(w, err)
+		return
+	}
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) UpdateUserById(w http.ResponseWriter, r *http.Request) {
-	var req types.UpdateUserByIdRequest
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req types.RefreshTokenRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	id, err := getID(r)
+	res, err := h.service.RefreshToken(req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	err = h.service.UpdateUserById(id, req)
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	var req types.RefreshTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	err = h.service.RevokeToken(req)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
+
+	writeJSON(w, http.StatusOK, nil)
 }
 
-func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	id, err := getID(r)
+// GetJWKS publishes the keys that access tokens are signed with, so other
+// services can check the tokens themselves.
+func (h *Handler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	res, err := h.service.GetJWKS()
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	err = h.service.DeleteUser(id)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, res)
+}
+
+// StartOIDCLogin sends the browser to the identity provider. The state is
+// also kept in a cookie, so that the callback only completes logins that
+// were started in the same browser.
+func (h *Handler) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	res, err := h.service.StartOIDCLogin()
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "oidcState",
+		Value:    res.State,
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   false,
+		Path:     "/login/oidc",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, res.URL, http.StatusFound)
 }
 
-func (h *Handler) GetAllBooks(w http.ResponseWriter, r *http.Request) {
-	var req types.GetAllBooksRequest
-	req.Filter = r.URL.Query().Get("filter")
-	req.ID = r.URL.Query().Get("id")
-	req.SortBy = r.URL.Query().Get("sort_by")
-	req.OrderBy = r.URL.Query().Get("order_by")
+func (h *Handler) CompleteOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: e + ": " + q.Get("error_description")})
+		return
+	}
 
-	res, err := h.service.GetAllBooks(req)
+	cookie, err := r.Cookie("oidcState")
+	if err != nil || cookie.Value != q.Get("state") {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "state does not match"})
+		return
+	}
+
+	req := types.CompleteOIDCLoginRequest{
+		Code:      q.Get("code"),
+		State:     q.Get("state"),
+		UserAgent: r.UserAgent(),
+	}
+	req.IP, _, _ = net.SplitHostPort(r.RemoteAddr)
+
+	res, err := h.service.CompleteOIDCLogin(req)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
+	http.SetCookie(w, &http.Cookie{Name: "oidcState", MaxAge: -1, Path: "/login/oidc"})
+	if res.SessionId != "" {
+		setSessionCookie(w, res)
+	}
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) CreateBook(w http.ResponseWriter, r *http.Request) {
-	var req types.CreateBookRequest
+func setSessionCookie(w http.ResponseWriter, res *types.GetSessionIdByUsernameResponse) {
+	cookie := &http.Cookie{
+		Name:     "sessionId",
+		Value:    res.SessionId,
+		Expires:  *res.ExpiresAt,
+		HttpOnly: true,
+		Secure:   false,
+		Path:     "/",
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+func (h *Handler) DeleteSessionId(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	err := h.service.DeleteSessionId(sessionId)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: "invalid sessionId"})
+		return
+	}
+}
+
+func (h *Handler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req types.RequestPasswordResetRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	res, err := h.service.CreateBook(req)
+	err = h.service.RequestPasswordReset(req)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
-
-	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) GetBookById(w http.ResponseWriter, r *http.Request) {
-	id, err := getID(r)
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req types.ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	res, err := h.service.GetBookById(id)
+	err = h.service.ResetPassword(req)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
-
-	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) UpdateBookById(w http.ResponseWriter, r *http.Request) {
-	var req types.UpdateBookRequest
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req types.VerifyEmailRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	id, err := getID(r)
+	err = h.service.VerifyEmail(req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
+}
 
-	err = h.service.UpdateBook(id, req)
+func (h *Handler) ResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	err := h.service.ResendEmailVerification(sessionId)
+	if errors.Is(err, service.ErrTooManyRequests) {
+		writeJSON(w, http.StatusTooManyRequests, types.ErrorResponse{Message: err.Error()})
+		return
+	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 }
 
-func (h *Handler) DeleteBookById(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UnlockUser(w http.ResponseWriter, r *http.Request) {
 	id, err := getID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
 		return
 	}
 
-	err = h.service.DeleteBook(id)
+	sessionId, _ := GetSessionId(r)
+
+	err = h.service.UnlockUser(sessionId, id)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 }
 
-func (h *Handler) GetAllAuthors(w http.ResponseWriter, r *http.Request) {
-	res, err := h.service.GetAllAuthors()
+func (h *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	res, err := h.service.GetAPIKeys(sessionId)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
@@ -279,15 +486,17 @@ func (h *Handler) GetAllAuthors(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) CreateAuthor(w http.ResponseWriter, r *http.Request) {
-	var req types.CreateAuthorRequest
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	var req types.CreateAPIKeyRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	res, err := h.service.CreateAuthor(req)
+	res, err := h.service.CreateAPIKey(sessionId, req)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
@@ -296,14 +505,35 @@ func (h *Handler) CreateAuthor(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) GetAuthorById(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 	id, err := getID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid api key id"})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	err = h.service.DeleteAPIKey(sessionId, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	req, err := getPage(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid limit"})
+		return
+	}
+
+	res, err := h.service.GetAuditLog(req)
+	if errors.Is(err, service.ErrBadCursor) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid cursor"})
 		return
 	}
 
-	res, err := h.service.GetAuthorById(id)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
@@ -312,60 +542,94 @@ func (h *Handler) GetAuthorById(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) UpdateAuthorById(w http.ResponseWriter, r *http.Request) {
-	var req types.UpdateAuthorRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+func (h *Handler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	res, err := h.service.SetupTwoFactor(sessionId)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	id, err := getID(r)
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	var req types.TwoFactorCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	err = h.service.UpdateAuthor(id, req)
+	res, err := h.service.ConfirmTwoFactor(sessionId, req)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
+
+	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) DeleteAuthor(w http.ResponseWriter, r *http.Request) {
-	id, err := getID(r)
+func (h *Handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	var req types.TwoFactorCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	err = h.service.DeleteAuthor(id)
+	err = h.service.DisableTwoFactor(sessionId, req)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 }
 
-func (h *Handler) GetAllGenres(w http.ResponseWriter, r *http.Request) {
-	listGenres, err := h.service.GetAllGenres()
+func (h *Handler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	res, err := h.service.GetSessions(sessionId)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	writeJSON(w, http.StatusOK, listGenres)
+	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) CreateGenre(w http.ResponseWriter, r *http.Request) {
-	var req types.CreateGenreRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid session id"})
 		return
 	}
 
-	res, err := h.service.CreateGenre(req)
+	sessionId, _ := GetSessionId(r)
+
+	err = h.service.DeleteSession(sessionId, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) DeleteOtherSessions(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	err := h.service.DeleteOtherSessions(sessionId)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) GetAllPermissions(w http.ResponseWriter, r *http.Request) {
+	res, err := h.service.GetAllPermissions()
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
@@ -374,91 +638,1412 @@ func (h *Handler) CreateGenre(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) UpdateGenre(w http.ResponseWriter, r *http.Request) {
-	var req types.UpdateGenreRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+func (h *Handler) GetAllRoles(w http.ResponseWriter, r *http.Request) {
+	res, err := h.service.GetAllRoles()
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetRoleById(w http.ResponseWriter, r *http.Request) {
 	id, err := getID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid role id"})
 		return
 	}
 
-	err = h.service.UpdateGenre(id, req)
+	res, err := h.service.GetRoleById(id)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
+
+	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) DeleteGenre(w http.ResponseWriter, r *http.Request) {
-	id, err := getID(r)
+func (h *Handler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var req types.CreateRoleRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	err = h.service.DeleteGenre(id)
+	res, err := h.service.CreateRole(req)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
+
+	writeJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) UploadFileByBookId(w http.ResponseWriter, r *http.Request) {
-	var err error
-	id, err := getID(r)
+func (h *Handler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdateRoleRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	file, fileHeader, err := r.FormFile("file")
+	id, err := getID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid role id"})
 		return
 	}
-	defer file.Close()
 
-	err = h.service.UploadFileByBookId(types.UploadFileByBookIdRequest{
-		ID:         id,
-		File:       file,
-		FileHeader: fileHeader,
-	})
+	err = h.service.UpdateRole(id, req)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
 }
 
-func (h *Handler) GetFileByBookId(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteRole(w http.ResponseWriter, r *http.Request) {
 	id, err := getID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid role id"})
 		return
 	}
 
-	req, err := h.service.GetFileByBookId(id)
+	err = h.service.DeleteRole(id)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
 		return
 	}
-
-	w.Header().Set("Content-Disposition", "attachment; filename="+req.Filename)
-	http.ServeContent(w, r, req.Filename, time.Now(), req.File)
 }
 
-func getID(r *http.Request) (int, error) {
-	id, err := strconv.Atoi(mux.Vars(r)["id"])
+func (h *Handler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
+	req, err := getPage(r)
 	if err != nil {
-		return 0, err
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid limit"})
+		return
 	}
 
-	return id, nil
+	res, err := h.service.GetAllUsers(req)
+	if errors.Is(err, service.ErrBadCursor) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid cursor"})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) UpdateUserBySessionId(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	err = h.service.UpdateUserBySessionId(req, sessionId)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) GetUserById(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	res, err := h.service.GetUserById(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) UpdateUserById(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdateUserByIdRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	err = h.service.UpdateUserById(id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	err = h.service.DeleteUser(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) GetAllBooks(w http.ResponseWriter, r *http.Request) {
+	var req types.GetAllBooksRequest
+	req.Query = r.URL.Query().Get("q")
+	req.SortBy = r.URL.Query().Get("sort_by")
+	req.OrderBy = r.URL.Query().Get("order_by")
+
+	filter, err := getBookFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+	req.Filter = filter
+
+	page, err := getPage(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid limit"})
+		return
+	}
+	req.Cursor = page.Cursor
+	req.Limit = page.Limit
+
+	res, err := h.service.GetAllBooks(req)
+	if errors.Is(err, service.ErrBadCursor) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid cursor"})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) CreateBook(w http.ResponseWriter, r *http.Request) {
+	var req types.CreateBookRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	res, err := h.service.CreateBook(req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetBookById(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	res, err := h.service.GetBookById(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) UpdateBookById(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdateBookRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	err = h.service.UpdateBook(id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) DeleteBookById(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	err = h.service.DeleteBook(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) GetBookPrices(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	res, err := h.service.GetBookPrices(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetBookPriceAt(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	at := time.Now()
+	if value := r.URL.Query().Get("at"); value != "" {
+		at, err = parseTime(value)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid at"})
+			return
+		}
+	}
+
+	res, err := h.service.GetBookPriceAt(id, at)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetAllAuthors(w http.ResponseWriter, r *http.Request) {
+	req, err := getPage(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid limit"})
+		return
+	}
+
+	res, err := h.service.GetAllAuthors(req)
+	if errors.Is(err, service.ErrBadCursor) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid cursor"})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) CreateAuthor(w http.ResponseWriter, r *http.Request) {
+	var req types.CreateAuthorRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	res, err := h.service.CreateAuthor(req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetAuthorById(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	res, err := h.service.GetAuthorById(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) UpdateAuthorById(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdateAuthorRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	err = h.service.UpdateAuthor(id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) DeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	err = h.service.DeleteAuthor(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) GetAllGenres(w http.ResponseWriter, r *http.Request) {
+	req, err := getPage(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid limit"})
+		return
+	}
+
+	listGenres, err := h.service.GetAllGenres(req)
+	if errors.Is(err, service.ErrBadCursor) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid cursor"})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listGenres)
+}
+
+func (h *Handler) CreateGenre(w http.ResponseWriter, r *http.Request) {
+	var req types.CreateGenreRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	res, err := h.service.CreateGenre(req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdateGenreRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	err = h.service.UpdateGenre(id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) DeleteGenre(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user id"})
+		return
+	}
+
+	err = h.service.DeleteGenre(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) UploadFileByBookId(w http.ResponseWriter, r *http.Request) {
+	var err error
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+	defer file.Close()
+
+	var fill bool
+	if value := r.FormValue("fill"); value != "" {
+		fill, err = strconv.ParseBool(value)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid fill"})
+			return
+		}
+	}
+
+	res, err := h.service.UploadFileByBookId(types.UploadFileByBookIdRequest{
+		ID:         id,
+		File:       file,
+		FileHeader: fileHeader,
+		Fill:       fill,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetFileMetadata(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	res, err := h.service.GetFileMetadata(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) FillBookFromFile(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	res, err := h.service.FillBookFromFile(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetFileByBookId(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	res, err := h.service.GetFileByBookId(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+	defer res.File.Close()
+
+	// without a known type ServeContent sniffs the first bytes
+	if contentType := service.ContentType(res.Filename); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	w.Header().Set("ETag", res.ETag)
+	w.Header().Set("Cache-Control", "private")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": res.Filename}))
+
+	// ServeContent answers Range and If-Range with 206, including several
+	// ranges, and If-None-Match and If-Modified-Since with 304
+	http.ServeContent(w, r, res.Filename, res.LastModified, res.File)
+}
+
+// GetFileURL redirects to a short-lived link to the file in storage, so the
+// download does not go through this server.
+func (h *Handler) GetFileURL(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	url, err := h.service.GetFileURL(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (h *Handler) CreateFileUpload(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	var req types.CreateFileUploadRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	res, err := h.service.CreateFileUpload(id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) FinalizeFileUpload(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	err = h.service.FinalizeFileUpload(id, mux.Vars(r)["uploadId"])
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, nil)
+}
+
+// GetUploadOptions tells tus clients what the upload routes support.
+func (h *Handler) GetUploadOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload. The length comes in Upload-Length
+// and the filename in Upload-Metadata, as tus clients send them.
+func (h *Handler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid Upload-Length"})
+		return
+	}
+
+	uploadId, err := h.service.CreateUpload(id, types.CreateUploadRequest{
+		Filename: uploadMetadata(r.Header.Get("Upload-Metadata"))["filename"],
+		Length:   length,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Location", "/files/"+strconv.Itoa(id)+"/uploads/"+uploadId)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// GetUpload answers HEAD with how much of the upload has arrived, so a
+// client can resume from there.
+func (h *Handler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	res, err := h.service.GetUpload(id, mux.Vars(r)["uploadId"])
+	if err != nil {
+		writeJSON(w, uploadStatus(err), types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(res.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(res.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// WriteUpload takes a chunk of a resumable upload. The body is passed on to
+// storage as it arrives.
+func (h *Handler) WriteUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		writeJSON(w, http.StatusUnsupportedMediaType, types.ErrorResponse{Message: "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid Upload-Offset"})
+		return
+	}
+
+	if r.ContentLength < 0 {
+		writeJSON(w, http.StatusLengthRequired, types.ErrorResponse{Message: "Content-Length is required"})
+		return
+	}
+
+	offset, err = h.service.WriteUpload(types.WriteUploadRequest{
+		BookId:   id,
+		UploadID: mux.Vars(r)["uploadId"],
+		Offset:   offset,
+		Size:     r.ContentLength,
+		Body:     r.Body,
+	})
+	if err != nil {
+		writeJSON(w, uploadStatus(err), types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	err = h.service.DeleteUpload(id, mux.Vars(r)["uploadId"])
+	if err != nil {
+		writeJSON(w, uploadStatus(err), types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadStatus returns the status that tus clients expect for err.
+func uploadStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrUploadOffset):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrUploadLocked):
+		return http.StatusLocked
+	case errors.Is(err, service.ErrUploadChunk):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// uploadMetadata decodes the Upload-Metadata header, comma separated keys
+// each followed by a space and the base64 of the value.
+func uploadMetadata(header string) map[string]string {
+	res := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+
+		res[key] = string(decoded)
+	}
+
+	return res
+}
+
+func (h *Handler) GetCart(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	res, err := h.service.GetCart(sessionId)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) AddCartItem(w http.ResponseWriter, r *http.Request) {
+	var req types.AddCartItemRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	err = h.service.AddCartItem(sessionId, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdateCartItemRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	err = h.service.UpdateCartItem(sessionId, id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) DeleteCartItem(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	err = h.service.DeleteCartItem(sessionId, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) ClearCart(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	err := h.service.ClearCart(sessionId)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) ApplyCoupon(w http.ResponseWriter, r *http.Request) {
+	var req types.ApplyCouponRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	err = h.service.ApplyCoupon(sessionId, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) RemoveCoupon(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	err := h.service.RemoveCoupon(sessionId)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) Checkout(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := GetSessionId(r)
+
+	res, err := h.service.Checkout(sessionId)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetOrdersBySessionId(w http.ResponseWriter, r *http.Request) {
+	req, err := getPage(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid limit"})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	res, err := h.service.GetOrdersBySessionId(sessionId, req)
+	if errors.Is(err, service.ErrBadCursor) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid cursor"})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetOrderBySessionId(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid order id"})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	res, err := h.service.GetOrderBySessionId(sessionId, id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetAllOrders(w http.ResponseWriter, r *http.Request) {
+	page, err := getPage(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid limit"})
+		return
+	}
+
+	req := types.GetAllOrdersRequest{
+		Status: r.URL.Query().Get("status"),
+		Cursor: page.Cursor,
+		Limit:  page.Limit,
+	}
+
+	if userId := r.URL.Query().Get("user_id"); userId != "" {
+		req.UserId, err = strconv.Atoi(userId)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid user_id"})
+			return
+		}
+	}
+
+	res, err := h.service.GetAllOrders(req)
+	if errors.Is(err, service.ErrBadCursor) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid cursor"})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdateOrderStatusRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid order id"})
+		return
+	}
+
+	// refunds move money, so they need more than orders:write
+	if req.Status == "refunded" {
+		sessionId, _ := GetSessionId(r)
+
+		ok, err := h.service.HasPermission(sessionId, service.PermissionOrdersRefund)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+			return
+		}
+
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: "permission denied"})
+			return
+		}
+	}
+
+	err = h.service.UpdateOrderStatus(id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) PayOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid order id"})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	res, err := h.service.PayOrder(sessionId, id)
+	if errors.Is(err, payment.ErrDeclined) {
+		writeJSON(w, http.StatusPaymentRequired, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// PaymentWebhook answers 5xx on failures so that the provider delivers the
+// event again later.
+func (h *Handler) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	err = h.service.HandlePaymentWebhook(payload, r.Header)
+	if errors.Is(err, payment.ErrBadSignature) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) GetInventory(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	res, err := h.service.GetInventory(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) UpdateInventory(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdateInventoryRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	err = h.service.UpdateInventory(id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) CreateInventoryAdjustment(w http.ResponseWriter, r *http.Request) {
+	var req types.CreateInventoryAdjustmentRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	sessionId, _ := GetSessionId(r)
+
+	res, err := h.service.CreateInventoryAdjustment(sessionId, id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetInventoryAdjustments(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid book id"})
+		return
+	}
+
+	req, err := getPage(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid limit"})
+		return
+	}
+
+	res, err := h.service.GetInventoryAdjustments(id, req)
+	if errors.Is(err, service.ErrBadCursor) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid cursor"})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetLowStock(w http.ResponseWriter, r *http.Request) {
+	res, err := h.service.GetLowStock()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetAllPromotions(w http.ResponseWriter, r *http.Request) {
+	req, err := getPage(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid limit"})
+		return
+	}
+
+	res, err := h.service.GetAllPromotions(req)
+	if errors.Is(err, service.ErrBadCursor) {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid cursor"})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) GetPromotionById(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid promotion id"})
+		return
+	}
+
+	res, err := h.service.GetPromotionById(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	var req types.CreatePromotionRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	res, err := h.service.CreatePromotion(req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) UpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	var req types.UpdatePromotionRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid promotion id"})
+		return
+	}
+
+	err = h.service.UpdatePromotion(id, req)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+func (h *Handler) DeletePromotion(w http.ResponseWriter, r *http.Request) {
+	id, err := getID(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, types.ErrorResponse{Message: "invalid promotion id"})
+		return
+	}
+
+	err = h.service.DeletePromotion(id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, types.ErrorResponse{Message: err.Error()})
+		return
+	}
+}
+
+// GetSessionId returns the session id from the sessionId cookie or, for
+// scripts, an API key or access token from the "Authorization: Bearer"
+// header.
+func GetSessionId(r *http.Request) (string, error) {
+	if cookie, err := r.Cookie("sessionId"); err == nil {
+		return cookie.Value, nil
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		return token, nil
+	}
+
+	return "", http.ErrNoCookie
+}
+
+func getID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func getPage(r *http.Request) (types.PageRequest, error) {
+	req := types.PageRequest{
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return types.PageRequest{}, err
+		}
+
+		req.Limit = n
+	}
+
+	return req, nil
+}
+
+func getBookFilter(r *http.Request) (types.BookFilter, error) {
+	var f types.BookFilter
+	var err error
+	q := r.URL.Query()
+
+	f.AuthorIds, err = getIDs(q["author_id"])
+	if err != nil {
+		return f, errors.New("invalid author_id")
+	}
+
+	f.GenreIds, err = getIDs(q["genre_id"])
+	if err != nil {
+		return f, errors.New("invalid genre_id")
+	}
+
+	// filter=author_id|genre_id&id=N is the original single filter form.
+	if filter := q.Get("filter"); filter == "author_id" || filter == "genre_id" {
+		id, err := strconv.Atoi(q.Get("id"))
+		if err != nil {
+			return f, errors.New("bad " + strings.TrimSuffix(filter, "_id") + " id")
+		}
+
+		if filter == "author_id" {
+			f.AuthorIds = append(f.AuthorIds, id)
+		} else {
+			f.GenreIds = append(f.GenreIds, id)
+		}
+	}
+
+	for name, dst := range map[string]**time.Time{
+		"created_after":  &f.CreatedAfter,
+		"created_before": &f.CreatedBefore,
+		"updated_after":  &f.UpdatedAfter,
+		"updated_before": &f.UpdatedBefore,
+	} {
+		value := q.Get(name)
+		if value == "" {
+			continue
+		}
+
+		t, err := parseTime(value)
+		if err != nil {
+			return f, errors.New("invalid " + name)
+		}
+		*dst = &t
+	}
+
+	if value := q.Get("has_file"); value != "" {
+		hasFile, err := strconv.ParseBool(value)
+		if err != nil {
+			return f, errors.New("invalid has_file")
+		}
+		f.HasFile = &hasFile
+	}
+
+	f.ISBNPrefix = q.Get("isbn")
+	f.TitleContains = q.Get("title")
+
+	for name, dst := range map[string]**int64{
+		"min_price": &f.MinPrice,
+		"max_price": &f.MaxPrice,
+	} {
+		value := q.Get(name)
+		if value == "" {
+			continue
+		}
+
+		price, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return f, errors.New("invalid " + name)
+		}
+		*dst = &price
+	}
+
+	f.Currency = q.Get("currency")
+
+	return f, nil
+}
+
+// getIDs accepts both repeated (?id=1&id=2) and comma separated (?id=1,2) values.
+func getIDs(values []string) ([]int, error) {
+	var ids []int
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part == "" {
+				continue
+			}
+
+			id, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, err
+			}
+
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// writeRetry answers 429 and tells the client when to try again if the
+// service knows.
+func writeRetry(w http.ResponseWriter, err error) {
+	var retry *service.RetryError
+	if errors.As(err, &retry) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.After.Seconds()))))
+	}
+
+	writeJSON(w, http.StatusTooManyRequests, types.ErrorResponse{Message: err.Error()})
 }
 
 func writeJSON(w http.ResponseWriter, statusCode int, data any) {
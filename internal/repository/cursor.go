@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// ErrBadCursor is returned by the paged lists for a cursor they did not hand
+// out.
+var ErrBadCursor = errors.New("bad cursor")
+
+// cursor points at the last row of a page. Value holds the sort column of
+// that row when the list is ordered by something other than id.
+type cursor struct {
+	ID    int    `json:"id"`
+	Value string `json:"v,omitempty"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+
+	return &c, nil
+}
+
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultLimit
+	}
+
+	if limit > maxLimit {
+		return maxLimit
+	}
+
+	return limit
+}
@@ -25,6 +25,210 @@ var (
 	//go:embed queries/update_user_by_id.sql
 	updateUserByIdQuery string
 
+	//go:embed queries/count_users.sql
+	countUsersQuery string
+
+	//sessions
+	//go:embed queries/create_session.sql
+	createSessionQuery string
+
+	//go:embed queries/get_session.sql
+	getSessionQuery string
+
+	//go:embed queries/get_user_sessions.sql
+	getUserSessionsQuery string
+
+	//go:embed queries/delete_session.sql
+	deleteSessionQuery string
+
+	//go:embed queries/delete_user_session.sql
+	deleteUserSessionQuery string
+
+	//go:embed queries/delete_other_sessions.sql
+	deleteOtherSessionsQuery string
+
+	//go:embed queries/delete_user_sessions.sql
+	deleteUserSessionsQuery string
+
+	//go:embed queries/delete_expired_sessions.sql
+	deleteExpiredSessionsQuery string
+
+	//go:embed queries/get_session_tokens.sql
+	getSessionTokensQuery string
+
+	//password resets
+	//go:embed queries/get_users_by_email.sql
+	getUsersByEmailQuery string
+
+	//go:embed queries/create_password_reset.sql
+	createPasswordResetQuery string
+
+	//go:embed queries/use_password_reset.sql
+	usePasswordResetQuery string
+
+	//go:embed queries/cancel_password_resets.sql
+	cancelPasswordResetsQuery string
+
+	//go:embed queries/update_user_password.sql
+	updateUserPasswordQuery string
+
+	//email verification
+	//go:embed queries/create_email_verification.sql
+	createEmailVerificationQuery string
+
+	//go:embed queries/count_email_verifications.sql
+	countEmailVerificationsQuery string
+
+	//go:embed queries/use_email_verification.sql
+	useEmailVerificationQuery string
+
+	//go:embed queries/verify_user_email.sql
+	verifyUserEmailQuery string
+
+	//go:embed queries/is_email_verified.sql
+	isEmailVerifiedQuery string
+
+	//two-factor authentication
+	//go:embed queries/create_login_challenge.sql
+	createLoginChallengeQuery string
+
+	//go:embed queries/get_login_challenge.sql
+	getLoginChallengeQuery string
+
+	//go:embed queries/fail_login_challenge.sql
+	failLoginChallengeQuery string
+
+	//go:embed queries/delete_login_challenge.sql
+	deleteLoginChallengeQuery string
+
+	//go:embed queries/get_two_factor.sql
+	getTwoFactorQuery string
+
+	//go:embed queries/set_two_factor_secret.sql
+	setTwoFactorSecretQuery string
+
+	//go:embed queries/enable_two_factor.sql
+	enableTwoFactorQuery string
+
+	//go:embed queries/disable_two_factor.sql
+	disableTwoFactorQuery string
+
+	//go:embed queries/use_two_factor_step.sql
+	useTwoFactorStepQuery string
+
+	//go:embed queries/delete_recovery_codes.sql
+	deleteRecoveryCodesQuery string
+
+	//go:embed queries/create_recovery_codes.sql
+	createRecoveryCodesQuery string
+
+	//go:embed queries/use_recovery_code.sql
+	useRecoveryCodeQuery string
+
+	//external identities
+	//go:embed queries/use_user_identity.sql
+	useUserIdentityQuery string
+
+	//go:embed queries/get_users_by_verified_email.sql
+	getUsersByVerifiedEmailQuery string
+
+	//go:embed queries/create_user_identity.sql
+	createUserIdentityQuery string
+
+	//go:embed queries/create_external_user.sql
+	createExternalUserQuery string
+
+	//go:embed queries/username_exists.sql
+	usernameExistsQuery string
+
+	//access and refresh tokens
+	//go:embed queries/get_signing_keys.sql
+	getSigningKeysQuery string
+
+	//go:embed queries/create_signing_key.sql
+	createSigningKeyQuery string
+
+	//go:embed queries/delete_expired_signing_keys.sql
+	deleteExpiredSigningKeysQuery string
+
+	//go:embed queries/create_refresh_token.sql
+	createRefreshTokenQuery string
+
+	//go:embed queries/get_refresh_token.sql
+	getRefreshTokenQuery string
+
+	//go:embed queries/use_refresh_token.sql
+	useRefreshTokenQuery string
+
+	//go:embed queries/delete_refresh_token_family.sql
+	deleteRefreshTokenFamilyQuery string
+
+	//go:embed queries/delete_family_refresh_tokens.sql
+	deleteFamilyRefreshTokensQuery string
+
+	//go:embed queries/delete_user_refresh_tokens.sql
+	deleteUserRefreshTokensQuery string
+
+	//go:embed queries/delete_expired_refresh_tokens.sql
+	deleteExpiredRefreshTokensQuery string
+
+	//go:embed queries/get_user_role_id.sql
+	getUserRoleIdQuery string
+
+	//api keys
+	//go:embed queries/create_api_key.sql
+	createAPIKeyQuery string
+
+	//go:embed queries/get_api_key.sql
+	getAPIKeyQuery string
+
+	//go:embed queries/get_api_key_scopes.sql
+	getAPIKeyScopesQuery string
+
+	//go:embed queries/get_user_api_keys.sql
+	getUserAPIKeysQuery string
+
+	//go:embed queries/delete_api_key.sql
+	deleteAPIKeyQuery string
+
+	//audit log
+	//go:embed queries/create_audit_entry.sql
+	createAuditEntryQuery string
+
+	//go:embed queries/get_audit_log.sql
+	getAuditLogQuery string
+
+	//go:embed queries/count_audit_log.sql
+	countAuditLogQuery string
+
+	//roles
+	//go:embed queries/get_all_permissions.sql
+	getAllPermissionsQuery string
+
+	//go:embed queries/get_all_roles.sql
+	getAllRolesQuery string
+
+	//go:embed queries/get_role_by_id.sql
+	getRoleByIdQuery string
+
+	//go:embed queries/get_role_permissions.sql
+	getRolePermissionsQuery string
+
+	//go:embed queries/create_role.sql
+	createRoleQuery string
+
+	//go:embed queries/update_role.sql
+	updateRoleQuery string
+
+	//go:embed queries/delete_role.sql
+	deleteRoleQuery string
+
+	//go:embed queries/delete_role_permissions.sql
+	deleteRolePermissionsQuery string
+
+	//go:embed queries/create_role_permissions.sql
+	createRolePermissionsQuery string
+
 	//books
 	//go:embed queries/get_all_books.sql
 	getAllBooksQuery string
@@ -41,6 +245,36 @@ var (
 	//go:embed queries/delete_book.sql
 	deleteBookQuery string
 
+	//go:embed queries/count_books.sql
+	countBooksQuery string
+
+	//go:embed queries/search_books.sql
+	searchBooksQuery string
+
+	//go:embed queries/get_book_authors.sql
+	getBookAuthorsQuery string
+
+	//go:embed queries/get_book_genres.sql
+	getBookGenresQuery string
+
+	//go:embed queries/create_book_author.sql
+	createBookAuthorQuery string
+
+	//go:embed queries/create_book_genre.sql
+	createBookGenreQuery string
+
+	//go:embed queries/delete_book_authors.sql
+	deleteBookAuthorsQuery string
+
+	//go:embed queries/delete_book_genres.sql
+	deleteBookGenresQuery string
+
+	//go:embed queries/get_book_prices.sql
+	getBookPricesQuery string
+
+	//go:embed queries/get_book_price_at.sql
+	getBookPriceAtQuery string
+
 	//authors
 	//go:embed queries/get_all_authors.sql
 	getAllAuthorsQuery string
@@ -54,6 +288,9 @@ var (
 	//go:embed queries/delete_author.sql
 	deleteAuthorQuery string
 
+	//go:embed queries/count_authors.sql
+	countAuthorsQuery string
+
 	//genres
 	//go:embed queries/get_all_genres.sql
 	getAllGenresQuery string
@@ -67,10 +304,190 @@ var (
 	//go:embed queries/delete_genre.sql
 	deleteGenreQuery string
 
+	//go:embed queries/count_genres.sql
+	countGenresQuery string
+
 	//files
-	//go:embed queries/get_filename.sql
-	getFilenameQuery string
+	//go:embed queries/get_book_file.sql
+	getBookFileQuery string
+
+	//go:embed queries/get_book_object_key.sql
+	getBookObjectKeyQuery string
 
 	//go:embed queries/update_filename.sql
 	updateFilenameQuery string
+
+	//go:embed queries/use_file.sql
+	useFileQuery string
+
+	//go:embed queries/acquire_file.sql
+	acquireFileQuery string
+
+	//go:embed queries/release_file.sql
+	releaseFileQuery string
+
+	//go:embed queries/delete_file.sql
+	deleteFileQuery string
+
+	//go:embed queries/get_file_metadata.sql
+	getFileMetadataQuery string
+
+	//go:embed queries/set_file_metadata.sql
+	setFileMetadataQuery string
+
+	//go:embed queries/fill_book_from_file.sql
+	fillBookFromFileQuery string
+
+	//resumable uploads
+	//go:embed queries/create_upload.sql
+	createUploadQuery string
+
+	//go:embed queries/get_upload.sql
+	getUploadQuery string
+
+	//go:embed queries/advance_upload.sql
+	advanceUploadQuery string
+
+	//go:embed queries/delete_upload.sql
+	deleteUploadQuery string
+
+	//go:embed queries/delete_stale_uploads.sql
+	deleteStaleUploadsQuery string
+
+	//go:embed queries/get_written_uploads.sql
+	getWrittenUploadsQuery string
+
+	//go:embed queries/upload_exists.sql
+	uploadExistsQuery string
+
+	//cart
+	//go:embed queries/get_cart_items.sql
+	getCartItemsQuery string
+
+	//go:embed queries/add_cart_item.sql
+	addCartItemQuery string
+
+	//go:embed queries/update_cart_item.sql
+	updateCartItemQuery string
+
+	//go:embed queries/delete_cart_item.sql
+	deleteCartItemQuery string
+
+	//go:embed queries/clear_cart.sql
+	clearCartQuery string
+
+	//go:embed queries/get_cart_items_for_update.sql
+	getCartItemsForUpdateQuery string
+
+	//orders
+	//go:embed queries/create_order.sql
+	createOrderQuery string
+
+	//go:embed queries/create_order_item.sql
+	createOrderItemQuery string
+
+	//go:embed queries/get_all_orders.sql
+	getAllOrdersQuery string
+
+	//go:embed queries/count_orders.sql
+	countOrdersQuery string
+
+	//go:embed queries/get_order_by_id.sql
+	getOrderByIdQuery string
+
+	//go:embed queries/get_order_items.sql
+	getOrderItemsQuery string
+
+	//go:embed queries/update_order_status.sql
+	updateOrderStatusQuery string
+
+	//inventory
+	//go:embed queries/get_inventory.sql
+	getInventoryQuery string
+
+	//go:embed queries/update_inventory.sql
+	updateInventoryQuery string
+
+	//go:embed queries/adjust_stock.sql
+	adjustStockQuery string
+
+	//go:embed queries/create_inventory_adjustment.sql
+	createInventoryAdjustmentQuery string
+
+	//go:embed queries/get_inventory_adjustments.sql
+	getInventoryAdjustmentsQuery string
+
+	//go:embed queries/count_inventory_adjustments.sql
+	countInventoryAdjustmentsQuery string
+
+	//go:embed queries/get_low_stock.sql
+	getLowStockQuery string
+
+	//go:embed queries/is_stocked.sql
+	isStockedQuery string
+
+	//go:embed queries/reserve_stock.sql
+	reserveStockQuery string
+
+	//go:embed queries/release_stock.sql
+	releaseStockQuery string
+
+	//go:embed queries/sell_stock.sql
+	sellStockQuery string
+
+	//payments
+	//go:embed queries/save_payment.sql
+	savePaymentQuery string
+
+	//go:embed queries/get_payment_by_order_id.sql
+	getPaymentByOrderIdQuery string
+
+	//go:embed queries/create_payment_event.sql
+	createPaymentEventQuery string
+
+	//go:embed queries/update_payment_status.sql
+	updatePaymentStatusQuery string
+
+	//promotions
+	//go:embed queries/get_all_promotions.sql
+	getAllPromotionsQuery string
+
+	//go:embed queries/count_promotions.sql
+	countPromotionsQuery string
+
+	//go:embed queries/get_promotion_by_id.sql
+	getPromotionByIdQuery string
+
+	//go:embed queries/create_promotion.sql
+	createPromotionQuery string
+
+	//go:embed queries/update_promotion.sql
+	updatePromotionQuery string
+
+	//go:embed queries/delete_promotion.sql
+	deletePromotionQuery string
+
+	//go:embed queries/get_active_promotions.sql
+	getActivePromotionsQuery string
+
+	//go:embed queries/lock_promotion_usage.sql
+	lockPromotionUsageQuery string
+
+	//go:embed queries/create_promotion_redemption.sql
+	createPromotionRedemptionQuery string
+
+	//go:embed queries/create_order_item_discount.sql
+	createOrderItemDiscountQuery string
+
+	//go:embed queries/get_order_item_discounts.sql
+	getOrderItemDiscountsQuery string
+
+	//go:embed queries/set_cart_coupon.sql
+	setCartCouponQuery string
+
+	//go:embed queries/get_cart_coupon.sql
+	getCartCouponQuery string
+
+	//go:embed queries/delete_cart_coupon.sql
+	deleteCartCouponQuery string
 )
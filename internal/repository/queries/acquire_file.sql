@@ -0,0 +1,4 @@
+insert into files (object_key, size, ref_count, created_at)
+values ($1, $2, 1, $3)
+on conflict (object_key) do update
+set ref_count = files.ref_count + 1
\ No newline at end of file
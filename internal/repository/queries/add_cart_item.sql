@@ -0,0 +1,17 @@
+INSERT INTO cart_items (user_id,
+                        book_id,
+                        quantity,
+                        unit_price,
+                        created_at,
+                        updated_at)
+SELECT $1,
+       b.id,
+       $3,
+       book_effective_price(b.price, b.sale_price, b.sale_starts_at, b.sale_ends_at, $4),
+       $4,
+       $4
+FROM books b
+WHERE b.id = $2
+ON CONFLICT (user_id, book_id) DO UPDATE
+SET quantity = cart_items.quantity + EXCLUDED.quantity,
+    updated_at = EXCLUDED.updated_at
@@ -0,0 +1,7 @@
+INSERT INTO book_inventory (book_id,
+                            on_hand,
+                            updated_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (book_id) DO UPDATE
+SET on_hand = book_inventory.on_hand + EXCLUDED.on_hand,
+    updated_at = EXCLUDED.updated_at
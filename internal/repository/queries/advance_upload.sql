@@ -0,0 +1,7 @@
+update uploads
+set upload_offset = upload_offset + $3,
+    parts = parts + 1,
+    hash_state = $5,
+    updated_at = $4
+where id = $1
+  and upload_offset = $2
\ No newline at end of file
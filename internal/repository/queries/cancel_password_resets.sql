@@ -0,0 +1,4 @@
+UPDATE password_resets
+SET used_at = $2
+WHERE user_id = $1
+  AND used_at IS NULL
\ No newline at end of file
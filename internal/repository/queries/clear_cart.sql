@@ -0,0 +1,2 @@
+DELETE FROM cart_items
+WHERE user_id = $1
\ No newline at end of file
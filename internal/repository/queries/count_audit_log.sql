@@ -0,0 +1,2 @@
+SELECT count(*)
+FROM audit_log
\ No newline at end of file
@@ -0,0 +1,2 @@
+SELECT count(*)
+FROM authors
\ No newline at end of file
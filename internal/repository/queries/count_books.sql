@@ -0,0 +1,2 @@
+SELECT count(*)
+FROM books b
\ No newline at end of file
@@ -0,0 +1,5 @@
+SELECT count(*),
+       max(created_at)
+FROM email_verifications
+WHERE user_id = $1
+  AND created_at > $2
\ No newline at end of file
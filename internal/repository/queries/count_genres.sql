@@ -0,0 +1,2 @@
+SELECT count(*)
+FROM genres
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT count(*)
+FROM inventory_adjustments
+WHERE book_id = $1
@@ -0,0 +1,2 @@
+SELECT count(*)
+FROM orders o
\ No newline at end of file
@@ -0,0 +1,2 @@
+SELECT count(*)
+FROM users
\ No newline at end of file
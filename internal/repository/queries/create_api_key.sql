@@ -0,0 +1,3 @@
+INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id
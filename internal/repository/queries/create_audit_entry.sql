@@ -0,0 +1,3 @@
+INSERT INTO audit_log (action, user_id, actor_id, username, ip, details, created_at)
+VALUES ($1, nullif($2, 0), nullif($3, 0), $4, $5, $6, $7)
+RETURNING id
\ No newline at end of file
@@ -1,10 +1,13 @@
-insert into books (author_id,
-                   genre_id,
-                   title,
+insert into books (title,
                    isbn,
                    filename,
                    description,
+                   price,
+                   currency,
+                   sale_price,
+                   sale_starts_at,
+                   sale_ends_at,
                    created_at,
                    updated_at)
-values($1, $2, $3, $4, $5, $6, $7, $8)
+values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 returning id
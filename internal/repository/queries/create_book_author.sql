@@ -0,0 +1,2 @@
+INSERT INTO book_authors (book_id, author_id, role, position)
+VALUES ($1, $2, $3, $4)
\ No newline at end of file
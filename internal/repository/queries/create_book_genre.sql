@@ -0,0 +1,2 @@
+INSERT INTO book_genres (book_id, genre_id, position)
+VALUES ($1, $2, $3)
\ No newline at end of file
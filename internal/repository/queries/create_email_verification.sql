@@ -0,0 +1,8 @@
+INSERT INTO email_verifications (user_id, email, token_hash, created_at, expires_at)
+SELECT id, email, $2, $3, $4
+FROM users
+WHERE id = $1
+  AND email IS NOT NULL
+  AND email <> ''
+  AND email_verified_at IS NULL
+RETURNING email
\ No newline at end of file
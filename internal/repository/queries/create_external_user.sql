@@ -0,0 +1,9 @@
+INSERT INTO users (role_id,
+                   username,
+                   password,
+                   email,
+                   email_verified_at)
+SELECT r.id, $2, $3, nullif($4, ''), $5
+FROM roles r
+WHERE r.name = $1
+RETURNING id
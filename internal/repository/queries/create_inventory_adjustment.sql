@@ -0,0 +1,8 @@
+INSERT INTO inventory_adjustments (book_id,
+                                   quantity,
+                                   reason,
+                                   note,
+                                   user_id,
+                                   created_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id
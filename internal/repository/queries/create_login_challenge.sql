@@ -0,0 +1,2 @@
+INSERT INTO login_challenges (token, user_id, created_at, expires_at)
+VALUES ($1, $2, $3, $4)
\ No newline at end of file
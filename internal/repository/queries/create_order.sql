@@ -0,0 +1,11 @@
+INSERT INTO orders (user_id,
+                    status,
+                    subtotal,
+                    discount,
+                    total,
+                    currency,
+                    coupon_code,
+                    created_at,
+                    updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, nullif($7, ''), $8, $8)
+RETURNING id
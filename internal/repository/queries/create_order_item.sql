@@ -0,0 +1,8 @@
+INSERT INTO order_items (order_id,
+                         book_id,
+                         title,
+                         quantity,
+                         unit_price,
+                         reserved)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id
\ No newline at end of file
@@ -0,0 +1,5 @@
+INSERT INTO order_item_discounts (order_item_id,
+                                  promotion_id,
+                                  description,
+                                  amount)
+VALUES ($1, $2, $3, $4)
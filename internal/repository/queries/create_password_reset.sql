@@ -0,0 +1,2 @@
+INSERT INTO password_resets (user_id, token_hash, created_at, expires_at)
+VALUES ($1, $2, $3, $4)
\ No newline at end of file
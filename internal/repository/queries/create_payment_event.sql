@@ -0,0 +1,7 @@
+INSERT INTO payment_events (provider,
+                            event_id,
+                            type,
+                            provider_payment_id,
+                            received_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (provider, event_id) DO NOTHING
@@ -0,0 +1,17 @@
+INSERT INTO promotions (name,
+                        code,
+                        kind,
+                        value,
+                        buy_quantity,
+                        get_quantity,
+                        book_id,
+                        genre_id,
+                        starts_at,
+                        ends_at,
+                        usage_limit,
+                        per_user_limit,
+                        active,
+                        created_at,
+                        updated_at)
+VALUES ($1, nullif($2, ''), $3, $4, $5, $6, nullif($7, 0), nullif($8, 0), $9, $10, $11, $12, $13, $14, $14)
+RETURNING id
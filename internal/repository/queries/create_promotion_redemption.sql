@@ -0,0 +1,6 @@
+INSERT INTO promotion_redemptions (promotion_id,
+                                   order_id,
+                                   user_id,
+                                   amount,
+                                   created_at)
+VALUES ($1, $2, $3, $4, $5)
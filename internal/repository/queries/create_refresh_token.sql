@@ -0,0 +1,2 @@
+INSERT INTO refresh_tokens (user_id, family, token_hash, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5)
@@ -0,0 +1,3 @@
+INSERT INTO roles (name)
+VALUES ($1)
+RETURNING id
\ No newline at end of file
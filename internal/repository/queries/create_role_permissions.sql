@@ -0,0 +1,2 @@
+INSERT INTO role_permissions (role_id, permission)
+SELECT $1, unnest($2::text[])
\ No newline at end of file
@@ -0,0 +1,3 @@
+INSERT INTO sessions (token, user_id, user_agent, ip, idle_timeout, created_at, last_seen_at, idle_expires_at, expires_at)
+VALUES ($1, $2, $3, $4, make_interval(secs => $5), $6, $6, $6 + make_interval(secs => $5), $7)
+RETURNING expires_at
\ No newline at end of file
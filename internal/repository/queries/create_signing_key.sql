@@ -0,0 +1,2 @@
+INSERT INTO signing_keys (kid, private_key, created_at, expires_at)
+VALUES ($1, $2, $3, $4)
@@ -0,0 +1,11 @@
+insert into uploads (id,
+                     book_id,
+                     filename,
+                     object_key,
+                     storage_upload_id,
+                     length,
+                     upload_offset,
+                     parts,
+                     created_at,
+                     updated_at)
+values ($1, $2, $3, $4, $5, $6, 0, 0, $7, $7)
\ No newline at end of file
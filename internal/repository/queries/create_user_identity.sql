@@ -0,0 +1,2 @@
+INSERT INTO user_identities (user_id, issuer, subject, email, created_at, last_login_at)
+VALUES ($1, $2, $3, $4, $5, $5)
@@ -0,0 +1,2 @@
+DELETE FROM book_genres
+WHERE book_id = $1
\ No newline at end of file
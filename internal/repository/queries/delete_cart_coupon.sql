@@ -0,0 +1,2 @@
+DELETE FROM cart_coupons
+WHERE user_id = $1
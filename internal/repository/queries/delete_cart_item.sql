@@ -0,0 +1,3 @@
+DELETE FROM cart_items
+WHERE user_id = $1
+  AND book_id = $2
\ No newline at end of file
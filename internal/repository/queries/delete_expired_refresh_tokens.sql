@@ -0,0 +1,3 @@
+DELETE FROM refresh_tokens
+WHERE user_id = $1
+  AND expires_at <= $2
@@ -0,0 +1,2 @@
+DELETE FROM signing_keys
+WHERE expires_at <= $1
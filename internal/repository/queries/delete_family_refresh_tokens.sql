@@ -0,0 +1,2 @@
+DELETE FROM refresh_tokens
+WHERE family = $1
@@ -0,0 +1,3 @@
+delete from files
+where object_key = $1
+  and ref_count <= 0
\ No newline at end of file
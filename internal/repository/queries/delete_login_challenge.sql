@@ -0,0 +1,2 @@
+DELETE FROM login_challenges
+WHERE token = $1
\ No newline at end of file
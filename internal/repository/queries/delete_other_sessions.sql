@@ -0,0 +1,3 @@
+DELETE FROM sessions
+WHERE user_id = $1
+  AND token <> $2
\ No newline at end of file
@@ -0,0 +1,2 @@
+DELETE FROM promotions
+WHERE id = $1
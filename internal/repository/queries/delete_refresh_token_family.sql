@@ -0,0 +1,4 @@
+DELETE FROM refresh_tokens
+WHERE family = (SELECT family
+                FROM refresh_tokens
+                WHERE token_hash = $1)
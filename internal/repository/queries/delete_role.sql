@@ -0,0 +1,3 @@
+DELETE FROM roles
+WHERE id = $1
+  AND NOT EXISTS (SELECT 1 FROM users WHERE role_id = $1)
\ No newline at end of file
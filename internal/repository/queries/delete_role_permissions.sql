@@ -0,0 +1,2 @@
+DELETE FROM role_permissions
+WHERE role_id = $1
\ No newline at end of file
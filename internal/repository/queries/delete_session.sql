@@ -0,0 +1,2 @@
+DELETE FROM sessions
+WHERE token = $1
\ No newline at end of file
@@ -0,0 +1,13 @@
+delete from uploads
+where updated_at < $1
+returning id,
+          book_id,
+          filename,
+          object_key,
+          storage_upload_id,
+          length,
+          upload_offset,
+          parts,
+          created_at,
+          updated_at,
+          hash_state
\ No newline at end of file
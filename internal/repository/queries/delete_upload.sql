@@ -0,0 +1,2 @@
+delete from uploads
+where id = $1
\ No newline at end of file
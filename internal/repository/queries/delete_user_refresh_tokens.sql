@@ -0,0 +1,2 @@
+DELETE FROM refresh_tokens
+WHERE user_id = $1
@@ -0,0 +1,3 @@
+DELETE FROM sessions
+WHERE user_id = $1
+RETURNING token
\ No newline at end of file
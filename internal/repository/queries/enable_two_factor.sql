@@ -0,0 +1,6 @@
+UPDATE users
+SET totp_enabled_at = $2,
+    totp_last_step = $3
+WHERE id = $1
+  AND totp_secret IS NOT NULL
+  AND totp_enabled_at IS NULL
\ No newline at end of file
@@ -0,0 +1,3 @@
+UPDATE login_challenges
+SET attempts = attempts + 1
+WHERE token = $1
\ No newline at end of file
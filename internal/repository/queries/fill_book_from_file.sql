@@ -0,0 +1,9 @@
+update books b
+set title = coalesce(nullif(b.title, ''), nullif(f.title, ''), b.title),
+    isbn = coalesce(nullif(b.isbn, ''), nullif(f.isbn, ''), b.isbn),
+    description = coalesce(nullif(b.description, ''), nullif(f.description, ''), b.description),
+    updated_at = $2
+from files f
+where b.id = $1
+  and f.object_key = b.object_key
+  and f.metadata_at is not null
\ No newline at end of file
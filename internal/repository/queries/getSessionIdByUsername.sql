@@ -1,6 +1,7 @@
 SELECT u.id,
        u.password,
-       r.name
+       r.name,
+       u.totp_enabled_at IS NOT NULL
 FROM users u
 JOIN roles r ON r.id = u.role_id
 WHERE u.username = $1
\ No newline at end of file
@@ -0,0 +1,27 @@
+SELECT p.id,
+       p.name,
+       coalesce(p.code, ''),
+       p.kind,
+       p.value,
+       p.buy_quantity,
+       p.get_quantity,
+       coalesce(p.book_id, 0),
+       coalesce(p.genre_id, 0),
+       p.starts_at,
+       p.ends_at,
+       p.usage_limit,
+       p.per_user_limit,
+       p.active,
+       p.created_at,
+       p.updated_at,
+       count(o.id),
+       count(o.id) FILTER (WHERE pr.user_id = $1)
+FROM promotions p
+LEFT JOIN promotion_redemptions pr ON pr.promotion_id = p.id
+LEFT JOIN orders o ON o.id = pr.order_id AND o.status <> 'cancelled'
+WHERE p.active
+  AND (p.code IS NULL OR p.code = $2)
+  AND (p.starts_at IS NULL OR p.starts_at <= $3)
+  AND (p.ends_at IS NULL OR p.ends_at > $3)
+GROUP BY p.id
+ORDER BY p.code IS NOT NULL, p.id
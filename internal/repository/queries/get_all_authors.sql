@@ -1,2 +1,5 @@
 SELECT id, name
-FROM authors
\ No newline at end of file
+FROM authors
+WHERE id > $1
+ORDER BY id
+LIMIT $2
\ No newline at end of file
@@ -1,14 +1,14 @@
 SELECT b.id,
        b.title,
-       a.id,
-       a.name,
-       g.id,
-       g.name,
        b.isbn,
        b.filename,
        b.description,
        b.created_at,
-       b.updated_at
+       b.updated_at,
+       b.price,
+       b.currency,
+       b.sale_price,
+       b.sale_starts_at,
+       b.sale_ends_at,
+       book_effective_price(b.price, b.sale_price, b.sale_starts_at, b.sale_ends_at, localtimestamp)
 FROM books b
-JOIN authors a ON b.author_id = a.id
-JOIN genres g ON b.genre_id = g.id
\ No newline at end of file
@@ -1,2 +1,5 @@
 SELECT id, name
-FROM genres
\ No newline at end of file
+FROM genres
+WHERE id > $1
+ORDER BY id
+LIMIT $2
\ No newline at end of file
@@ -0,0 +1,11 @@
+SELECT o.id,
+       coalesce(o.user_id, 0),
+       o.status,
+       o.subtotal,
+       o.discount,
+       o.total,
+       coalesce(o.coupon_code, ''),
+       o.currency,
+       o.created_at,
+       o.updated_at
+FROM orders o
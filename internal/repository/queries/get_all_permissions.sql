@@ -0,0 +1,4 @@
+SELECT name,
+       description
+FROM permissions
+ORDER BY name
\ No newline at end of file
@@ -3,6 +3,10 @@ SELECT u.id,
        u.username,
        u.password,
        u.email,
-       u.phone
+       u.phone,
+       u.email_verified_at IS NOT NULL
 FROM users u
 JOIN roles r ON r.id = u.role_id
+WHERE u.id > $1
+ORDER BY u.id
+LIMIT $2
\ No newline at end of file
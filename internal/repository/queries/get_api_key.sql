@@ -0,0 +1,15 @@
+WITH k AS (
+    SELECT id, user_id
+    FROM api_keys
+    WHERE key_hash = $1
+      AND (expires_at IS NULL OR expires_at > $2)
+), used AS (
+    UPDATE api_keys
+    SET last_used_at = $2
+    WHERE id = (SELECT id FROM k)
+      AND (last_used_at IS NULL OR last_used_at < $2 - interval '1 minute')
+)
+SELECT u.id,
+       u.role_id
+FROM k
+JOIN users u ON u.id = k.user_id
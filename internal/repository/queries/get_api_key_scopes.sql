@@ -0,0 +1,4 @@
+SELECT scopes
+FROM api_keys
+WHERE key_hash = $1
+  AND (expires_at IS NULL OR expires_at > $2)
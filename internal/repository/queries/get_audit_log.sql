@@ -0,0 +1,10 @@
+SELECT a.id,
+       a.action,
+       coalesce(a.user_id, 0),
+       coalesce(a.actor_id, 0),
+       a.username,
+       a.ip,
+       a.details,
+       a.created_at
+FROM audit_log a
+WHERE true
\ No newline at end of file
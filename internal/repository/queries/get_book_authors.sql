@@ -0,0 +1,8 @@
+SELECT ba.book_id,
+       a.id,
+       a.name,
+       ba.role
+FROM book_authors ba
+JOIN authors a ON a.id = ba.author_id
+WHERE ba.book_id = ANY($1)
+ORDER BY ba.book_id, ba.position
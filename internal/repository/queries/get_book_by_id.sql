@@ -1,15 +1,15 @@
 SELECT books.id,
        books.title,
-       authors.id,
-       authors.name,
-       genres.id,
-       genres.name,
        books.isbn,
        books.filename,
        books.description,
        books.created_at,
-       books.updated_at
+       books.updated_at,
+       books.price,
+       books.currency,
+       books.sale_price,
+       books.sale_starts_at,
+       books.sale_ends_at,
+       book_effective_price(books.price, books.sale_price, books.sale_starts_at, books.sale_ends_at, localtimestamp)
 FROM books
-JOIN authors ON books.author_id = authors.id
-JOIN genres ON books.genre_id = genres.id
 WHERE books.id = $1
\ No newline at end of file
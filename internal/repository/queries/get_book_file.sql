@@ -0,0 +1,6 @@
+select b.filename,
+       b.object_key,
+       f.size
+from books b
+left join files f on f.object_key = b.object_key
+where b.id = $1
\ No newline at end of file
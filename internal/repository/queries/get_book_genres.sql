@@ -0,0 +1,7 @@
+SELECT bg.book_id,
+       g.id,
+       g.name
+FROM book_genres bg
+JOIN genres g ON g.id = bg.genre_id
+WHERE bg.book_id = ANY($1)
+ORDER BY bg.book_id, bg.position
@@ -0,0 +1,4 @@
+select object_key
+from books
+where id = $1
+for update
\ No newline at end of file
@@ -0,0 +1,12 @@
+SELECT price,
+       currency,
+       sale_price,
+       sale_starts_at,
+       sale_ends_at,
+       valid_from,
+       book_effective_price(price, sale_price, sale_starts_at, sale_ends_at, $2)
+FROM price_history
+WHERE book_id = $1
+  AND valid_from <= $2
+ORDER BY valid_from DESC, id DESC
+LIMIT 1
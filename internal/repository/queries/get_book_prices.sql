@@ -0,0 +1,9 @@
+SELECT price,
+       currency,
+       sale_price,
+       sale_starts_at,
+       sale_ends_at,
+       valid_from
+FROM price_history
+WHERE book_id = $1
+ORDER BY valid_from DESC, id DESC
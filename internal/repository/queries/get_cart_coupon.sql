@@ -0,0 +1,3 @@
+SELECT code
+FROM cart_coupons
+WHERE user_id = $1
@@ -0,0 +1,8 @@
+SELECT ci.book_id,
+       b.title,
+       ci.quantity,
+       ci.unit_price
+FROM cart_items ci
+JOIN books b ON b.id = ci.book_id
+WHERE ci.user_id = $1
+ORDER BY ci.created_at, ci.book_id
@@ -0,0 +1,10 @@
+SELECT ci.book_id,
+       b.title,
+       ci.quantity,
+       ci.unit_price,
+       b.currency
+FROM cart_items ci
+JOIN books b ON b.id = ci.book_id
+WHERE ci.user_id = $1
+ORDER BY ci.created_at, ci.book_id
+FOR UPDATE OF ci
@@ -0,0 +1,8 @@
+select coalesce(title, ''),
+       coalesce(authors, '{}'),
+       coalesce(isbn, ''),
+       coalesce(language, ''),
+       coalesce(description, ''),
+       metadata_at
+from files
+where object_key = $1
\ No newline at end of file
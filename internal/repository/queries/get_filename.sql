@@ -1,3 +0,0 @@
-select filename
-from books
-where id = $1
\ No newline at end of file
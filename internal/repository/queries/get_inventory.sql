@@ -0,0 +1,9 @@
+SELECT b.id,
+       b.title,
+       bi.book_id IS NOT NULL,
+       coalesce(bi.on_hand, 0),
+       coalesce(bi.reserved, 0),
+       coalesce(bi.low_stock_threshold, 0)
+FROM books b
+LEFT JOIN book_inventory bi ON bi.book_id = b.id
+WHERE b.id = $1
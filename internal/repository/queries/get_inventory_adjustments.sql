@@ -0,0 +1,10 @@
+SELECT ia.id,
+       ia.book_id,
+       ia.quantity,
+       ia.reason,
+       coalesce(ia.note, ''),
+       coalesce(ia.order_id, 0),
+       coalesce(ia.user_id, 0),
+       ia.created_at
+FROM inventory_adjustments ia
+WHERE ia.book_id = $1
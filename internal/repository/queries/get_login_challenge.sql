@@ -0,0 +1,5 @@
+SELECT user_id
+FROM login_challenges
+WHERE token = $1
+  AND expires_at > $2
+  AND attempts < $3
\ No newline at end of file
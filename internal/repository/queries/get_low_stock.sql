@@ -0,0 +1,10 @@
+SELECT b.id,
+       b.title,
+       true,
+       bi.on_hand,
+       bi.reserved,
+       bi.low_stock_threshold
+FROM book_inventory bi
+JOIN books b ON b.id = bi.book_id
+WHERE bi.on_hand - bi.reserved <= bi.low_stock_threshold
+ORDER BY bi.on_hand - bi.reserved, b.id
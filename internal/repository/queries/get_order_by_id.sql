@@ -0,0 +1,12 @@
+SELECT id,
+       coalesce(user_id, 0),
+       status,
+       subtotal,
+       discount,
+       total,
+       coalesce(coupon_code, ''),
+       currency,
+       created_at,
+       updated_at
+FROM orders
+WHERE id = $1
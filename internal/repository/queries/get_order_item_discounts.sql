@@ -0,0 +1,7 @@
+SELECT oid.order_item_id,
+       coalesce(oid.promotion_id, 0),
+       oid.description,
+       oid.amount
+FROM order_item_discounts oid
+WHERE oid.order_item_id = ANY($1)
+ORDER BY oid.order_item_id, oid.id
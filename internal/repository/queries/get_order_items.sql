@@ -0,0 +1,9 @@
+SELECT id,
+       order_id,
+       coalesce(book_id, 0),
+       title,
+       quantity,
+       unit_price
+FROM order_items
+WHERE order_id = ANY($1)
+ORDER BY order_id, id
@@ -0,0 +1,10 @@
+SELECT order_id,
+       provider,
+       provider_payment_id,
+       status,
+       amount,
+       currency
+FROM payments
+WHERE order_id = $1
+ORDER BY id DESC
+LIMIT 1
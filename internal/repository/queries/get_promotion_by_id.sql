@@ -0,0 +1,18 @@
+SELECT p.id,
+       p.name,
+       coalesce(p.code, ''),
+       p.kind,
+       p.value,
+       p.buy_quantity,
+       p.get_quantity,
+       coalesce(p.book_id, 0),
+       coalesce(p.genre_id, 0),
+       p.starts_at,
+       p.ends_at,
+       p.usage_limit,
+       p.per_user_limit,
+       p.active,
+       p.created_at,
+       p.updated_at
+FROM promotions p
+WHERE p.id = $1
@@ -0,0 +1,8 @@
+SELECT id,
+       user_id,
+       family,
+       used_at IS NOT NULL,
+       expires_at > $2
+FROM refresh_tokens
+WHERE token_hash = $1
+FOR UPDATE
@@ -0,0 +1,7 @@
+SELECT r.id,
+       r.name,
+       coalesce(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+FROM roles r
+LEFT JOIN role_permissions rp ON rp.role_id = r.id
+WHERE r.id = $1
+GROUP BY r.id
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT permission
+FROM role_permissions
+WHERE role_id = $1
\ No newline at end of file
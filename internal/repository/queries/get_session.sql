@@ -0,0 +1,17 @@
+WITH s AS (
+    SELECT id, user_id
+    FROM sessions
+    WHERE token = $1
+      AND idle_expires_at > $2
+      AND expires_at > $2
+), renewed AS (
+    UPDATE sessions
+    SET last_seen_at = $2,
+        idle_expires_at = least($2 + idle_timeout, expires_at)
+    WHERE id = (SELECT id FROM s)
+      AND last_seen_at < $2 - interval '1 minute'
+)
+SELECT u.id,
+       u.role_id
+FROM s
+JOIN users u ON u.id = s.user_id
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT token
+FROM sessions
+WHERE user_id = $1
\ No newline at end of file
@@ -0,0 +1,7 @@
+SELECT kid,
+       private_key,
+       created_at,
+       expires_at
+FROM signing_keys
+WHERE expires_at > $1
+ORDER BY created_at DESC
@@ -0,0 +1,7 @@
+SELECT coalesce(u.totp_secret, ''),
+       u.totp_enabled_at IS NOT NULL,
+       u.totp_last_step,
+       coalesce(u.email, ''),
+       u.username
+FROM users u
+WHERE u.id = $1
\ No newline at end of file
@@ -0,0 +1,10 @@
+SELECT id,
+       name,
+       prefix,
+       scopes,
+       created_at,
+       expires_at,
+       last_used_at
+FROM api_keys
+WHERE user_id = $1
+ORDER BY id
@@ -3,7 +3,8 @@ SELECT u.id,
        u.password,
        u.email,
        u.phone,
-       r.name
+       r.name,
+       u.email_verified_at IS NOT NULL
 FROM users u
 JOIN roles r ON r.id = u.role_id
 WHERE u.id = $1
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT role_id
+FROM users
+WHERE id = $1
@@ -0,0 +1,12 @@
+SELECT id,
+       user_agent,
+       ip,
+       created_at,
+       last_seen_at,
+       least(idle_expires_at, expires_at),
+       token = $2
+FROM sessions
+WHERE user_id = $1
+  AND idle_expires_at > $3
+  AND expires_at > $3
+ORDER BY last_seen_at DESC
\ No newline at end of file
@@ -0,0 +1,5 @@
+SELECT id,
+       username,
+       email
+FROM users
+WHERE lower(email) = lower($1)
\ No newline at end of file
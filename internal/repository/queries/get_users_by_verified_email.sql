@@ -0,0 +1,9 @@
+SELECT u.id,
+       u.totp_enabled_at IS NOT NULL OR exists(SELECT 1
+                                               FROM role_permissions rp
+                                               WHERE rp.role_id = u.role_id
+                                                 AND rp.permission IN ('users:write', 'roles:write'))
+FROM users u
+WHERE lower(u.email) = lower($1)
+  AND u.email_verified_at IS NOT NULL
+LIMIT 2
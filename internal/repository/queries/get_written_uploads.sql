@@ -0,0 +1,13 @@
+select id,
+       book_id,
+       filename,
+       object_key,
+       storage_upload_id,
+       length,
+       upload_offset,
+       parts,
+       created_at,
+       updated_at,
+       hash_state
+from uploads
+where upload_offset = length
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT email_verified_at IS NOT NULL
+FROM users
+WHERE id = $1
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT EXISTS (SELECT 1
+               FROM book_inventory
+               WHERE book_id = $1)
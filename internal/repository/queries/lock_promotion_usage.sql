@@ -0,0 +1,16 @@
+SELECT p.usage_limit,
+       p.per_user_limit,
+       (SELECT count(*)
+        FROM promotion_redemptions pr
+        JOIN orders o ON o.id = pr.order_id
+        WHERE pr.promotion_id = p.id
+          AND o.status <> 'cancelled'),
+       (SELECT count(*)
+        FROM promotion_redemptions pr
+        JOIN orders o ON o.id = pr.order_id
+        WHERE pr.promotion_id = p.id
+          AND pr.user_id = $2
+          AND o.status <> 'cancelled')
+FROM promotions p
+WHERE p.id = $1
+FOR UPDATE OF p
@@ -0,0 +1,4 @@
+update files
+set ref_count = ref_count - 1
+where object_key = $1
+returning ref_count
\ No newline at end of file
@@ -0,0 +1,7 @@
+UPDATE book_inventory bi
+SET reserved = bi.reserved - oi.reserved,
+    updated_at = $2
+FROM order_items oi
+WHERE oi.order_id = $1
+  AND oi.reserved > 0
+  AND bi.book_id = oi.book_id
\ No newline at end of file
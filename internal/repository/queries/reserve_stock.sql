@@ -0,0 +1,5 @@
+UPDATE book_inventory
+SET reserved = reserved + $2,
+    updated_at = $3
+WHERE book_id = $1
+  AND on_hand - reserved >= $2
@@ -0,0 +1,13 @@
+INSERT INTO payments (order_id,
+                      provider,
+                      provider_payment_id,
+                      status,
+                      amount,
+                      currency,
+                      created_at,
+                      updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+ON CONFLICT (provider, provider_payment_id) DO UPDATE
+SET status = EXCLUDED.status,
+    amount = EXCLUDED.amount,
+    updated_at = EXCLUDED.updated_at
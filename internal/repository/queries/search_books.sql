@@ -0,0 +1,19 @@
+SELECT b.id,
+       b.title,
+       b.isbn,
+       b.filename,
+       b.description,
+       b.created_at,
+       b.updated_at,
+       b.price,
+       b.currency,
+       b.sale_price,
+       b.sale_starts_at,
+       b.sale_ends_at,
+       book_effective_price(b.price, b.sale_price, b.sale_starts_at, b.sale_ends_at, localtimestamp),
+       ts_rank(b.search_vector, websearch_to_tsquery('english', $1)),
+       ts_headline('english',
+                   b.title || ' ' || coalesce(b.description, ''),
+                   websearch_to_tsquery('english', $1),
+                   'StartSel=<mark>, StopSel=</mark>, MaxFragments=2')
+FROM books b
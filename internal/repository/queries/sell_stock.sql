@@ -0,0 +1,18 @@
+WITH sold AS (
+    UPDATE book_inventory bi
+    SET on_hand = bi.on_hand - oi.reserved,
+        reserved = bi.reserved - oi.reserved,
+        updated_at = $2
+    FROM order_items oi
+    WHERE oi.order_id = $1
+      AND oi.reserved > 0
+      AND bi.book_id = oi.book_id
+    RETURNING bi.book_id, oi.reserved
+)
+INSERT INTO inventory_adjustments (book_id,
+                                   quantity,
+                                   reason,
+                                   order_id,
+                                   created_at)
+SELECT book_id, -reserved, 'sale', $1, $2
+FROM sold
\ No newline at end of file
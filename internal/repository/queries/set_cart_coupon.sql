@@ -0,0 +1,7 @@
+INSERT INTO cart_coupons (user_id,
+                          code,
+                          created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET code = EXCLUDED.code,
+    created_at = EXCLUDED.created_at
@@ -0,0 +1,8 @@
+update files
+set title = $2,
+    authors = $3,
+    isbn = $4,
+    language = $5,
+    description = $6,
+    metadata_at = $7
+where object_key = $1
\ No newline at end of file
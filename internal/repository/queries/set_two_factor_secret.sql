@@ -0,0 +1,4 @@
+UPDATE users
+SET totp_secret = $2
+WHERE id = $1
+  AND totp_enabled_at IS NULL
\ No newline at end of file
@@ -3,5 +3,5 @@ SET username = $1,
     password = $2,
     email = $3,
     phone = $4,
-    session_id = $5
-WHERE id = $6
\ No newline at end of file
+    email_verified_at = CASE WHEN email IS DISTINCT FROM $3 THEN NULL ELSE email_verified_at END
+WHERE id = $5
\ No newline at end of file
@@ -1,9 +1,11 @@
 update books
-set author_id = $1,
-    genre_id = $2,
-    title = $3,
-    isbn = $4,
-    description = $5,
-    updated_at = $6
-WHERE id = $7
-
+set title = $1,
+    isbn = $2,
+    description = $3,
+    price = $4,
+    currency = $5,
+    sale_price = $6,
+    sale_starts_at = $7,
+    sale_ends_at = $8,
+    updated_at = $9
+WHERE id = $10
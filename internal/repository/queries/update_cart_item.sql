@@ -0,0 +1,5 @@
+UPDATE cart_items
+SET quantity = $1,
+    updated_at = $2
+WHERE user_id = $3
+  AND book_id = $4
@@ -1,3 +1,4 @@
 update books
-set filename = $1
-where id = $2
+set filename = $1,
+    object_key = $2
+where id = $3
\ No newline at end of file
@@ -0,0 +1,7 @@
+INSERT INTO book_inventory (book_id,
+                            low_stock_threshold,
+                            updated_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (book_id) DO UPDATE
+SET low_stock_threshold = EXCLUDED.low_stock_threshold,
+    updated_at = EXCLUDED.updated_at
@@ -0,0 +1,6 @@
+UPDATE payments
+SET status = $1,
+    updated_at = $2
+WHERE provider = $3
+  AND provider_payment_id = $4
+RETURNING order_id
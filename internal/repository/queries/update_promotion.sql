@@ -0,0 +1,16 @@
+UPDATE promotions
+SET name = $1,
+    code = nullif($2, ''),
+    kind = $3,
+    value = $4,
+    buy_quantity = $5,
+    get_quantity = $6,
+    book_id = nullif($7, 0),
+    genre_id = nullif($8, 0),
+    starts_at = $9,
+    ends_at = $10,
+    usage_limit = $11,
+    per_user_limit = $12,
+    active = $13,
+    updated_at = $14
+WHERE id = $15
@@ -0,0 +1,3 @@
+UPDATE roles
+SET name = $1
+WHERE id = $2
\ No newline at end of file
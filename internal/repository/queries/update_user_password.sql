@@ -0,0 +1,3 @@
+UPDATE users
+SET password = $1
+WHERE id = $2
\ No newline at end of file
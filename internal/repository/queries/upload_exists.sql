@@ -0,0 +1,3 @@
+select exists(select 1
+              from uploads
+              where storage_upload_id = $1)
\ No newline at end of file
@@ -0,0 +1,9 @@
+UPDATE email_verifications v
+SET used_at = $2
+FROM users u
+WHERE v.token_hash = $1
+  AND v.used_at IS NULL
+  AND v.expires_at > $2
+  AND u.id = v.user_id
+  AND u.email = v.email
+RETURNING v.user_id
\ No newline at end of file
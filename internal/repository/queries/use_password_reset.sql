@@ -0,0 +1,6 @@
+UPDATE password_resets
+SET used_at = $2
+WHERE token_hash = $1
+  AND used_at IS NULL
+  AND expires_at > $2
+RETURNING user_id
\ No newline at end of file
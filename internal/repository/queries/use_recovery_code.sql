@@ -0,0 +1,8 @@
+UPDATE recovery_codes
+SET used_at = $3
+WHERE id = (SELECT id
+            FROM recovery_codes
+            WHERE user_id = $1
+              AND code_hash = $2
+              AND used_at IS NULL
+            LIMIT 1)
\ No newline at end of file
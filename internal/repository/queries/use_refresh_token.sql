@@ -0,0 +1,3 @@
+UPDATE refresh_tokens
+SET used_at = $2
+WHERE id = $1
@@ -0,0 +1,6 @@
+UPDATE user_identities
+SET email = $3,
+    last_login_at = $4
+WHERE issuer = $1
+  AND subject = $2
+RETURNING user_id
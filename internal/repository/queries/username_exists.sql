@@ -0,0 +1,3 @@
+SELECT EXISTS (SELECT 1
+               FROM users
+               WHERE username = $1)
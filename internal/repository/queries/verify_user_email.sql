@@ -0,0 +1,3 @@
+UPDATE users
+SET email_verified_at = $2
+WHERE id = $1
\ No newline at end of file
@@ -1,16 +1,46 @@
 package repository
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/sabirov8872/bookstore/internal/types"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// A session ends once it has not been used for the idle timeout, and at the
+// latest after the absolute timeout however often it is used.
+const (
+	defaultSessionIdleTimeout     = 7 * 24 * time.Hour
+	defaultSessionAbsoluteTimeout = 30 * 24 * time.Hour
+
+	// A login challenge has to be answered within five minutes and five
+	// tries.
+	loginChallengeTTL      = 5 * time.Minute
+	loginChallengeAttempts = 5
+)
+
+// APIKeyPrefix starts every API key, so that they can be told apart from
+// session ids. Keys are accepted wherever a session id is.
+const APIKeyPrefix = "bk_"
+
+var errOrderStatusChanged = errors.New("order status has changed")
+
+// ErrOrderNotPending is returned by ProcessPaymentEvent when a payment was
+// captured for an order that is no longer pending, such as a cancelled one.
+// The capture is recorded, and the money has to be given back.
+var ErrOrderNotPending = errors.New("payment captured for an order that is not pending")
+
 type Repository struct {
 	DB *sql.DB
 }
@@ -19,34 +49,140 @@ type IRepository interface {
 	CreateUser(req types.CreateUserRequest) (int, error)
 	GetSessionIdByUsername(req types.GetSessionIdByUsernameRequest) (*types.GetSessionIdByUsernameResponse, error)
 	DeleteSessionId(sessionId string) error
-
-	GetAllUsers() (resp []*types.UserDB, err error)
+	CheckPassword(username, password string) (userId int, twoFactor bool, err error)
+	CreateLoginChallenge(userId int) (string, error)
+	CreateSession(userId int, req types.GetSessionIdByUsernameRequest) (*types.GetSessionIdByUsernameResponse, error)
+	GetLoginChallenge(token string) (int, error)
+	FailLoginChallenge(token string) error
+	DeleteLoginChallenge(token string) error
+	GetSessions(userId int, sessionId string) ([]*types.SessionDB, error)
+	DeleteSession(userId, id int) error
+	DeleteOtherSessions(userId int, sessionId string) error
+	GetSessionTokens(userId int) ([]string, error)
+
+	GetUsersByEmail(email string) ([]*types.UserDB, error)
+	CreatePasswordReset(userId int, tokenHash string, expiresAt time.Time) error
+	ResetPassword(tokenHash, password string) ([]string, error)
+
+	CreateEmailVerification(userId int, tokenHash string, expiresAt time.Time) (string, error)
+	CountEmailVerifications(userId int, since time.Time) (int, time.Time, error)
+	VerifyEmail(tokenHash string) (int, error)
+	IsEmailVerified(userId int) (bool, error)
+
+	GetTwoFactor(userId int) (*types.TwoFactorDB, error)
+	SetTwoFactorSecret(userId int, secret string) error
+	EnableTwoFactor(userId int, step int64, codeHashes []string) error
+	DisableTwoFactor(userId int) error
+	UseTwoFactorStep(userId int, step int64) error
+	UseRecoveryCode(userId int, codeHash string) (bool, error)
+
+	GetOrCreateExternalUser(identity types.ExternalIdentityDB, role string) (int, error)
+
+	GetSigningKeys() ([]*types.SigningKeyDB, error)
+	CreateSigningKey(key types.SigningKeyDB) error
+	CreateRefreshToken(userId int, tokenHash string, expiresAt time.Time) error
+	RotateRefreshToken(tokenHash, newTokenHash string, expiresAt time.Time) (int, error)
+	RevokeRefreshToken(tokenHash string) error
+
+	CreateAPIKey(userId int, key string, req types.CreateAPIKeyRequest) (int, error)
+	GetAPIKeys(userId int) ([]*types.APIKeyDB, error)
+	GetAPIKeyScopes(key string) ([]string, error)
+	DeleteAPIKey(userId, id int) error
+
+	CreateAuditEntry(entry types.AuditEntryDB) (int, error)
+	GetAuditLog(req types.PageRequest) ([]*types.AuditEntryDB, string, error)
+	CountAuditLog() (int, error)
+
+	GetAllPermissions() ([]*types.PermissionDB, error)
+	GetAllRoles() ([]*types.RoleDB, error)
+	GetRoleById(id int) (*types.RoleDB, error)
+	GetRolePermissions(roleId int) ([]string, error)
+	CreateRole(req types.CreateRoleRequest) (int, error)
+	UpdateRole(id int, req types.UpdateRoleRequest) error
+	DeleteRole(id int) error
+
+	GetAllUsers(req types.PageRequest) (resp []*types.UserDB, nextCursor string, err error)
+	CountUsers() (int, error)
 	GetUserByID(id int) (*types.UserDB, error)
 	UpdateUserBySessionId(req types.UpdateUserRequest, sessionId string) (int, error)
+	UpdateUser(id int, req types.UpdateUserRequest) error
+	GetUserRoleId(id int) (int, error)
 	UpdateUserById(id int, req types.UpdateUserByIdRequest) error
 	DeleteUser(id int) error
 
-	GetAllBooks(req types.GetAllBooksRequest) ([]*types.BookDB, error)
+	GetAllBooks(req types.GetAllBooksRequest) ([]*types.BookDB, string, error)
+	CountBooks(req types.GetAllBooksRequest) (int, error)
 	GetBookByID(id int) (*types.BookDB, error)
 	CreateBook(req types.CreateBookRequest) (int, error)
 	UpdateBook(id int, req types.UpdateBookRequest) error
 	DeleteBook(id int) (string, error)
+	GetBookPrices(bookId int) ([]*types.BookPriceDB, error)
+	GetBookPriceAt(bookId int, at time.Time) (*types.BookPriceDB, error)
 
-	GetAllAuthors() ([]*types.AuthorDB, error)
+	GetAllAuthors(req types.PageRequest) ([]*types.AuthorDB, string, error)
+	CountAuthors() (int, error)
 	GetAuthorById(id int) (*types.AuthorDB, error)
 	CreateAuthor(req types.CreateAuthorRequest) (int, error)
 	UpdateAuthor(id int, req types.UpdateAuthorRequest) error
 	DeleteAuthor(id int) error
 
-	GetAllGenres() ([]*types.GenreDB, error)
+	GetAllGenres(req types.PageRequest) ([]*types.GenreDB, string, error)
+	CountGenres() (int, error)
 	CreateGenre(req types.CreateGenreRequest) (int, error)
 	UpdateGenre(id int, req types.UpdateGenreRequest) error
 	DeleteGenre(id int) error
 
-	GetFileByBookId(id int) (string, error)
-	UploadFileByBookId(id int, filename string) (string, error)
+	GetFileByBookId(id int) (*types.BookFileDB, error)
+	UploadFileByBookId(id int, file types.BookFileDB, store func() error) (string, error)
+	DeleteFile(objectKey string, remove func() error) error
+	GetFileMetadata(objectKey string) (*types.FileMetadataDB, error)
+	SetFileMetadata(objectKey string, meta types.FileMetadataDB) error
+	FillBookFromFile(id int) error
+	CreateUpload(upload types.UploadDB) error
+	GetUpload(id string) (*types.UploadDB, error)
+	AdvanceUpload(id string, offset, size int64, hashState []byte) error
+	DeleteUpload(id string) error
+	DeleteStaleUploads(before time.Time) ([]*types.UploadDB, error)
+	GetWrittenUploads() ([]*types.UploadDB, error)
+	UploadExists(storageUploadId string) (bool, error)
 
 	GetUserRoleBySessionId(sessionId string) (int, error)
+	GetUserIdBySessionId(sessionId string) (int, error)
+
+	GetCartItems(userId int) ([]*types.CartItemDB, error)
+	AddCartItem(userId int, req types.AddCartItemRequest) error
+	UpdateCartItem(userId, bookId int, req types.UpdateCartItemRequest) error
+	DeleteCartItem(userId, bookId int) error
+	ClearCart(userId int) error
+
+	CreateOrder(userId int, req types.CreateOrderDB) (int, error)
+	GetAllOrders(req types.GetAllOrdersRequest) ([]*types.OrderDB, string, error)
+	CountOrders(req types.GetAllOrdersRequest) (int, error)
+	GetOrderById(id int) (*types.OrderDB, error)
+	UpdateOrderStatus(id int, from, to string) error
+
+	GetInventory(bookId int) (*types.InventoryDB, error)
+	UpdateInventory(bookId int, req types.UpdateInventoryRequest) error
+	CreateInventoryAdjustment(bookId, userId int, req types.CreateInventoryAdjustmentRequest) (int, error)
+	GetInventoryAdjustments(bookId int, req types.PageRequest) ([]*types.InventoryAdjustmentDB, string, error)
+	CountInventoryAdjustments(bookId int) (int, error)
+	GetLowStock() ([]*types.InventoryDB, error)
+
+	SavePayment(p types.PaymentDB) error
+	GetPaymentByOrderId(orderId int) (*types.PaymentDB, error)
+	ProcessPaymentEvent(event types.PaymentEventDB, status string) (int, error)
+
+	GetAllPromotions(req types.PageRequest) ([]*types.PromotionDB, string, error)
+	CountPromotions() (int, error)
+	GetPromotionById(id int) (*types.PromotionDB, error)
+	CreatePromotion(req types.CreatePromotionRequest) (int, error)
+	UpdatePromotion(id int, req types.UpdatePromotionRequest) error
+	DeletePromotion(id int) error
+	GetActivePromotions(userId int, code string, at time.Time) ([]*types.PromotionDB, error)
+
+	GetCartCoupon(userId int) (string, error)
+	SetCartCoupon(userId int, code string) error
+	DeleteCartCoupon(userId int) error
 }
 
 func NewRepository(db *sql.DB) *Repository {
@@ -77,24 +213,90 @@ func (repo *Repository) CreateUser(req types.CreateUserRequest) (int, error) {
 }
 
 func (repo *Repository) GetSessionIdByUsername(req types.GetSessionIdByUsernameRequest) (*types.GetSessionIdByUsernameResponse, error) {
-	var id int
-	var password, role string
-	err := repo.DB.QueryRow(getSessionIdByUsernameQuery, req.Username).Scan(
-		&id,
-		&password,
-		&role)
+	id, twoFactor, err := repo.CheckPassword(req.Username, req.Password)
 	if err != nil {
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(password), []byte(req.Password))
+	if twoFactor {
+		token, err := repo.CreateLoginChallenge(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.GetSessionIdByUsernameResponse{
+			UserId:         id,
+			ChallengeToken: token,
+		}, nil
+	}
+
+	return repo.CreateSession(id, req)
+}
+
+// CheckPassword returns the user with the username and password, and whether
+// the user has to answer a two-factor challenge as well. Unknown users give
+// sql.ErrNoRows and wrong passwords bcrypt.ErrMismatchedHashAndPassword.
+func (repo *Repository) CheckPassword(username, password string) (userId int, twoFactor bool, err error) {
+	var hash, role string
+	err = repo.DB.QueryRow(getSessionIdByUsernameQuery, username).Scan(
+		&userId,
+		&hash,
+		&role,
+		&twoFactor)
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		return 0, false, err
+	}
+
+	return userId, twoFactor, nil
+}
+
+// CreateLoginChallenge returns a token to answer with a second factor.
+func (repo *Repository) CreateLoginChallenge(userId int) (string, error) {
+	token := uuid.New().String()
+	now := time.Now()
+	_, err := repo.DB.Exec(createLoginChallengeQuery, token, userId, now, now.Add(loginChallengeTTL))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// CreateSession starts a session for a user whose credentials were checked.
+func (repo *Repository) CreateSession(id int, req types.GetSessionIdByUsernameRequest) (*types.GetSessionIdByUsernameResponse, error) {
+	idleTimeout := req.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultSessionIdleTimeout
+	}
+
+	absoluteTimeout := req.AbsoluteTimeout
+	if absoluteTimeout <= 0 {
+		absoluteTimeout = defaultSessionAbsoluteTimeout
+	}
+
+	now := time.Now()
+	_, err := repo.DB.Exec(deleteExpiredSessionsQuery, id, now)
 	if err != nil {
 		return nil, err
 	}
 
 	sessionId := uuid.New().String()
 
-	_, err = repo.DB.Query(`update users set session_id = $1 where id = $2`, sessionId, id)
+	var expiresAt time.Time
+	err = repo.DB.QueryRow(createSessionQuery,
+		sessionId,
+		id,
+		req.UserAgent,
+		req.IP,
+		idleTimeout.Seconds(),
+		now,
+		now.Add(absoluteTimeout)).
+		Scan(&expiresAt)
 	if err != nil {
 		return nil, err
 	}
@@ -102,11 +304,52 @@ func (repo *Repository) GetSessionIdByUsername(req types.GetSessionIdByUsernameR
 	return &types.GetSessionIdByUsernameResponse{
 		UserId:    id,
 		SessionId: sessionId,
+		ExpiresAt: &expiresAt,
 	}, nil
 }
 
+// GetLoginChallenge returns the user of a challenge that has not expired or
+// run out of tries.
+func (repo *Repository) GetLoginChallenge(token string) (int, error) {
+	var userId int
+	err := repo.DB.QueryRow(getLoginChallengeQuery, token, time.Now(), loginChallengeAttempts).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("invalid or expired challenge")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return userId, nil
+}
+
+func (repo *Repository) FailLoginChallenge(token string) error {
+	_, err := repo.DB.Exec(failLoginChallengeQuery, token)
+	return err
+}
+
+// DeleteLoginChallenge uses up a challenge. It returns sql.ErrNoRows when it
+// was used up already.
+func (repo *Repository) DeleteLoginChallenge(token string) error {
+	res, err := repo.DB.Exec(deleteLoginChallengeQuery, token)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 func (repo *Repository) DeleteSessionId(sessionId string) error {
-	_, err := repo.DB.Query(`update users set session_id = $1 where session_id = $2`, "", sessionId)
+	_, err := repo.DB.Exec(deleteSessionQuery, sessionId)
 	if err != nil {
 		return err
 	}
@@ -114,372 +357,3080 @@ func (repo *Repository) DeleteSessionId(sessionId string) error {
 	return nil
 }
 
-func (repo *Repository) GetAllUsers() (resp []*types.UserDB, err error) {
-	rows, err := repo.DB.Query(getAllUsersQuery)
+// GetSessions returns the unexpired sessions of a user, the most recently
+// used first. sessionId marks the one the request came with.
+func (repo *Repository) GetSessions(userId int, sessionId string) ([]*types.SessionDB, error) {
+	rows, err := repo.DB.Query(getUserSessionsQuery, userId, sessionId, time.Now())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	var resp []*types.SessionDB
 	for rows.Next() {
-		var u types.UserDB
+		var s types.SessionDB
 		err = rows.Scan(
-			&u.ID,
-			&u.Role,
-			&u.Username,
-			&u.Password,
-			&u.Email,
-			&u.Phone)
+			&s.ID,
+			&s.UserAgent,
+			&s.IP,
+			&s.CreatedAt,
+			&s.LastSeenAt,
+			&s.ExpiresAt,
+			&s.Current)
 		if err != nil {
 			return nil, err
 		}
 
-		resp = append(resp, &u)
+		resp = append(resp, &s)
 	}
 
-	return resp, nil
+	return resp, rows.Err()
 }
 
-func (repo *Repository) GetUserByID(id int) (*types.UserDB, error) {
-	var resp types.UserDB
-	err := repo.DB.QueryRow(getUserByIdQuery, id).Scan(
-		&resp.ID,
-		&resp.Username,
-		&resp.Password,
-		&resp.Email,
-		&resp.Phone,
-		&resp.Role)
+func (repo *Repository) DeleteSession(userId, id int) error {
+	res, err := repo.DB.Exec(deleteUserSessionQuery, id, userId)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &resp, nil
-}
-
-func (repo *Repository) UpdateUserBySessionId(req types.UpdateUserRequest, sessionId string) (int, error) {
-	password, err := hashingPassword(req.Password)
+	n, err := res.RowsAffected()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	var id int
-	err = repo.DB.QueryRow(`select id from users where session_id = $1`, sessionId).Scan(&id)
-	if err != nil {
-		return 0, errors.New("bad request")
+	if n == 0 {
+		return sql.ErrNoRows
 	}
 
-	_, err = repo.DB.Query(updateUserBySessionIdQuery,
-		req.Username,
-		password,
-		req.Email,
-		req.Phone,
-		"",
-		id)
+	return nil
+}
+
+func (repo *Repository) DeleteOtherSessions(userId int, sessionId string) error {
+	_, err := repo.DB.Exec(deleteOtherSessionsQuery, userId, sessionId)
+	return err
+}
+
+func (repo *Repository) GetSessionTokens(userId int) ([]string, error) {
+	rows, err := repo.DB.Query(getSessionTokensQuery, userId)
 	if err != nil {
-		return 0, errors.New("bad request")
+		return nil, err
 	}
+	defer rows.Close()
 
-	return id, nil
+	var tokens []string
+	for rows.Next() {
+		var token string
+		err = rows.Scan(&token)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
 }
 
-func (repo *Repository) UpdateUserById(id int, req types.UpdateUserByIdRequest) error {
-	password, err := hashingPassword(req.Password)
+func (repo *Repository) GetUsersByEmail(email string) ([]*types.UserDB, error) {
+	rows, err := repo.DB.Query(getUsersByEmailQuery, email)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	_, err = repo.DB.Query(updateUserByIdQuery,
-		req.Username,
-		password,
-		req.Email,
-		req.Phone,
-		req.RoleId,
-		id)
-	if err != nil {
-		return errors.New("bad request")
+	var resp []*types.UserDB
+	for rows.Next() {
+		var u types.UserDB
+		err = rows.Scan(&u.ID, &u.Username, &u.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		resp = append(resp, &u)
 	}
 
-	return err
+	return resp, rows.Err()
 }
 
-func (repo *Repository) DeleteUser(id int) error {
-	_, err := repo.DB.Query(deleteUserQuery, id)
+func (repo *Repository) CreatePasswordReset(userId int, tokenHash string, expiresAt time.Time) error {
+	_, err := repo.DB.Exec(createPasswordResetQuery, userId, tokenHash, time.Now(), expiresAt)
 	return err
 }
 
-func (repo *Repository) GetAllBooks(req types.GetAllBooksRequest) ([]*types.BookDB, error) {
-	query := getAllBooksQuery
+// ResetPassword uses up the reset token, along with every other token of the
+// user, sets the new password and ends all sessions of the user. It returns
+// the tokens of the ended sessions.
+func (repo *Repository) ResetPassword(tokenHash, password string) ([]string, error) {
+	hash, err := hashingPassword(password)
+	if err != nil {
+		return nil, err
+	}
 
-	if req.Filter == "author_id" {
-		_, err := strconv.Atoi(req.ID)
-		if err != nil {
-			return nil, errors.New("bad author id")
-		}
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-		query += "\nWHERE b.author_id = " + req.ID
-	} else if req.Filter == "genre_id" {
-		_, err := strconv.Atoi(req.ID)
-		if err != nil {
-			return nil, errors.New("bad genre id")
-		}
+	now := time.Now()
+
+	var userId int
+	err = tx.QueryRow(usePasswordResetQuery, tokenHash, now).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("invalid or expired token")
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		query += "\nWHERE b.genre_id = " + req.ID
+	_, err = tx.Exec(cancelPasswordResetsQuery, userId, now)
+	if err != nil {
+		return nil, err
 	}
 
-	checkSortBy := false
-	if req.SortBy == "title" {
-		query += "\nORDER BY b.title"
-		checkSortBy = true
-	} else if req.SortBy == "created_at" {
-		query += "\nORDER BY b.created_at"
-		checkSortBy = true
-	} else if req.SortBy == "updated_at" {
-		query += "\nORDER BY b.updated_at"
-		checkSortBy = true
+	_, err = tx.Exec(updateUserPasswordQuery, hash, userId)
+	if err != nil {
+		return nil, err
 	}
 
-	if checkSortBy && req.OrderBy == "desc" {
-		query += " DESC"
-	} else if checkSortBy && req.OrderBy == "asc" {
-		query += " ASC"
+	_, err = tx.Exec(deleteUserRefreshTokensQuery, userId)
+	if err != nil {
+		return nil, err
 	}
 
-	rows, err := repo.DB.Query(query)
+	rows, err := tx.Query(deleteUserSessionsQuery, userId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var resp []*types.BookDB
+	var tokens []string
 	for rows.Next() {
-		var b types.BookDB
-		err = rows.Scan(
-			&b.ID,
-			&b.Title,
-			&b.Author.ID,
-			&b.Author.Name,
-			&b.Genre.ID,
-			&b.Genre.Name,
-			&b.ISBN,
-			&b.Filename,
-			&b.Description,
-			&b.CreatedAt,
-			&b.UpdatedAt)
+		var token string
+		err = rows.Scan(&token)
 		if err != nil {
 			return nil, err
 		}
 
-		resp = append(resp, &b)
+		tokens = append(tokens, token)
 	}
 
-	return resp, nil
-}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
 
-func (repo *Repository) GetBookByID(id int) (*types.BookDB, error) {
-	var res types.BookDB
-	err := repo.DB.QueryRow(getBookByIdQuery, id).Scan(
-		&res.ID,
-		&res.Title,
-		&res.Author.ID,
-		&res.Author.Name,
-		&res.Genre.ID,
-		&res.Genre.Name,
-		&res.ISBN,
-		&res.Filename,
-		&res.Description,
-		&res.CreatedAt,
-		&res.UpdatedAt)
+	err = tx.Commit()
 	if err != nil {
 		return nil, err
 	}
 
-	return &res, nil
+	return tokens, nil
 }
 
-func (repo *Repository) CreateBook(req types.CreateBookRequest) (int, error) {
-	var id int
-	err := repo.DB.QueryRow(createBookQuery,
-		req.AuthorId,
-		req.GenreId,
-		req.Title,
-		req.ISBN,
-		"",
-		req.Description,
-		time.Now(),
-		time.Now()).
-		Scan(&id)
+// CreateEmailVerification stores a token for the current email of the user
+// and returns that email. It returns "" when there is nothing to verify.
+func (repo *Repository) CreateEmailVerification(userId int, tokenHash string, expiresAt time.Time) (string, error) {
+	var email string
+	err := repo.DB.QueryRow(createEmailVerificationQuery, userId, tokenHash, time.Now(), expiresAt).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
 	if err != nil {
-		return 0, errors.New("bad request")
+		return "", err
 	}
 
-	return id, nil
+	return email, nil
 }
 
-func (repo *Repository) UpdateBook(id int, req types.UpdateBookRequest) error {
-	_, err := repo.DB.Query(updateBookQuery,
-		req.AuthorId,
-		req.GenreId,
-		req.Title,
-		req.ISBN,
-		req.Description,
-		time.Now(),
-		id)
+// CountEmailVerifications returns how many tokens the user was sent since the
+// given time and when the last one was sent.
+func (repo *Repository) CountEmailVerifications(userId int, since time.Time) (int, time.Time, error) {
+	var count int
+	var last sql.NullTime
+	err := repo.DB.QueryRow(countEmailVerificationsQuery, userId, since).Scan(&count, &last)
 	if err != nil {
-		return errors.New("bad request")
+		return 0, time.Time{}, err
 	}
 
-	return err
+	return count, last.Time, nil
 }
 
-func (repo *Repository) DeleteBook(id int) (string, error) {
-	var filename string
-	err := repo.DB.QueryRow(getFilenameQuery, id).Scan(&filename)
+// VerifyEmail uses up the token and marks the email of its user verified.
+// Tokens sent to an email the user has changed since do not work.
+func (repo *Repository) VerifyEmail(tokenHash string) (int, error) {
+	tx, err := repo.DB.Begin()
 	if err != nil {
-		return "", err
+		return 0, err
 	}
+	defer tx.Rollback()
+
+	now := time.Now()
 
-	_, err = repo.DB.Query(deleteBookQuery, id)
+	var userId int
+	err = tx.QueryRow(useEmailVerificationQuery, tokenHash, now).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("invalid or expired token")
+	}
 	if err != nil {
-		return "", err
+		return 0, err
 	}
 
-	return filename, nil
-}
-
-func (repo *Repository) UploadFileByBookId(id int, filename string) (string, error) {
-	var oldFilename string
-	err := repo.DB.QueryRow(getFilenameQuery, id).Scan(&oldFilename)
+	_, err = tx.Exec(verifyUserEmailQuery, userId, now)
 	if err != nil {
-		return "", err
+		return 0, err
 	}
 
-	_, err = repo.DB.Query(updateFilenameQuery, filename, id)
+	err = tx.Commit()
 	if err != nil {
-		return "", err
+		return 0, err
 	}
 
-	return oldFilename, nil
+	return userId, nil
 }
 
-func (repo *Repository) GetFileByBookId(id int) (string, error) {
-	var filename string
-	err := repo.DB.QueryRow(getFilenameQuery, id).Scan(&filename)
+func (repo *Repository) IsEmailVerified(userId int) (bool, error) {
+	var verified bool
+	err := repo.DB.QueryRow(isEmailVerifiedQuery, userId).Scan(&verified)
 	if err != nil {
-		return "", err
+		return false, err
 	}
 
-	return filename, nil
+	return verified, nil
 }
 
-func (repo *Repository) GetAllAuthors() ([]*types.AuthorDB, error) {
-	rows, err := repo.DB.Query(getAllAuthorsQuery)
+func (repo *Repository) GetTwoFactor(userId int) (*types.TwoFactorDB, error) {
+	var resp types.TwoFactorDB
+	err := repo.DB.QueryRow(getTwoFactorQuery, userId).Scan(
+		&resp.Secret,
+		&resp.Enabled,
+		&resp.LastStep,
+		&resp.Email,
+		&resp.Username)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var authors []*types.AuthorDB
-	for rows.Next() {
-		var author types.AuthorDB
-		err = rows.Scan(&author.ID, &author.Name)
-		if err != nil {
-			return nil, err
-		}
-
-		authors = append(authors, &author)
-	}
 
-	return authors, nil
+	return &resp, nil
 }
 
-func (repo *Repository) GetAuthorById(id int) (*types.AuthorDB, error) {
-	var res types.AuthorDB
-	err := repo.DB.QueryRow(`select id, name from authors where id = $1`, id).Scan(
-		&res.ID,
-		&res.Name)
+// SetTwoFactorSecret stores a secret that is not in use until
+// EnableTwoFactor. It fails while two-factor authentication is enabled.
+func (repo *Repository) SetTwoFactorSecret(userId int, secret string) error {
+	res, err := repo.DB.Exec(setTwoFactorSecretQuery, userId, secret)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &res, nil
-}
-
-func (repo *Repository) CreateAuthor(req types.CreateAuthorRequest) (int, error) {
-	var id int
-	err := repo.DB.QueryRow(createAuthorQuery, req.Name).Scan(&id)
+	n, err := res.RowsAffected()
 	if err != nil {
-		return 0, errors.New("bad request")
+		return err
 	}
 
-	return id, nil
-}
-
-func (repo *Repository) UpdateAuthor(id int, req types.UpdateAuthorRequest) error {
-	_, err := repo.DB.Query(updateAuthorQuery, req.Name, id)
-	if err != nil {
-		return errors.New("bad request")
+	if n == 0 {
+		return errors.New("two-factor authentication is already enabled")
 	}
 
 	return nil
 }
 
-func (repo *Repository) DeleteAuthor(id int) error {
-	row, err := repo.DB.Query(`select id from books where author_id = $1`, id)
+// EnableTwoFactor turns on two-factor authentication once the user has shown
+// a working code, and replaces the recovery codes.
+func (repo *Repository) EnableTwoFactor(userId int, step int64, codeHashes []string) error {
+	tx, err := repo.DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	var ids []int
-	for row.Next() {
-		var i int
+	res, err := tx.Exec(enableTwoFactorQuery, userId, time.Now(), step)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errors.New("two-factor authentication is already enabled")
+	}
+
+	_, err = tx.Exec(deleteRecoveryCodesQuery, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(createRecoveryCodesQuery, userId, pq.Array(codeHashes))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repo *Repository) DisableTwoFactor(userId int) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(disableTwoFactorQuery, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(deleteRecoveryCodesQuery, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTwoFactorStep records the time step of an accepted code and refuses
+// steps that are not newer than the last one, so a code works only once.
+func (repo *Repository) UseTwoFactorStep(userId int, step int64) error {
+	res, err := repo.DB.Exec(useTwoFactorStepQuery, userId, step)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errors.New("code was already used")
+	}
+
+	return nil
+}
+
+func (repo *Repository) UseRecoveryCode(userId int, codeHash string) (bool, error) {
+	res, err := repo.DB.Exec(useRecoveryCodeQuery, userId, codeHash, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// GetOrCreateExternalUser returns the user linked to an identity at an
+// OpenID provider. A new identity is linked to the one user with the same
+// email if both the provider and we have verified it, or else to a new user
+// with the role. New users get a random password they can reset.
+func (repo *Repository) GetOrCreateExternalUser(identity types.ExternalIdentityDB, role string) (int, error) {
+	if identity.Issuer == "" || identity.Subject == "" {
+		return 0, errors.New("bad request")
+	}
+
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	var userId int
+	err = tx.QueryRow(useUserIdentityQuery, identity.Issuer, identity.Subject, identity.Email, now).Scan(&userId)
+	if err == nil {
+		return userId, tx.Commit()
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	if identity.EmailVerified && identity.Email != "" {
+		userId, err = linkByEmail(tx, identity.Email)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if userId == 0 {
+		userId, err = createExternalUser(tx, identity, role, now)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(createUserIdentityQuery, userId, identity.Issuer, identity.Subject, identity.Email, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return userId, tx.Commit()
+}
+
+// linkByEmail returns the only user with the verified email, or 0. Users
+// whose role can edit users or roles, whatever it is called, and users with
+// two-factor authentication are never linked, since the provider would let
+// anyone who controls the email past their second factor.
+func linkByEmail(tx *sql.Tx, email string) (int, error) {
+	rows, err := tx.Query(getUsersByVerifiedEmailQuery, email)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	var protected bool
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id, &protected)
+		if err != nil {
+			return 0, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) != 1 || protected {
+		return 0, nil
+	}
+
+	return ids[0], nil
+}
+
+func createExternalUser(tx *sql.Tx, identity types.ExternalIdentityDB, role string, now time.Time) (int, error) {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	username := base
+	for i := 2; ; i++ {
+		var exists bool
+		err := tx.QueryRow(usernameExistsQuery, username).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+
+		if !exists {
+			break
+		}
+
+		username = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	password, err := hashingPassword(uuid.New().String())
+	if err != nil {
+		return 0, err
+	}
+
+	var verifiedAt *time.Time
+	if identity.EmailVerified && identity.Email != "" {
+		verifiedAt = &now
+	}
+
+	var id int
+	err = tx.QueryRow(createExternalUserQuery, role, username, password, identity.Email, verifiedAt).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("unknown role %q", role)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetSigningKeys returns the access token keys that have not expired, the
+// newest first.
+func (repo *Repository) GetSigningKeys() ([]*types.SigningKeyDB, error) {
+	rows, err := repo.DB.Query(getSigningKeysQuery, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resp []*types.SigningKeyDB
+	for rows.Next() {
+		var k types.SigningKeyDB
+		err = rows.Scan(
+			&k.Kid,
+			&k.PrivateKey,
+			&k.CreatedAt,
+			&k.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp = append(resp, &k)
+	}
+
+	return resp, rows.Err()
+}
+
+func (repo *Repository) CreateSigningKey(key types.SigningKeyDB) error {
+	_, err := repo.DB.Exec(deleteExpiredSigningKeysQuery, key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.DB.Exec(createSigningKeyQuery, key.Kid, key.PrivateKey, key.CreatedAt, key.ExpiresAt)
+	return err
+}
+
+// CreateRefreshToken starts a family of refresh tokens for a new login.
+// Each refresh replaces the token with the next one of the family.
+func (repo *Repository) CreateRefreshToken(userId int, tokenHash string, expiresAt time.Time) error {
+	now := time.Now()
+	_, err := repo.DB.Exec(deleteExpiredRefreshTokensQuery, userId, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.DB.Exec(createRefreshTokenQuery, userId, uuid.New().String(), tokenHash, now, expiresAt)
+	return err
+}
+
+// RotateRefreshToken uses up a refresh token and stores the next one of its
+// family, returning the user. A token that was used before means it was
+// copied, so the whole family is revoked and the login has to start over.
+func (repo *Repository) RotateRefreshToken(tokenHash, newTokenHash string, expiresAt time.Time) (int, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	var id, userId int
+	var family string
+	var used, valid bool
+	err = tx.QueryRow(getRefreshTokenQuery, tokenHash, now).Scan(&id, &userId, &family, &used, &valid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("invalid or expired refresh token")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if used {
+		_, err = tx.Exec(deleteFamilyRefreshTokensQuery, family)
+		if err != nil {
+			return 0, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+
+		return 0, errors.New("refresh token was already used, the login is revoked")
+	}
+
+	if !valid {
+		return 0, errors.New("invalid or expired refresh token")
+	}
+
+	_, err = tx.Exec(useRefreshTokenQuery, id, now)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(createRefreshTokenQuery, userId, family, newTokenHash, now, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+
+	return userId, tx.Commit()
+}
+
+// RevokeRefreshToken ends the login the token belongs to.
+func (repo *Repository) RevokeRefreshToken(tokenHash string) error {
+	res, err := repo.DB.Exec(deleteRefreshTokenFamilyQuery, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreateAPIKey stores a hash of the key, so the key itself cannot be read
+// back.
+func (repo *Repository) CreateAPIKey(userId int, key string, req types.CreateAPIKeyRequest) (int, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) || len(key) < len(APIKeyPrefix)+8 {
+		return 0, errors.New("bad request")
+	}
+
+	var id int
+	err := repo.DB.QueryRow(createAPIKeyQuery,
+		userId,
+		req.Name,
+		key[:len(APIKeyPrefix)+8],
+		hashAPIKey(key),
+		pq.Array(req.Scopes),
+		time.Now(),
+		req.ExpiresAt).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (repo *Repository) GetAPIKeys(userId int) ([]*types.APIKeyDB, error) {
+	rows, err := repo.DB.Query(getUserAPIKeysQuery, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resp []*types.APIKeyDB
+	for rows.Next() {
+		var k types.APIKeyDB
+		err = rows.Scan(
+			&k.ID,
+			&k.Name,
+			&k.Prefix,
+			pq.Array(&k.Scopes),
+			&k.CreatedAt,
+			&k.ExpiresAt,
+			&k.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp = append(resp, &k)
+	}
+
+	return resp, rows.Err()
+}
+
+// GetAPIKeyScopes returns the scopes of a key that has not expired.
+func (repo *Repository) GetAPIKeyScopes(key string) ([]string, error) {
+	var scopes []string
+	err := repo.DB.QueryRow(getAPIKeyScopesQuery, hashAPIKey(key), time.Now()).Scan(pq.Array(&scopes))
+	if err != nil {
+		return nil, err
+	}
+
+	return scopes, nil
+}
+
+func (repo *Repository) DeleteAPIKey(userId, id int) error {
+	res, err := repo.DB.Exec(deleteAPIKeyQuery, id, userId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (repo *Repository) CreateAuditEntry(entry types.AuditEntryDB) (int, error) {
+	createdAt := entry.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	var id int
+	err := repo.DB.QueryRow(createAuditEntryQuery,
+		entry.Action,
+		entry.UserId,
+		entry.ActorId,
+		entry.Username,
+		entry.IP,
+		entry.Details,
+		createdAt).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetAuditLog lists audit entries, the newest first.
+func (repo *Repository) GetAuditLog(req types.PageRequest) ([]*types.AuditEntryDB, string, error) {
+	after, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := getAuditLogQuery
+	var args []any
+	if after != nil {
+		args = append(args, after.ID)
+		query += fmt.Sprintf("\n  AND a.id < $%d", len(args))
+	}
+
+	limit := pageLimit(req.Limit)
+	args = append(args, limit+1)
+	query += fmt.Sprintf("\nORDER BY a.id DESC\nLIMIT $%d", len(args))
+
+	rows, err := repo.DB.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var resp []*types.AuditEntryDB
+	for rows.Next() {
+		var e types.AuditEntryDB
+		err = rows.Scan(
+			&e.ID,
+			&e.Action,
+			&e.UserId,
+			&e.ActorId,
+			&e.Username,
+			&e.IP,
+			&e.Details,
+			&e.CreatedAt)
+		if err != nil {
+			return nil, "", err
+		}
+
+		resp = append(resp, &e)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(resp) > limit {
+		resp = resp[:limit]
+		nextCursor = encodeCursor(cursor{ID: resp[limit-1].ID})
+	}
+
+	return resp, nextCursor, nil
+}
+
+func (repo *Repository) CountAuditLog() (int, error) {
+	var count int
+	err := repo.DB.QueryRow(countAuditLogQuery).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (repo *Repository) GetAllPermissions() ([]*types.PermissionDB, error) {
+	rows, err := repo.DB.Query(getAllPermissionsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resp []*types.PermissionDB
+	for rows.Next() {
+		var p types.PermissionDB
+		err = rows.Scan(&p.Name, &p.Description)
+		if err != nil {
+			return nil, err
+		}
+
+		resp = append(resp, &p)
+	}
+
+	return resp, rows.Err()
+}
+
+func (repo *Repository) GetAllRoles() ([]*types.RoleDB, error) {
+	rows, err := repo.DB.Query(getAllRolesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resp []*types.RoleDB
+	for rows.Next() {
+		var r types.RoleDB
+		err = rows.Scan(&r.ID, &r.Name, pq.Array(&r.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		resp = append(resp, &r)
+	}
+
+	return resp, rows.Err()
+}
+
+func (repo *Repository) GetRoleById(id int) (*types.RoleDB, error) {
+	var resp types.RoleDB
+	err := repo.DB.QueryRow(getRoleByIdQuery, id).Scan(
+		&resp.ID,
+		&resp.Name,
+		pq.Array(&resp.Permissions))
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (repo *Repository) GetRolePermissions(roleId int) ([]string, error) {
+	rows, err := repo.DB.Query(getRolePermissionsQuery, roleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []string
+	for rows.Next() {
+		var p string
+		err = rows.Scan(&p)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, p)
+	}
+
+	return permissions, rows.Err()
+}
+
+func (repo *Repository) CreateRole(req types.CreateRoleRequest) (int, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(createRoleQuery, req.Name).Scan(&id)
+	if err != nil {
+		return 0, errors.New("bad request")
+	}
+
+	err = setRolePermissions(tx, id, req.Permissions)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (repo *Repository) UpdateRole(id int, req types.UpdateRoleRequest) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(updateRoleQuery, req.Name, id)
+	if err != nil {
+		return errors.New("bad request")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = setRolePermissions(tx, id, req.Permissions)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRole refuses to delete a role that is still given to users.
+func (repo *Repository) DeleteRole(id int) error {
+	res, err := repo.DB.Exec(deleteRoleQuery, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errors.New("cannot delete role")
+	}
+
+	return nil
+}
+
+func setRolePermissions(tx *sql.Tx, roleId int, permissions []string) error {
+	_, err := tx.Exec(deleteRolePermissionsQuery, roleId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(createRolePermissionsQuery, roleId, pq.Array(permissions))
+	if err != nil {
+		return errors.New("unknown permission")
+	}
+
+	return nil
+}
+
+// getSession looks up an unexpired session and renews its idle timeout. The
+// renewal is written at most once a minute so that every request does not
+// turn into an update.
+func (repo *Repository) getSession(sessionId string) (userId, roleId int, err error) {
+	if strings.HasPrefix(sessionId, APIKeyPrefix) {
+		err = repo.DB.QueryRow(getAPIKeyQuery, hashAPIKey(sessionId), time.Now()).Scan(&userId, &roleId)
+		return userId, roleId, err
+	}
+
+	err = repo.DB.QueryRow(getSessionQuery, sessionId, time.Now()).Scan(&userId, &roleId)
+	return userId, roleId, err
+}
+
+func (repo *Repository) GetAllUsers(req types.PageRequest) (resp []*types.UserDB, nextCursor string, err error) {
+	after, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var afterID int
+	if after != nil {
+		afterID = after.ID
+	}
+
+	limit := pageLimit(req.Limit)
+	rows, err := repo.DB.Query(getAllUsersQuery, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u types.UserDB
+		err = rows.Scan(
+			&u.ID,
+			&u.Role,
+			&u.Username,
+			&u.Password,
+			&u.Email,
+			&u.Phone,
+			&u.EmailVerified)
+		if err != nil {
+			return nil, "", err
+		}
+
+		resp = append(resp, &u)
+	}
+
+	if len(resp) > limit {
+		resp = resp[:limit]
+		nextCursor = encodeCursor(cursor{ID: resp[limit-1].ID})
+	}
+
+	return resp, nextCursor, nil
+}
+
+func (repo *Repository) CountUsers() (int, error) {
+	var count int
+	err := repo.DB.QueryRow(countUsersQuery).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (repo *Repository) GetUserByID(id int) (*types.UserDB, error) {
+	var resp types.UserDB
+	err := repo.DB.QueryRow(getUserByIdQuery, id).Scan(
+		&resp.ID,
+		&resp.Username,
+		&resp.Password,
+		&resp.Email,
+		&resp.Phone,
+		&resp.Role,
+		&resp.EmailVerified)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (repo *Repository) UpdateUserBySessionId(req types.UpdateUserRequest, sessionId string) (int, error) {
+	id, _, err := repo.getSession(sessionId)
+	if err != nil {
+		return 0, errors.New("bad request")
+	}
+
+	err = repo.UpdateUser(id, req)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UpdateUser changes the account of a user who is logged in as id.
+func (repo *Repository) UpdateUser(id int, req types.UpdateUserRequest) error {
+	password, err := hashingPassword(req.Password)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.DB.Exec(updateUserBySessionIdQuery,
+		req.Username,
+		password,
+		req.Email,
+		req.Phone,
+		id)
+	if err != nil {
+		return errors.New("bad request")
+	}
+
+	// the credentials changed, so every device has to log in again
+	_, err = repo.DB.Exec(deleteUserSessionsQuery, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.DB.Exec(deleteUserRefreshTokensQuery, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (repo *Repository) GetUserRoleId(id int) (int, error) {
+	var roleId int
+	err := repo.DB.QueryRow(getUserRoleIdQuery, id).Scan(&roleId)
+	if err != nil {
+		return 0, err
+	}
+
+	return roleId, nil
+}
+
+func (repo *Repository) UpdateUserById(id int, req types.UpdateUserByIdRequest) error {
+	password, err := hashingPassword(req.Password)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.DB.Query(updateUserByIdQuery,
+		req.Username,
+		password,
+		req.Email,
+		req.Phone,
+		req.RoleId,
+		id)
+	if err != nil {
+		return errors.New("bad request")
+	}
+
+	return err
+}
+
+func (repo *Repository) DeleteUser(id int) error {
+	_, err := repo.DB.Query(deleteUserQuery, id)
+	return err
+}
+
+func (repo *Repository) GetAllBooks(req types.GetAllBooksRequest) ([]*types.BookDB, string, error) {
+	conditions, args := bookConditions(req)
+
+	sortBy := req.SortBy
+	if sortBy == "" && req.Query != "" {
+		sortBy = "relevance"
+	}
+
+	sortColumn, ok := bookSortColumns[sortBy]
+	if !ok || (sortBy == "relevance" && req.Query == "") {
+		sortBy, sortColumn = "", "b.id"
+	}
+
+	direction, operator := "ASC", ">"
+	if sortBy == "relevance" || (sortBy != "" && req.OrderBy == "desc") {
+		direction, operator = "DESC", "<"
+	}
+
+	after, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if after != nil {
+		if sortBy == "" {
+			args = append(args, after.ID)
+			conditions = append(conditions, fmt.Sprintf("b.id > $%d", len(args)))
+		} else {
+			value, err := bookCursorValue(sortBy, after.Value)
+			if err != nil {
+				return nil, "", err
+			}
+
+			args = append(args, value, after.ID)
+			conditions = append(conditions, fmt.Sprintf("(%s, b.id) %s ($%d, $%d)",
+				sortColumn, operator, len(args)-1, len(args)))
+		}
+	}
+
+	query := getAllBooksQuery
+	if req.Query != "" {
+		query = searchBooksQuery
+	}
+
+	if len(conditions) > 0 {
+		query += "\nWHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if sortBy == "" {
+		query += "\nORDER BY b.id"
+	} else {
+		query += fmt.Sprintf("\nORDER BY %s %s, b.id %s", sortColumn, direction, direction)
+	}
+
+	limit := pageLimit(req.Limit)
+	args = append(args, limit+1)
+	query += fmt.Sprintf("\nLIMIT $%d", len(args))
+
+	rows, err := repo.DB.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var resp []*types.BookDB
+	for rows.Next() {
+		var b types.BookDB
+		dest := []any{
+			&b.ID,
+			&b.Title,
+			&b.ISBN,
+			&b.Filename,
+			&b.Description,
+			&b.CreatedAt,
+			&b.UpdatedAt,
+			&b.Price,
+			&b.Currency,
+			&b.SalePrice,
+			&b.SaleStartsAt,
+			&b.SaleEndsAt,
+			&b.EffectivePrice,
+		}
+		if req.Query != "" {
+			dest = append(dest, &b.Rank, &b.Snippet)
+		}
+
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, "", err
+		}
+
+		resp = append(resp, &b)
+	}
+
+	var nextCursor string
+	if len(resp) > limit {
+		resp = resp[:limit]
+		last := resp[limit-1]
+		nextCursor = encodeCursor(cursor{
+			ID:    last.ID,
+			Value: bookSortValue(sortBy, last),
+		})
+	}
+
+	err = repo.getBookRelations(resp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resp, nextCursor, nil
+}
+
+func (repo *Repository) CountBooks(req types.GetAllBooksRequest) (int, error) {
+	conditions, args := bookConditions(req)
+
+	query := countBooksQuery
+	if len(conditions) > 0 {
+		query += "\nWHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var count int
+	err := repo.DB.QueryRow(query, args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// bookPriceColumn is the price a book sells for right now, see
+// book_effective_price in migrations.
+const bookPriceColumn = "book_effective_price(b.price, b.sale_price, b.sale_starts_at, b.sale_ends_at, localtimestamp)"
+
+var bookSortColumns = map[string]string{
+	"title":      "b.title",
+	"created_at": "b.created_at",
+	"updated_at": "b.updated_at",
+	"price":      bookPriceColumn,
+	"relevance":  "ts_rank(b.search_vector, websearch_to_tsquery('english', $1))",
+}
+
+// bookConditions builds the WHERE clause shared by GetAllBooks and CountBooks.
+// The search text, when present, is always bound as $1 because
+// search_books.sql refers to it from the select list.
+func bookConditions(req types.GetAllBooksRequest) ([]string, []any) {
+	var conditions []string
+	var args []any
+
+	if req.Query != "" {
+		args = append(args, req.Query)
+		conditions = append(conditions, "b.search_vector @@ websearch_to_tsquery('english', $1)")
+	}
+
+	f := req.Filter
+	if len(f.AuthorIds) > 0 {
+		args = append(args, pq.Array(f.AuthorIds))
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM book_authors ba WHERE ba.book_id = b.id AND ba.author_id = ANY($%d))", len(args)))
+	}
+
+	if len(f.GenreIds) > 0 {
+		args = append(args, pq.Array(f.GenreIds))
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM book_genres bg WHERE bg.book_id = b.id AND bg.genre_id = ANY($%d))", len(args)))
+	}
+
+	if f.CreatedAfter != nil {
+		args = append(args, *f.CreatedAfter)
+		conditions = append(conditions, fmt.Sprintf("b.created_at >= $%d", len(args)))
+	}
+
+	if f.CreatedBefore != nil {
+		args = append(args, *f.CreatedBefore)
+		conditions = append(conditions, fmt.Sprintf("b.created_at < $%d", len(args)))
+	}
+
+	if f.UpdatedAfter != nil {
+		args = append(args, *f.UpdatedAfter)
+		conditions = append(conditions, fmt.Sprintf("b.updated_at >= $%d", len(args)))
+	}
+
+	if f.UpdatedBefore != nil {
+		args = append(args, *f.UpdatedBefore)
+		conditions = append(conditions, fmt.Sprintf("b.updated_at < $%d", len(args)))
+	}
+
+	if f.HasFile != nil {
+		if *f.HasFile {
+			conditions = append(conditions, "coalesce(b.filename, '') <> ''")
+		} else {
+			conditions = append(conditions, "coalesce(b.filename, '') = ''")
+		}
+	}
+
+	if f.ISBNPrefix != "" {
+		args = append(args, escapeLike(f.ISBNPrefix)+"%")
+		conditions = append(conditions, fmt.Sprintf("b.isbn LIKE $%d", len(args)))
+	}
+
+	if f.TitleContains != "" {
+		args = append(args, "%"+escapeLike(f.TitleContains)+"%")
+		conditions = append(conditions, fmt.Sprintf("b.title ILIKE $%d", len(args)))
+	}
+
+	if f.MinPrice != nil {
+		args = append(args, *f.MinPrice)
+		conditions = append(conditions, fmt.Sprintf("%s >= $%d", bookPriceColumn, len(args)))
+	}
+
+	if f.MaxPrice != nil {
+		args = append(args, *f.MaxPrice)
+		conditions = append(conditions, fmt.Sprintf("%s <= $%d", bookPriceColumn, len(args)))
+	}
+
+	if f.Currency != "" {
+		args = append(args, strings.ToUpper(f.Currency))
+		conditions = append(conditions, fmt.Sprintf("b.currency = $%d", len(args)))
+	}
+
+	return conditions, args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func bookSortValue(sortBy string, b *types.BookDB) string {
+	switch sortBy {
+	case "title":
+		return b.Title
+	case "created_at":
+		return b.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		return b.UpdatedAt.Format(time.RFC3339Nano)
+	case "price":
+		return strconv.FormatInt(b.EffectivePrice, 10)
+	case "relevance":
+		return strconv.FormatFloat(b.Rank, 'g', -1, 32)
+	}
+
+	return ""
+}
+
+func bookCursorValue(sortBy, value string) (any, error) {
+	switch sortBy {
+	case "title":
+		return value, nil
+	case "price":
+		price, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("bad cursor")
+		}
+
+		return price, nil
+	case "relevance":
+		rank, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return nil, errors.New("bad cursor")
+		}
+
+		return float32(rank), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, errors.New("bad cursor")
+	}
+
+	return t, nil
+}
+
+func (repo *Repository) GetBookByID(id int) (*types.BookDB, error) {
+	var res types.BookDB
+	err := repo.DB.QueryRow(getBookByIdQuery, id).Scan(
+		&res.ID,
+		&res.Title,
+		&res.ISBN,
+		&res.Filename,
+		&res.Description,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Price,
+		&res.Currency,
+		&res.SalePrice,
+		&res.SaleStartsAt,
+		&res.SaleEndsAt,
+		&res.EffectivePrice)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.getBookRelations([]*types.BookDB{&res})
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (repo *Repository) CreateBook(req types.CreateBookRequest) (int, error) {
+	if len(req.Authors) == 0 || len(req.GenreIds) == 0 {
+		return 0, errors.New("bad request")
+	}
+
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(createBookQuery,
+		req.Title,
+		req.ISBN,
+		"",
+		req.Description,
+		req.Price,
+		req.Currency,
+		req.SalePrice,
+		req.SaleStartsAt,
+		req.SaleEndsAt,
+		time.Now(),
+		time.Now()).
+		Scan(&id)
+	if err != nil {
+		return 0, errors.New("bad request")
+	}
+
+	err = setBookRelations(tx, id, req.Authors, req.GenreIds)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (repo *Repository) UpdateBook(id int, req types.UpdateBookRequest) error {
+	if len(req.Authors) == 0 || len(req.GenreIds) == 0 {
+		return errors.New("bad request")
+	}
+
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(updateBookQuery,
+		req.Title,
+		req.ISBN,
+		req.Description,
+		req.Price,
+		req.Currency,
+		req.SalePrice,
+		req.SaleStartsAt,
+		req.SaleEndsAt,
+		time.Now(),
+		id)
+	if err != nil {
+		return errors.New("bad request")
+	}
+
+	_, err = tx.Exec(deleteBookAuthorsQuery, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(deleteBookGenresQuery, id)
+	if err != nil {
+		return err
+	}
+
+	err = setBookRelations(tx, id, req.Authors, req.GenreIds)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setBookRelations stores contributors and genres in the order they were
+// given; a missing role means the contributor is an author.
+func setBookRelations(tx *sql.Tx, bookId int, authors []types.BookAuthorRequest, genreIds []int) error {
+	for i, author := range authors {
+		role := author.Role
+		if role == "" {
+			role = "author"
+		}
+
+		_, err := tx.Exec(createBookAuthorQuery, bookId, author.AuthorId, role, i)
+		if err != nil {
+			return errors.New("bad request")
+		}
+	}
+
+	for i, genreId := range genreIds {
+		_, err := tx.Exec(createBookGenreQuery, bookId, genreId, i)
+		if err != nil {
+			return errors.New("bad request")
+		}
+	}
+
+	return nil
+}
+
+// getBookRelations fills Authors and Genres for every book with one query
+// per relation.
+func (repo *Repository) getBookRelations(books []*types.BookDB) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(books))
+	byId := make(map[int]*types.BookDB, len(books))
+	for i, b := range books {
+		ids[i] = b.ID
+		byId[b.ID] = b
+	}
+
+	rows, err := repo.DB.Query(getBookAuthorsQuery, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookId int
+		var author types.BookAuthorDB
+		err = rows.Scan(&bookId, &author.ID, &author.Name, &author.Role)
+		if err != nil {
+			return err
+		}
+
+		byId[bookId].Authors = append(byId[bookId].Authors, author)
+	}
+
+	genreRows, err := repo.DB.Query(getBookGenresQuery, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer genreRows.Close()
+
+	for genreRows.Next() {
+		var bookId int
+		var genre types.GenreDB
+		err = genreRows.Scan(&bookId, &genre.ID, &genre.Name)
+		if err != nil {
+			return err
+		}
+
+		byId[bookId].Genres = append(byId[bookId].Genres, genre)
+	}
+
+	return nil
+}
+
+// DeleteBook returns the object key of the file of the book when no other
+// book shares it, so that the object can be removed.
+func (repo *Repository) DeleteBook(id int) (string, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var objectKey sql.NullString
+	err = tx.QueryRow(getBookObjectKeyQuery, id).Scan(&objectKey)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(deleteBookQuery, id)
+	if err != nil {
+		return "", err
+	}
+
+	var unused string
+	if objectKey.Valid {
+		unused, err = releaseFile(tx, objectKey.String)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return unused, tx.Commit()
+}
+
+func (repo *Repository) GetBookPrices(bookId int) ([]*types.BookPriceDB, error) {
+	rows, err := repo.DB.Query(getBookPricesQuery, bookId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resp []*types.BookPriceDB
+	for rows.Next() {
+		var p types.BookPriceDB
+		err = rows.Scan(
+			&p.Price,
+			&p.Currency,
+			&p.SalePrice,
+			&p.SaleStartsAt,
+			&p.SaleEndsAt,
+			&p.ValidFrom)
+		if err != nil {
+			return nil, err
+		}
+
+		resp = append(resp, &p)
+	}
+
+	return resp, nil
+}
+
+// GetBookPriceAt returns the price entry that was in force at the given time
+// together with the price a buyer actually paid then.
+func (repo *Repository) GetBookPriceAt(bookId int, at time.Time) (*types.BookPriceDB, error) {
+	var p types.BookPriceDB
+	err := repo.DB.QueryRow(getBookPriceAtQuery, bookId, at).Scan(
+		&p.Price,
+		&p.Currency,
+		&p.SalePrice,
+		&p.SaleStartsAt,
+		&p.SaleEndsAt,
+		&p.ValidFrom,
+		&p.EffectivePrice)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// UploadFileByBookId points the book at file, counting a reference to the
+// object. store is called to write the object unless a book uses it
+// already; the files row stays locked meanwhile, so DeleteFile cannot remove
+// the object under it. It returns the object key of the file the book had
+// before when no book uses it any more.
+func (repo *Repository) UploadFileByBookId(id int, file types.BookFileDB, store func() error) (string, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var oldObjectKey sql.NullString
+	err = tx.QueryRow(getBookObjectKeyQuery, id).Scan(&oldObjectKey)
+	if err != nil {
+		return "", err
+	}
+
+	err = acquireFile(tx, file, store)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(updateFilenameQuery, file.Filename, file.ObjectKey, id)
+	if err != nil {
+		return "", err
+	}
+
+	var unused string
+	if oldObjectKey.Valid {
+		unused, err = releaseFile(tx, oldObjectKey.String)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return unused, tx.Commit()
+}
+
+// acquireFile counts a reference to the object of file. An object without
+// references may be being removed, so it is stored again.
+func acquireFile(tx *sql.Tx, file types.BookFileDB, store func() error) error {
+	res, err := tx.Exec(useFileQuery, file.ObjectKey)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(acquireFileQuery, file.ObjectKey, file.Size, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return store()
+}
+
+// releaseFile drops a reference to an object and returns its key when that
+// was the last one. The row stays until DeleteFile removes the object.
+func releaseFile(tx *sql.Tx, objectKey string) (string, error) {
+	var refCount int
+	err := tx.QueryRow(releaseFileQuery, objectKey).Scan(&refCount)
+	if err != nil {
+		return "", err
+	}
+
+	if refCount > 0 {
+		return "", nil
+	}
+
+	return objectKey, nil
+}
+
+// DeleteFile calls remove for an object no book uses and forgets it. The
+// files row stays locked while remove runs, and nothing happens when a book
+// took the object up again in the meantime.
+func (repo *Repository) DeleteFile(objectKey string, remove func() error) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(deleteFileQuery, objectKey)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	err = remove()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetFileMetadata returns what the object says about the book in it.
+// ExtractedAt is nil when the object was not read yet.
+func (repo *Repository) GetFileMetadata(objectKey string) (*types.FileMetadataDB, error) {
+	var m types.FileMetadataDB
+	err := repo.DB.QueryRow(getFileMetadataQuery, objectKey).Scan(
+		&m.Title,
+		pq.Array(&m.Authors),
+		&m.ISBN,
+		&m.Language,
+		&m.Description,
+		&m.ExtractedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (repo *Repository) SetFileMetadata(objectKey string, meta types.FileMetadataDB) error {
+	res, err := repo.DB.Exec(setFileMetadataQuery,
+		objectKey,
+		meta.Title,
+		pq.Array(meta.Authors),
+		meta.ISBN,
+		meta.Language,
+		meta.Description,
+		meta.ExtractedAt)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// FillBookFromFile sets the title, ISBN and description the book lacks to
+// what its file says. Fields the book has are kept. It returns
+// sql.ErrNoRows when the book has no file that was read.
+func (repo *Repository) FillBookFromFile(id int) error {
+	res, err := repo.DB.Exec(fillBookFromFileQuery, id, time.Now())
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (repo *Repository) CreateUpload(upload types.UploadDB) error {
+	_, err := repo.DB.Exec(createUploadQuery,
+		upload.ID,
+		upload.BookId,
+		upload.Filename,
+		upload.ObjectKey,
+		upload.StorageUploadID,
+		upload.Length,
+		upload.CreatedAt)
+	return err
+}
+
+func (repo *Repository) GetUpload(id string) (*types.UploadDB, error) {
+	return scanUpload(repo.DB.QueryRow(getUploadQuery, id))
+}
+
+// AdvanceUpload records a part of size bytes written at offset, and the hash
+// state after it. It returns sql.ErrNoRows when the upload is no longer at
+// offset.
+func (repo *Repository) AdvanceUpload(id string, offset, size int64, hashState []byte) error {
+	res, err := repo.DB.Exec(advanceUploadQuery, id, offset, size, time.Now(), hashState)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteUpload(id string) error {
+	_, err := repo.DB.Exec(deleteUploadQuery, id)
+	return err
+}
+
+// DeleteStaleUploads removes the uploads that got no chunk since before and
+// returns them, so their parts can be thrown away.
+func (repo *Repository) DeleteStaleUploads(before time.Time) ([]*types.UploadDB, error) {
+	return queryUploads(repo.DB.Query(deleteStaleUploadsQuery, before))
+}
+
+// GetWrittenUploads returns the uploads that got all their bytes but were
+// not completed, as when attaching the file failed.
+func (repo *Repository) GetWrittenUploads() ([]*types.UploadDB, error) {
+	return queryUploads(repo.DB.Query(getWrittenUploadsQuery))
+}
+
+func queryUploads(rows *sql.Rows, err error) ([]*types.UploadDB, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resp []*types.UploadDB
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		resp = append(resp, upload)
+	}
+
+	return resp, rows.Err()
+}
+
+func scanUpload(row interface{ Scan(...any) error }) (*types.UploadDB, error) {
+	var u types.UploadDB
+	err := row.Scan(
+		&u.ID,
+		&u.BookId,
+		&u.Filename,
+		&u.ObjectKey,
+		&u.StorageUploadID,
+		&u.Length,
+		&u.Offset,
+		&u.Parts,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+		&u.HashState)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func (repo *Repository) UploadExists(storageUploadId string) (bool, error) {
+	var exists bool
+	err := repo.DB.QueryRow(uploadExistsQuery, storageUploadId).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (repo *Repository) GetFileByBookId(id int) (*types.BookFileDB, error) {
+	var res types.BookFileDB
+	var objectKey sql.NullString
+	err := repo.DB.QueryRow(getBookFileQuery, id).Scan(&res.Filename, &objectKey, &res.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	if !objectKey.Valid {
+		return nil, errors.New("book has no file")
+	}
+
+	res.ObjectKey = objectKey.String
+	return &res, nil
+}
+
+func (repo *Repository) GetAllAuthors(req types.PageRequest) ([]*types.AuthorDB, string, error) {
+	after, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var afterID int
+	if after != nil {
+		afterID = after.ID
+	}
+
+	limit := pageLimit(req.Limit)
+	rows, err := repo.DB.Query(getAllAuthorsQuery, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var authors []*types.AuthorDB
+	for rows.Next() {
+		var author types.AuthorDB
+		err = rows.Scan(&author.ID, &author.Name)
+		if err != nil {
+			return nil, "", err
+		}
+
+		authors = append(authors, &author)
+	}
+
+	var nextCursor string
+	if len(authors) > limit {
+		authors = authors[:limit]
+		nextCursor = encodeCursor(cursor{ID: authors[limit-1].ID})
+	}
+
+	return authors, nextCursor, nil
+}
+
+func (repo *Repository) CountAuthors() (int, error) {
+	var count int
+	err := repo.DB.QueryRow(countAuthorsQuery).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (repo *Repository) GetAuthorById(id int) (*types.AuthorDB, error) {
+	var res types.AuthorDB
+	err := repo.DB.QueryRow(`select id, name from authors where id = $1`, id).Scan(
+		&res.ID,
+		&res.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (repo *Repository) CreateAuthor(req types.CreateAuthorRequest) (int, error) {
+	var id int
+	err := repo.DB.QueryRow(createAuthorQuery, req.Name).Scan(&id)
+	if err != nil {
+		return 0, errors.New("bad request")
+	}
+
+	return id, nil
+}
+
+func (repo *Repository) UpdateAuthor(id int, req types.UpdateAuthorRequest) error {
+	_, err := repo.DB.Query(updateAuthorQuery, req.Name, id)
+	if err != nil {
+		return errors.New("bad request")
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteAuthor(id int) error {
+	row, err := repo.DB.Query(`select book_id from book_authors where author_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	var ids []int
+	for row.Next() {
+		var i int
 		err = row.Scan(&i)
 		if err != nil {
-			return err
+			return err
+		}
+
+		ids = append(ids, i)
+
+		if len(ids) > 0 {
+			break
+		}
+	}
+
+	if len(ids) == 0 {
+		_, err = repo.DB.Query(deleteAuthorQuery, id)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	return errors.New("cannot delete author")
+}
+
+func (repo *Repository) GetAllGenres(req types.PageRequest) ([]*types.GenreDB, string, error) {
+	after, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var afterID int
+	if after != nil {
+		afterID = after.ID
+	}
+
+	limit := pageLimit(req.Limit)
+	rows, err := repo.DB.Query(getAllGenresQuery, afterID, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var genres []*types.GenreDB
+	for rows.Next() {
+		var genre types.GenreDB
+		err = rows.Scan(&genre.ID, &genre.Name)
+		if err != nil {
+			return nil, "", err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	var nextCursor string
+	if len(genres) > limit {
+		genres = genres[:limit]
+		nextCursor = encodeCursor(cursor{ID: genres[limit-1].ID})
+	}
+
+	return genres, nextCursor, nil
+}
+
+func (repo *Repository) CountGenres() (int, error) {
+	var count int
+	err := repo.DB.QueryRow(countGenresQuery).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (repo *Repository) CreateGenre(req types.CreateGenreRequest) (int, error) {
+	var id int
+	err := repo.DB.QueryRow(createGenreQuery, req.Name).Scan(&id)
+	if err != nil {
+		return 0, errors.New("bad request")
+	}
+
+	return id, nil
+}
+
+func (repo *Repository) UpdateGenre(id int, req types.UpdateGenreRequest) error {
+	_, err := repo.DB.Query(updateGenreQuery, req.Name, id)
+	if err != nil {
+		return errors.New("bad request")
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteGenre(id int) error {
+	rows, err := repo.DB.Query(`select book_id from book_genres where genre_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var i int
+		err = rows.Scan(&i)
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, i)
+
+		if len(ids) > 0 {
+			break
+		}
+	}
+
+	if len(ids) == 0 {
+		_, err = repo.DB.Query(deleteGenreQuery, id)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	return errors.New("cannot delete genre")
+}
+
+func (repo *Repository) GetUserRoleBySessionId(sessionId string) (int, error) {
+	_, roleId, err := repo.getSession(sessionId)
+	if err != nil {
+		return 0, err
+	}
+
+	return roleId, nil
+}
+
+func (repo *Repository) GetUserIdBySessionId(sessionId string) (int, error) {
+	id, _, err := repo.getSession(sessionId)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (repo *Repository) GetCartItems(userId int) ([]*types.CartItemDB, error) {
+	rows, err := repo.DB.Query(getCartItemsQuery, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*types.CartItemDB
+	for rows.Next() {
+		var item types.CartItemDB
+		err = rows.Scan(
+			&item.BookId,
+			&item.Title,
+			&item.Quantity,
+			&item.UnitPrice)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, &item)
+	}
+
+	err = repo.getCartGenres(items)
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// getCartGenres fills GenreIds, which genre promotions are matched against.
+func (repo *Repository) getCartGenres(items []*types.CartItemDB) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(items))
+	byId := make(map[int]*types.CartItemDB, len(items))
+	for i, item := range items {
+		ids[i] = item.BookId
+		byId[item.BookId] = item
+	}
+
+	rows, err := repo.DB.Query(getBookGenresQuery, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookId int
+		var g types.GenreDB
+		err = rows.Scan(&bookId, &g.ID, &g.Name)
+		if err != nil {
+			return err
+		}
+
+		byId[bookId].GenreIds = append(byId[bookId].GenreIds, g.ID)
+	}
+
+	return nil
+}
+
+// AddCartItem snapshots the effective price of the book when it first enters
+// the cart; adding the same book again only changes the quantity.
+func (repo *Repository) AddCartItem(userId int, req types.AddCartItemRequest) error {
+	res, err := repo.DB.Exec(addCartItemQuery, userId, req.BookId, req.Quantity, time.Now())
+	if err != nil {
+		return errors.New("bad request")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return errors.New("bad request")
+	}
+
+	return nil
+}
+
+func (repo *Repository) UpdateCartItem(userId, bookId int, req types.UpdateCartItemRequest) error {
+	res, err := repo.DB.Exec(updateCartItemQuery, req.Quantity, time.Now(), userId, bookId)
+	if err != nil {
+		return errors.New("bad request")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (repo *Repository) DeleteCartItem(userId, bookId int) error {
+	_, err := repo.DB.Exec(deleteCartItemQuery, userId, bookId)
+	return err
+}
+
+func (repo *Repository) ClearCart(userId int) error {
+	_, err := repo.DB.Exec(clearCartQuery, userId)
+	return err
+}
+
+// CreateOrder turns the user's cart into a pending order. The cart rows are
+// locked, copied into order_items and removed in one transaction. Stocked
+// books are reserved until the order is paid or cancelled. req holds the cart
+// the discounts were worked out for, and promotion usage limits are checked
+// under lock before they are redeemed.
+func (repo *Repository) CreateOrder(userId int, req types.CreateOrderDB) (int, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(getCartItemsForUpdateQuery, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	var items []*types.CartItemDB
+	for rows.Next() {
+		var item types.CartItemDB
+		err = rows.Scan(
+			&item.BookId,
+			&item.Title,
+			&item.Quantity,
+			&item.UnitPrice,
+			&item.Currency)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		items = append(items, &item)
+	}
+	rows.Close()
+
+	if len(items) == 0 {
+		return 0, errors.New("cart is empty")
+	}
+
+	if !sameCart(items, req.Items) {
+		return 0, errors.New("cart has changed")
+	}
+
+	reserved := make(map[int]int, len(items))
+	for _, item := range items {
+		reserved[item.BookId], err = reserveStock(tx, item)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var subtotal int64
+	for _, item := range items {
+		if item.Currency != items[0].Currency {
+			return 0, errors.New("cart has books in different currencies")
+		}
+
+		subtotal += item.UnitPrice * int64(item.Quantity)
+	}
+
+	var discount int64
+	discounts := make(map[int][]*types.DiscountDB)
+	redeemed := make(map[int]int64)
+	for _, d := range req.Discounts {
+		discount += d.Amount
+		discounts[d.BookId] = append(discounts[d.BookId], d)
+		redeemed[d.PromotionId] += d.Amount
+	}
+
+	var id int
+	err = tx.QueryRow(createOrderQuery,
+		userId,
+		"pending",
+		subtotal,
+		discount,
+		subtotal-discount,
+		items[0].Currency,
+		req.CouponCode,
+		time.Now()).
+		Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range items {
+		var itemId int
+		err = tx.QueryRow(createOrderItemQuery,
+			id,
+			item.BookId,
+			item.Title,
+			item.Quantity,
+			item.UnitPrice,
+			reserved[item.BookId]).
+			Scan(&itemId)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, d := range discounts[item.BookId] {
+			_, err = tx.Exec(createOrderItemDiscountQuery, itemId, d.PromotionId, d.Description, d.Amount)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// locked in id order so that concurrent checkouts cannot deadlock
+	promotionIds := slices.Sorted(maps.Keys(redeemed))
+	for _, promotionId := range promotionIds {
+		err = redeemPromotion(tx, promotionId, id, userId, redeemed[promotionId])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(clearCartQuery, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(deleteCartCouponQuery, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func sameCart(items, priced []*types.CartItemDB) bool {
+	if len(items) != len(priced) {
+		return false
+	}
+
+	byId := make(map[int]*types.CartItemDB, len(priced))
+	for _, item := range priced {
+		byId[item.BookId] = item
+	}
+
+	for _, item := range items {
+		p, ok := byId[item.BookId]
+		if !ok || p.Quantity != item.Quantity || p.UnitPrice != item.UnitPrice {
+			return false
+		}
+	}
+
+	return true
+}
+
+func redeemPromotion(tx *sql.Tx, promotionId, orderId, userId int, amount int64) error {
+	var usageLimit, perUserLimit, used, usedByUser int
+	err := tx.QueryRow(lockPromotionUsageQuery, promotionId, userId).Scan(
+		&usageLimit,
+		&perUserLimit,
+		&used,
+		&usedByUser)
+	if err != nil {
+		return err
+	}
+
+	if usageLimit > 0 && used >= usageLimit {
+		return errors.New("promotion is no longer available")
+	}
+
+	if perUserLimit > 0 && usedByUser >= perUserLimit {
+		return errors.New("promotion has already been used")
+	}
+
+	_, err = tx.Exec(createPromotionRedemptionQuery, promotionId, orderId, userId, amount, time.Now())
+	return err
+}
+
+func (repo *Repository) GetAllOrders(req types.GetAllOrdersRequest) ([]*types.OrderDB, string, error) {
+	conditions, args := orderConditions(req)
+
+	after, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if after != nil {
+		args = append(args, after.ID)
+		conditions = append(conditions, fmt.Sprintf("o.id < $%d", len(args)))
+	}
+
+	query := getAllOrdersQuery
+	if len(conditions) > 0 {
+		query += "\nWHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := pageLimit(req.Limit)
+	args = append(args, limit+1)
+	query += fmt.Sprintf("\nORDER BY o.id DESC\nLIMIT $%d", len(args))
+
+	rows, err := repo.DB.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var orders []*types.OrderDB
+	for rows.Next() {
+		var o types.OrderDB
+		err = rows.Scan(
+			&o.ID,
+			&o.UserId,
+			&o.Status,
+			&o.Subtotal,
+			&o.Discount,
+			&o.Total,
+			&o.CouponCode,
+			&o.Currency,
+			&o.CreatedAt,
+			&o.UpdatedAt)
+		if err != nil {
+			return nil, "", err
+		}
+
+		orders = append(orders, &o)
+	}
+
+	var nextCursor string
+	if len(orders) > limit {
+		orders = orders[:limit]
+		nextCursor = encodeCursor(cursor{ID: orders[limit-1].ID})
+	}
+
+	err = repo.getOrderItems(orders)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return orders, nextCursor, nil
+}
+
+func (repo *Repository) CountOrders(req types.GetAllOrdersRequest) (int, error) {
+	conditions, args := orderConditions(req)
+
+	query := countOrdersQuery
+	if len(conditions) > 0 {
+		query += "\nWHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var count int
+	err := repo.DB.QueryRow(query, args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (repo *Repository) GetOrderById(id int) (*types.OrderDB, error) {
+	var o types.OrderDB
+	err := repo.DB.QueryRow(getOrderByIdQuery, id).Scan(
+		&o.ID,
+		&o.UserId,
+		&o.Status,
+		&o.Subtotal,
+		&o.Discount,
+		&o.Total,
+		&o.CouponCode,
+		&o.Currency,
+		&o.CreatedAt,
+		&o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.getOrderItems([]*types.OrderDB{&o})
+	if err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// UpdateOrderStatus only succeeds while the order is still in status from,
+// so two concurrent transitions cannot both win. Leaving pending settles
+// the stock reserved at checkout: paying turns it into a sale, cancelling
+// puts it back on the shelf.
+func (repo *Repository) UpdateOrderStatus(id int, from, to string) error {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = setOrderStatus(tx, id, from, to)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setOrderStatus(tx *sql.Tx, id int, from, to string) error {
+	res, err := tx.Exec(updateOrderStatusQuery, to, time.Now(), id, from)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errOrderStatusChanged
+	}
+
+	if from == "pending" {
+		switch to {
+		case "paid":
+			_, err = tx.Exec(sellStockQuery, id, time.Now())
+		case "cancelled":
+			_, err = tx.Exec(releaseStockQuery, id, time.Now())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reserveStock returns how many copies it reserved, which is none for
+// books that are not stocked. Paying or cancelling the order settles that
+// many.
+func reserveStock(tx *sql.Tx, item *types.CartItemDB) (int, error) {
+	res, err := tx.Exec(reserveStockQuery, item.BookId, item.Quantity, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		return item.Quantity, nil
+	}
+
+	var stocked bool
+	err = tx.QueryRow(isStockedQuery, item.BookId).Scan(&stocked)
+	if err != nil {
+		return 0, err
+	}
+
+	if stocked {
+		return 0, errors.New("not enough stock: " + item.Title)
+	}
+
+	return 0, nil
+}
+
+func (repo *Repository) GetInventory(bookId int) (*types.InventoryDB, error) {
+	var res types.InventoryDB
+	err := repo.DB.QueryRow(getInventoryQuery, bookId).Scan(
+		&res.BookId,
+		&res.Title,
+		&res.Tracked,
+		&res.OnHand,
+		&res.Reserved,
+		&res.LowStockThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// UpdateInventory also starts tracking stock for a book that had none.
+func (repo *Repository) UpdateInventory(bookId int, req types.UpdateInventoryRequest) error {
+	_, err := repo.DB.Exec(updateInventoryQuery, bookId, req.LowStockThreshold, time.Now())
+	if err != nil {
+		return errors.New("bad request")
+	}
+
+	return nil
+}
+
+// CreateInventoryAdjustment changes the stock on hand and records why in the
+// ledger. Stock can never drop below what is reserved by pending orders.
+func (repo *Repository) CreateInventoryAdjustment(bookId, userId int, req types.CreateInventoryAdjustmentRequest) (int, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(adjustStockQuery, bookId, req.Quantity, time.Now())
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23514" {
+			return 0, errors.New("not enough stock")
+		}
+
+		return 0, errors.New("bad request")
+	}
+
+	var id int
+	err = tx.QueryRow(createInventoryAdjustmentQuery,
+		bookId,
+		req.Quantity,
+		req.Reason,
+		req.Note,
+		userId,
+		time.Now()).
+		Scan(&id)
+	if err != nil {
+		return 0, errors.New("bad request")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (repo *Repository) GetInventoryAdjustments(bookId int, req types.PageRequest) ([]*types.InventoryAdjustmentDB, string, error) {
+	after, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := getInventoryAdjustmentsQuery
+	args := []any{bookId}
+	if after != nil {
+		args = append(args, after.ID)
+		query += fmt.Sprintf("\n  AND ia.id < $%d", len(args))
+	}
+
+	limit := pageLimit(req.Limit)
+	args = append(args, limit+1)
+	query += fmt.Sprintf("\nORDER BY ia.id DESC\nLIMIT $%d", len(args))
+
+	rows, err := repo.DB.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var resp []*types.InventoryAdjustmentDB
+	for rows.Next() {
+		var a types.InventoryAdjustmentDB
+		err = rows.Scan(
+			&a.ID,
+			&a.BookId,
+			&a.Quantity,
+			&a.Reason,
+			&a.Note,
+			&a.OrderId,
+			&a.UserId,
+			&a.CreatedAt)
+		if err != nil {
+			return nil, "", err
 		}
 
-		ids = append(ids, i)
+		resp = append(resp, &a)
+	}
 
-		if len(ids) > 0 {
-			break
+	var nextCursor string
+	if len(resp) > limit {
+		resp = resp[:limit]
+		nextCursor = encodeCursor(cursor{ID: resp[limit-1].ID})
+	}
+
+	return resp, nextCursor, nil
+}
+
+func (repo *Repository) CountInventoryAdjustments(bookId int) (int, error) {
+	var count int
+	err := repo.DB.QueryRow(countInventoryAdjustmentsQuery, bookId).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetLowStock lists stocked books whose available copies are at or below
+// their threshold, emptiest first.
+func (repo *Repository) GetLowStock() ([]*types.InventoryDB, error) {
+	rows, err := repo.DB.Query(getLowStockQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resp []*types.InventoryDB
+	for rows.Next() {
+		var res types.InventoryDB
+		err = rows.Scan(
+			&res.BookId,
+			&res.Title,
+			&res.Tracked,
+			&res.OnHand,
+			&res.Reserved,
+			&res.LowStockThreshold)
+		if err != nil {
+			return nil, err
 		}
+
+		resp = append(resp, &res)
 	}
 
-	if len(ids) == 0 {
-		_, err = repo.DB.Query(deleteAuthorQuery, id)
+	return resp, nil
+}
+
+func orderConditions(req types.GetAllOrdersRequest) ([]string, []any) {
+	var conditions []string
+	var args []any
+
+	if req.UserId != 0 {
+		args = append(args, req.UserId)
+		conditions = append(conditions, fmt.Sprintf("o.user_id = $%d", len(args)))
+	}
+
+	if req.Status != "" {
+		args = append(args, req.Status)
+		conditions = append(conditions, fmt.Sprintf("o.status = $%d", len(args)))
+	}
+
+	return conditions, args
+}
+
+func (repo *Repository) getOrderItems(orders []*types.OrderDB) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(orders))
+	byId := make(map[int]*types.OrderDB, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+		byId[o.ID] = o
+	}
+
+	rows, err := repo.DB.Query(getOrderItemsQuery, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var itemIds []int
+	items := make(map[int]*types.OrderItemDB)
+	for rows.Next() {
+		var orderId int
+		var item types.OrderItemDB
+		err = rows.Scan(
+			&item.ID,
+			&orderId,
+			&item.BookId,
+			&item.Title,
+			&item.Quantity,
+			&item.UnitPrice)
 		if err != nil {
 			return err
 		}
 
+		byId[orderId].Items = append(byId[orderId].Items, &item)
+		itemIds = append(itemIds, item.ID)
+		items[item.ID] = &item
+	}
+
+	if len(itemIds) == 0 {
 		return nil
 	}
 
-	return errors.New("cannot delete author")
+	discountRows, err := repo.DB.Query(getOrderItemDiscountsQuery, pq.Array(itemIds))
+	if err != nil {
+		return err
+	}
+	defer discountRows.Close()
+
+	for discountRows.Next() {
+		var itemId int
+		var d types.DiscountDB
+		err = discountRows.Scan(
+			&itemId,
+			&d.PromotionId,
+			&d.Description,
+			&d.Amount)
+		if err != nil {
+			return err
+		}
+
+		d.BookId = items[itemId].BookId
+		items[itemId].Discounts = append(items[itemId].Discounts, &d)
+	}
+
+	return nil
+}
+
+func (repo *Repository) SavePayment(p types.PaymentDB) error {
+	_, err := repo.DB.Exec(savePaymentQuery,
+		p.OrderId,
+		p.Provider,
+		p.ProviderPaymentId,
+		p.Status,
+		p.Amount,
+		p.Currency,
+		time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
-func (repo *Repository) GetAllGenres() ([]*types.GenreDB, error) {
-	rows, err := repo.DB.Query(getAllGenresQuery)
+func (repo *Repository) GetPaymentByOrderId(orderId int) (*types.PaymentDB, error) {
+	var p types.PaymentDB
+	err := repo.DB.QueryRow(getPaymentByOrderIdQuery, orderId).Scan(
+		&p.OrderId,
+		&p.Provider,
+		&p.ProviderPaymentId,
+		&p.Status,
+		&p.Amount,
+		&p.Currency)
 	if err != nil {
 		return nil, err
 	}
+
+	return &p, nil
+}
+
+// ProcessPaymentEvent applies a webhook event once and returns the order of
+// the payment, or 0 when the event was already handled. A captured payment
+// moves its order from pending to paid; for an order that has already moved
+// on, it returns ErrOrderNotPending.
+func (repo *Repository) ProcessPaymentEvent(event types.PaymentEventDB, status string) (int, error) {
+	tx, err := repo.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(createPaymentEventQuery,
+		event.Provider,
+		event.EventId,
+		event.Type,
+		event.ProviderPaymentId,
+		time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	var orderId int
+	err = tx.QueryRow(updatePaymentStatusQuery,
+		status,
+		time.Now(),
+		event.Provider,
+		event.ProviderPaymentId).
+		Scan(&orderId)
+	if err != nil {
+		return 0, err
+	}
+
+	var notPending bool
+	if status == "captured" {
+		err = setOrderStatus(tx, orderId, "pending", "paid")
+		notPending = errors.Is(err, errOrderStatusChanged)
+		if err != nil && !notPending {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	if notPending {
+		return orderId, ErrOrderNotPending
+	}
+
+	return orderId, nil
+}
+
+func (repo *Repository) GetAllPromotions(req types.PageRequest) ([]*types.PromotionDB, string, error) {
+	after, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := getAllPromotionsQuery
+	var args []any
+	if after != nil {
+		args = append(args, after.ID)
+		query += fmt.Sprintf("WHERE p.id > $%d\n", len(args))
+	}
+
+	limit := pageLimit(req.Limit)
+	args = append(args, limit+1)
+	query += fmt.Sprintf("ORDER BY p.id\nLIMIT $%d", len(args))
+
+	rows, err := repo.DB.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
 	defer rows.Close()
 
-	var genres []*types.GenreDB
+	var resp []*types.PromotionDB
 	for rows.Next() {
-		var genre types.GenreDB
-		err = rows.Scan(&genre.ID, &genre.Name)
+		var p types.PromotionDB
+		err = rows.Scan(promotionDest(&p)...)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
-		genres = append(genres, &genre)
+		resp = append(resp, &p)
+	}
+
+	var nextCursor string
+	if len(resp) > limit {
+		resp = resp[:limit]
+		nextCursor = encodeCursor(cursor{ID: resp[limit-1].ID})
 	}
 
-	return genres, nil
+	return resp, nextCursor, nil
 }
 
-func (repo *Repository) CreateGenre(req types.CreateGenreRequest) (int, error) {
+func (repo *Repository) CountPromotions() (int, error) {
+	var count int
+	err := repo.DB.QueryRow(countPromotionsQuery).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (repo *Repository) GetPromotionById(id int) (*types.PromotionDB, error) {
+	var p types.PromotionDB
+	err := repo.DB.QueryRow(getPromotionByIdQuery, id).Scan(promotionDest(&p)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (repo *Repository) CreatePromotion(req types.CreatePromotionRequest) (int, error) {
 	var id int
-	err := repo.DB.QueryRow(createGenreQuery, req.Name).Scan(&id)
+	err := repo.DB.QueryRow(createPromotionQuery,
+		req.Name,
+		req.Code,
+		req.Kind,
+		req.Value,
+		req.BuyQuantity,
+		req.GetQuantity,
+		req.BookId,
+		req.GenreId,
+		req.StartsAt,
+		req.EndsAt,
+		req.UsageLimit,
+		req.PerUserLimit,
+		req.Active == nil || *req.Active,
+		time.Now()).
+		Scan(&id)
 	if err != nil {
 		return 0, errors.New("bad request")
 	}
@@ -487,56 +3438,110 @@ func (repo *Repository) CreateGenre(req types.CreateGenreRequest) (int, error) {
 	return id, nil
 }
 
-func (repo *Repository) UpdateGenre(id int, req types.UpdateGenreRequest) error {
-	_, err := repo.DB.Query(updateGenreQuery, req.Name, id)
+func (repo *Repository) UpdatePromotion(id int, req types.UpdatePromotionRequest) error {
+	res, err := repo.DB.Exec(updatePromotionQuery,
+		req.Name,
+		req.Code,
+		req.Kind,
+		req.Value,
+		req.BuyQuantity,
+		req.GetQuantity,
+		req.BookId,
+		req.GenreId,
+		req.StartsAt,
+		req.EndsAt,
+		req.UsageLimit,
+		req.PerUserLimit,
+		req.Active == nil || *req.Active,
+		time.Now(),
+		id)
 	if err != nil {
 		return errors.New("bad request")
 	}
 
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
 
-func (repo *Repository) DeleteGenre(id int) error {
-	rows, err := repo.DB.Query(`select id from books where genre_id = $1`, id)
+func (repo *Repository) DeletePromotion(id int) error {
+	_, err := repo.DB.Exec(deletePromotionQuery, id)
+	return err
+}
+
+// GetActivePromotions returns the automatic promotions running at the given
+// time plus the one with the given code, if any, each with its redemption
+// counts. Orders that were cancelled do not count.
+func (repo *Repository) GetActivePromotions(userId int, code string, at time.Time) ([]*types.PromotionDB, error) {
+	rows, err := repo.DB.Query(getActivePromotionsQuery, userId, code, at)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	var ids []int
+	var resp []*types.PromotionDB
 	for rows.Next() {
-		var i int
-		err = rows.Scan(&i)
+		var p types.PromotionDB
+		err = rows.Scan(append(promotionDest(&p), &p.Used, &p.UsedByUser)...)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		ids = append(ids, i)
-
-		if len(ids) > 0 {
-			break
-		}
+		resp = append(resp, &p)
 	}
 
-	if len(ids) == 0 {
-		_, err = repo.DB.Query(deleteGenreQuery, id)
-		if err != nil {
-			return err
-		}
+	return resp, nil
+}
 
-		return nil
+func promotionDest(p *types.PromotionDB) []any {
+	return []any{
+		&p.ID,
+		&p.Name,
+		&p.Code,
+		&p.Kind,
+		&p.Value,
+		&p.BuyQuantity,
+		&p.GetQuantity,
+		&p.BookId,
+		&p.GenreId,
+		&p.StartsAt,
+		&p.EndsAt,
+		&p.UsageLimit,
+		&p.PerUserLimit,
+		&p.Active,
+		&p.CreatedAt,
+		&p.UpdatedAt,
 	}
-
-	return errors.New("cannot delete genre")
 }
 
-func (repo *Repository) GetUserRoleBySessionId(sessionId string) (int, error) {
-	var roleId int
-	err := repo.DB.QueryRow(`select role_id from users where session_id = $1`, sessionId).Scan(&roleId)
+func (repo *Repository) GetCartCoupon(userId int) (string, error) {
+	var code string
+	err := repo.DB.QueryRow(getCartCouponQuery, userId).Scan(&code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
 	if err != nil {
-		return 0, err
+		return "", err
 	}
 
-	return roleId, nil
+	return code, nil
+}
+
+func (repo *Repository) SetCartCoupon(userId int, code string) error {
+	_, err := repo.DB.Exec(setCartCouponQuery, userId, code, time.Now())
+	return err
+}
+
+func (repo *Repository) DeleteCartCoupon(userId int) error {
+	_, err := repo.DB.Exec(deleteCartCouponQuery, userId)
+	return err
 }
 
 func hashingPassword(password string) (string, error) {
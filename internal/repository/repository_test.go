@@ -69,7 +69,7 @@ func (c *TestContainer) getDB(t *testing.T) *sql.DB {
 	)
 	require.NoError(t, err)
 
-	err = m.Migrate(1)
+	err = m.Up()
 	require.NoError(t, err)
 
 	return db
@@ -178,7 +178,7 @@ func TestRepository_GetAllUsers(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
 
-	res, err := repo.GetAllUsers()
+	res, _, err := repo.GetAllUsers(types.PageRequest{})
 	require.NoError(t, err)
 	require.Len(t, res, 1)
 	require.Equal(t, id, res[0].ID)
@@ -195,7 +195,7 @@ func TestRepository_GetAllUsers(t *testing.T) {
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			listUsers, err := repo.GetAllUsers()
+			listUsers, _, err := repo.GetAllUsers(types.PageRequest{})
 			require.Equal(t, tt.err, err)
 			require.Equal(t, tt.res, listUsers)
 		})
@@ -421,8 +421,8 @@ func TestRepository_GetAllBooks(t *testing.T) {
 	require.Equal(t, id, 2)
 
 	id, err = repo.CreateBook(types.CreateBookRequest{
-		AuthorId: 1,
-		GenreId:  1,
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
 		Title:    "foo",
 	})
 	require.NoError(t, err)
@@ -433,8 +433,8 @@ func TestRepository_GetAllBooks(t *testing.T) {
 	require.NoError(t, err)
 
 	id, err = repo.CreateBook(types.CreateBookRequest{
-		AuthorId: 2,
-		GenreId:  2,
+		Authors:  []types.BookAuthorRequest{{AuthorId: 2}},
+		GenreIds: []int{2},
 		Title:    "bar",
 	})
 	require.NoError(t, err)
@@ -449,30 +449,34 @@ func TestRepository_GetAllBooks(t *testing.T) {
 		res []*types.BookDB
 		err error
 	}{
-		"case 01: fail author_id": {
+		"case 01: success author_id and title": {
 			req: types.GetAllBooksRequest{
-				Filter: "author_id",
-				ID:     "1a",
+				Filter: types.BookFilter{
+					AuthorIds:     []int{1, 2},
+					TitleContains: "FO",
+				},
 			},
-			res: nil,
-			err: errors.New("bad author id"),
+			res: []*types.BookDB{
+				{
+					ID:        1,
+					Authors:   []types.BookAuthorDB{{ID: 1, Name: "John", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 1, Name: "foo"}},
+					Title:     "foo",
+					CreatedAt: createdAt,
+					UpdatedAt: updatedAt,
+				},
+			},
+			err: nil,
 		},
 		"case 02: success author_id": {
 			req: types.GetAllBooksRequest{
-				Filter: "author_id",
-				ID:     "1",
+				Filter: types.BookFilter{AuthorIds: []int{1}},
 			},
 			res: []*types.BookDB{
 				{
-					ID: 1,
-					Author: types.AuthorDB{
-						ID:   1,
-						Name: "John",
-					},
-					Genre: types.GenreDB{
-						ID:   1,
-						Name: "foo",
-					},
+					ID:        1,
+					Authors:   []types.BookAuthorDB{{ID: 1, Name: "John", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 1, Name: "foo"}},
 					Title:     "foo",
 					CreatedAt: createdAt,
 					UpdatedAt: updatedAt,
@@ -480,30 +484,25 @@ func TestRepository_GetAllBooks(t *testing.T) {
 			},
 			err: nil,
 		},
-		"case 03: fail genre_id": {
+		"case 03: success no match": {
 			req: types.GetAllBooksRequest{
-				Filter: "genre_id",
-				ID:     "1a",
+				Filter: types.BookFilter{
+					GenreIds:     []int{1},
+					CreatedAfter: &updatedAt2,
+				},
 			},
 			res: nil,
-			err: errors.New("bad genre id"),
+			err: nil,
 		},
 		"case 04: success genre_id": {
 			req: types.GetAllBooksRequest{
-				Filter: "genre_id",
-				ID:     "2",
+				Filter: types.BookFilter{GenreIds: []int{2}},
 			},
 			res: []*types.BookDB{
 				{
-					ID: 2,
-					Author: types.AuthorDB{
-						ID:   2,
-						Name: "Jane",
-					},
-					Genre: types.GenreDB{
-						ID:   2,
-						Name: "bar",
-					},
+					ID:        2,
+					Authors:   []types.BookAuthorDB{{ID: 2, Name: "Jane", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 2, Name: "bar"}},
 					Title:     "bar",
 					CreatedAt: createdAt2,
 					UpdatedAt: updatedAt2,
@@ -518,29 +517,17 @@ func TestRepository_GetAllBooks(t *testing.T) {
 			},
 			res: []*types.BookDB{
 				{
-					ID: 1,
-					Author: types.AuthorDB{
-						ID:   1,
-						Name: "John",
-					},
-					Genre: types.GenreDB{
-						ID:   1,
-						Name: "foo",
-					},
+					ID:        1,
+					Authors:   []types.BookAuthorDB{{ID: 1, Name: "John", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 1, Name: "foo"}},
 					Title:     "foo",
 					CreatedAt: createdAt,
 					UpdatedAt: updatedAt,
 				},
 				{
-					ID: 2,
-					Author: types.AuthorDB{
-						ID:   2,
-						Name: "Jane",
-					},
-					Genre: types.GenreDB{
-						ID:   2,
-						Name: "bar",
-					},
+					ID:        2,
+					Authors:   []types.BookAuthorDB{{ID: 2, Name: "Jane", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 2, Name: "bar"}},
 					Title:     "bar",
 					CreatedAt: createdAt2,
 					UpdatedAt: updatedAt2,
@@ -555,29 +542,17 @@ func TestRepository_GetAllBooks(t *testing.T) {
 			},
 			res: []*types.BookDB{
 				{
-					ID: 2,
-					Author: types.AuthorDB{
-						ID:   2,
-						Name: "Jane",
-					},
-					Genre: types.GenreDB{
-						ID:   2,
-						Name: "bar",
-					},
+					ID:        2,
+					Authors:   []types.BookAuthorDB{{ID: 2, Name: "Jane", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 2, Name: "bar"}},
 					Title:     "bar",
 					CreatedAt: createdAt2,
 					UpdatedAt: updatedAt2,
 				},
 				{
-					ID: 1,
-					Author: types.AuthorDB{
-						ID:   1,
-						Name: "John",
-					},
-					Genre: types.GenreDB{
-						ID:   1,
-						Name: "foo",
-					},
+					ID:        1,
+					Authors:   []types.BookAuthorDB{{ID: 1, Name: "John", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 1, Name: "foo"}},
 					Title:     "foo",
 					CreatedAt: createdAt,
 					UpdatedAt: updatedAt,
@@ -592,29 +567,17 @@ func TestRepository_GetAllBooks(t *testing.T) {
 			},
 			res: []*types.BookDB{
 				{
-					ID: 2,
-					Author: types.AuthorDB{
-						ID:   2,
-						Name: "Jane",
-					},
-					Genre: types.GenreDB{
-						ID:   2,
-						Name: "bar",
-					},
+					ID:        2,
+					Authors:   []types.BookAuthorDB{{ID: 2, Name: "Jane", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 2, Name: "bar"}},
 					Title:     "bar",
 					CreatedAt: createdAt2,
 					UpdatedAt: updatedAt2,
 				},
 				{
-					ID: 1,
-					Author: types.AuthorDB{
-						ID:   1,
-						Name: "John",
-					},
-					Genre: types.GenreDB{
-						ID:   1,
-						Name: "foo",
-					},
+					ID:        1,
+					Authors:   []types.BookAuthorDB{{ID: 1, Name: "John", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 1, Name: "foo"}},
 					Title:     "foo",
 					CreatedAt: createdAt,
 					UpdatedAt: updatedAt,
@@ -629,29 +592,17 @@ func TestRepository_GetAllBooks(t *testing.T) {
 			},
 			res: []*types.BookDB{
 				{
-					ID: 1,
-					Author: types.AuthorDB{
-						ID:   1,
-						Name: "John",
-					},
-					Genre: types.GenreDB{
-						ID:   1,
-						Name: "foo",
-					},
+					ID:        1,
+					Authors:   []types.BookAuthorDB{{ID: 1, Name: "John", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 1, Name: "foo"}},
 					Title:     "foo",
 					CreatedAt: createdAt,
 					UpdatedAt: updatedAt,
 				},
 				{
-					ID: 2,
-					Author: types.AuthorDB{
-						ID:   2,
-						Name: "Jane",
-					},
-					Genre: types.GenreDB{
-						ID:   2,
-						Name: "bar",
-					},
+					ID:        2,
+					Authors:   []types.BookAuthorDB{{ID: 2, Name: "Jane", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 2, Name: "bar"}},
 					Title:     "bar",
 					CreatedAt: createdAt2,
 					UpdatedAt: updatedAt2,
@@ -659,17 +610,114 @@ func TestRepository_GetAllBooks(t *testing.T) {
 			},
 			err: nil,
 		},
+		"case 09: success title asc next page": {
+			req: types.GetAllBooksRequest{
+				SortBy:  "title",
+				OrderBy: "asc",
+				Cursor:  encodeCursor(cursor{ID: 2, Value: "bar"}),
+			},
+			res: []*types.BookDB{
+				{
+					ID:        1,
+					Authors:   []types.BookAuthorDB{{ID: 1, Name: "John", Role: "author"}},
+					Genres:    []types.GenreDB{{ID: 1, Name: "foo"}},
+					Title:     "foo",
+					CreatedAt: createdAt,
+					UpdatedAt: updatedAt,
+				},
+			},
+			err: nil,
+		},
 	}
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			res, err := repo.GetAllBooks(tt.req)
+			res, _, err := repo.GetAllBooks(tt.req)
 			require.Equal(t, tt.err, err)
 			require.Equal(t, tt.res, res)
 		})
 	}
 }
 
+func TestRepository_GetAllBooksSearch(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	id, err := repo.CreateAuthor(types.CreateAuthorRequest{Name: "Tolkien"})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	id, err = repo.CreateGenre(types.CreateGenreRequest{Name: "Fantasy"})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	id, err = repo.CreateBook(types.CreateBookRequest{
+		Authors:     []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds:    []int{1},
+		Title:       "The Hobbit",
+		Description: "A dragon guards the treasure",
+	})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	id, err = repo.CreateBook(types.CreateBookRequest{
+		Authors:     []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds:    []int{1},
+		Title:       "Dragons of the north",
+		Description: "A dragon and another dragon",
+	})
+	require.NoError(t, err)
+	require.Equal(t, id, 2)
+
+	tests := map[string]struct {
+		req     types.GetAllBooksRequest
+		ids     []int
+		snippet string
+	}{
+		"case 01: success title": {
+			req:     types.GetAllBooksRequest{Query: "hobbit"},
+			ids:     []int{1},
+			snippet: "The <mark>Hobbit</mark>",
+		},
+		"case 02: success author": {
+			req: types.GetAllBooksRequest{Query: "tolkien"},
+			ids: []int{1, 2},
+		},
+		"case 03: success relevance": {
+			req: types.GetAllBooksRequest{Query: "dragon"},
+			ids: []int{2, 1},
+		},
+		"case 04: success no match": {
+			req: types.GetAllBooksRequest{Query: "spaceship"},
+			ids: nil,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			res, _, err := repo.GetAllBooks(tt.req)
+			require.NoError(t, err)
+
+			var ids []int
+			for _, b := range res {
+				ids = append(ids, b.ID)
+			}
+			require.Equal(t, tt.ids, ids)
+
+			if tt.snippet != "" {
+				require.Contains(t, res[0].Snippet, tt.snippet)
+			}
+
+			count, err := repo.CountBooks(tt.req)
+			require.NoError(t, err)
+			require.Equal(t, len(tt.ids), count)
+		})
+	}
+}
+
 func TestRepository_GetBookByID(t *testing.T) {
 	container := newTestContainer(t)
 	defer container.terminate(t)
@@ -686,8 +734,8 @@ func TestRepository_GetBookByID(t *testing.T) {
 	require.Equal(t, id, 1)
 
 	id, err = repo.CreateBook(types.CreateBookRequest{
-		AuthorId: 1,
-		GenreId:  1})
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1}})
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
 
@@ -708,15 +756,9 @@ func TestRepository_GetBookByID(t *testing.T) {
 		"case 02: success": {
 			id: 1,
 			res: &types.BookDB{
-				ID: 1,
-				Author: types.AuthorDB{
-					ID:   1,
-					Name: "John",
-				},
-				Genre: types.GenreDB{
-					ID:   1,
-					Name: "foo",
-				},
+				ID:        1,
+				Authors:   []types.BookAuthorDB{{ID: 1, Name: "John", Role: "author"}},
+				Genres:    []types.GenreDB{{ID: 1, Name: "foo"}},
 				CreatedAt: createdAt,
 				UpdatedAt: updatedAt,
 			},
@@ -754,16 +796,16 @@ func TestRepository_CreateBook(t *testing.T) {
 	}{
 		"case 01: success": {
 			req: types.CreateBookRequest{
-				AuthorId: 1,
-				GenreId:  1,
+				Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+				GenreIds: []int{1},
 			},
 			id:  1,
 			err: nil,
 		},
 		"case 02: bad request": {
 			req: types.CreateBookRequest{
-				AuthorId: 0,
-				GenreId:  0,
+				Authors:  []types.BookAuthorRequest{{AuthorId: 0}},
+				GenreIds: []int{0},
 			},
 			id:  0,
 			err: errors.New("bad request"),
@@ -779,6 +821,71 @@ func TestRepository_CreateBook(t *testing.T) {
 	}
 }
 
+func TestRepository_CreateBookContributors(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	id, err := repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	id, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "Jane"})
+	require.NoError(t, err)
+	require.Equal(t, id, 2)
+
+	id, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	id, err = repo.CreateGenre(types.CreateGenreRequest{Name: "bar"})
+	require.NoError(t, err)
+	require.Equal(t, id, 2)
+
+	id, err = repo.CreateBook(types.CreateBookRequest{
+		Authors: []types.BookAuthorRequest{
+			{AuthorId: 2},
+			{AuthorId: 1, Role: "translator"},
+		},
+		GenreIds: []int{2, 1},
+		Title:    "foo",
+	})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	_, err = repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1, Role: "narrator"}},
+		GenreIds: []int{1},
+		Title:    "bar",
+	})
+	require.Equal(t, errors.New("bad request"), err)
+
+	res, err := repo.GetBookByID(1)
+	require.NoError(t, err)
+	require.Equal(t, []types.BookAuthorDB{
+		{ID: 2, Name: "Jane", Role: "author"},
+		{ID: 1, Name: "John", Role: "translator"},
+	}, res.Authors)
+	require.Equal(t, []types.GenreDB{
+		{ID: 2, Name: "bar"},
+		{ID: 1, Name: "foo"},
+	}, res.Genres)
+
+	books, _, err := repo.GetAllBooks(types.GetAllBooksRequest{
+		Filter: types.BookFilter{
+			AuthorIds: []int{1},
+			GenreIds:  []int{1},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, books, 1)
+
+	err = repo.DeleteAuthor(1)
+	require.Equal(t, errors.New("cannot delete author"), err)
+}
+
 func TestRepository_UpdateBook(t *testing.T) {
 	container := newTestContainer(t)
 	defer container.terminate(t)
@@ -795,8 +902,8 @@ func TestRepository_UpdateBook(t *testing.T) {
 	require.Equal(t, id, 1)
 
 	id, err = repo.CreateBook(types.CreateBookRequest{
-		AuthorId: 1,
-		GenreId:  1})
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1}})
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
 
@@ -808,16 +915,16 @@ func TestRepository_UpdateBook(t *testing.T) {
 		"case 01: bad request": {
 			id: 1,
 			req: types.UpdateBookRequest{
-				AuthorId: 0,
-				GenreId:  0,
+				Authors:  []types.BookAuthorRequest{{AuthorId: 0}},
+				GenreIds: []int{0},
 			},
 			err: errors.New("bad request"),
 		},
 		"case 02: success": {
 			id: 1,
 			req: types.UpdateBookRequest{
-				AuthorId: 1,
-				GenreId:  1,
+				Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+				GenreIds: []int{1},
 				Title:    "test",
 			},
 			err: nil,
@@ -848,8 +955,8 @@ func TestRepository_DeleteBook(t *testing.T) {
 	require.Equal(t, id, 1)
 
 	id, err = repo.CreateBook(types.CreateBookRequest{
-		AuthorId: 1,
-		GenreId:  1,
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
 	})
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
@@ -895,40 +1002,125 @@ func TestRepository_UploadFileByBookId(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
 
-	id, err = repo.CreateBook(types.CreateBookRequest{
-		AuthorId: 1,
-		GenreId:  1,
-	})
-	require.NoError(t, err)
-	require.Equal(t, id, 1)
+	for i := 1; i <= 2; i++ {
+		id, err = repo.CreateBook(types.CreateBookRequest{
+			Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+			GenreIds: []int{1},
+		})
+		require.NoError(t, err)
+		require.Equal(t, id, i)
+	}
 
-	tests := map[string]struct {
-		id          int
-		filename    string
-		oldFilename string
-		err         error
+	tests := []struct {
+		name      string
+		id        int
+		objectKey string
+		stored    bool
+		unused    string
+		err       error
 	}{
-		"case 01: bad request": {
-			id:          0,
-			filename:    "foo",
-			oldFilename: "",
-			err:         sql.ErrNoRows,
+		{
+			name:      "case 01: bad request",
+			id:        0,
+			objectKey: "sha256/aaa",
+			stored:    false,
+			unused:    "",
+			err:       sql.ErrNoRows,
 		},
-		"case 02: success": {
-			id:          1,
-			filename:    "foo",
-			oldFilename: "",
-			err:         nil,
+		{
+			name:      "case 02: success",
+			id:        1,
+			objectKey: "sha256/aaa",
+			stored:    true,
+			unused:    "",
+			err:       nil,
+		},
+		{
+			name:      "case 03: same content for another book",
+			id:        2,
+			objectKey: "sha256/aaa",
+			stored:    false,
+			unused:    "",
+			err:       nil,
+		},
+		{
+			name:      "case 04: shared file is replaced",
+			id:        1,
+			objectKey: "sha256/bbb",
+			stored:    true,
+			unused:    "",
+			err:       nil,
+		},
+		{
+			name:      "case 05: last reference is replaced",
+			id:        2,
+			objectKey: "sha256/bbb",
+			stored:    false,
+			unused:    "sha256/aaa",
+			err:       nil,
+		},
+		{
+			name:      "case 06: unused file is stored again",
+			id:        2,
+			objectKey: "sha256/aaa",
+			stored:    true,
+			unused:    "",
+			err:       nil,
+		},
+		{
+			name:      "case 07: failed store",
+			id:        1,
+			objectKey: "sha256/ccc",
+			stored:    true,
+			unused:    "",
+			err:       errors.New("store failed"),
 		},
 	}
 
-	for name, tt := range tests {
-		t.Run(name, func(t *testing.T) {
-			oldFilename, err := repo.UploadFileByBookId(tt.id, tt.filename)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := false
+			unused, err := repo.UploadFileByBookId(tt.id, types.BookFileDB{Filename: "book.pdf", ObjectKey: tt.objectKey}, func() error {
+				stored = true
+				if tt.objectKey == "sha256/ccc" {
+					return errors.New("store failed")
+				}
+				return nil
+			})
 			require.Equal(t, tt.err, err)
-			require.Equal(t, tt.oldFilename, oldFilename)
+			require.Equal(t, tt.stored, stored)
+			require.Equal(t, tt.unused, unused)
 		})
 	}
+
+	// the book taking sha256/aaa up again keeps it from being removed
+	removed := false
+	err = repo.DeleteFile("sha256/aaa", func() error {
+		removed = true
+		return nil
+	})
+	require.NoError(t, err)
+	require.False(t, removed)
+
+	unused, err := repo.DeleteBook(1)
+	require.NoError(t, err)
+	require.Equal(t, "sha256/bbb", unused)
+
+	err = repo.DeleteFile("sha256/bbb", func() error {
+		return errors.New("remove failed")
+	})
+	require.Equal(t, errors.New("remove failed"), err)
+
+	err = repo.DeleteFile("sha256/bbb", func() error {
+		removed = true
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, removed)
+
+	unused, err = repo.DeleteBook(2)
+	require.NoError(t, err)
+	require.Equal(t, "sha256/aaa", unused)
 }
 
 func TestRepository_GetFileByBookId(t *testing.T) {
@@ -946,40 +1138,57 @@ func TestRepository_GetFileByBookId(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
 
-	id, err = repo.CreateBook(types.CreateBookRequest{
-		AuthorId: 1,
-		GenreId:  1,
-	})
+	for i := 1; i <= 2; i++ {
+		id, err = repo.CreateBook(types.CreateBookRequest{
+			Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+			GenreIds: []int{1},
+		})
+		require.NoError(t, err)
+		require.Equal(t, id, i)
+	}
+
+	size := int64(42)
+	file := types.BookFileDB{Filename: "book.pdf", ObjectKey: "sha256/aaa", Size: &size}
+	_, err = repo.UploadFileByBookId(2, file, func() error { return nil })
 	require.NoError(t, err)
-	require.Equal(t, id, 1)
 
 	tests := map[string]struct {
-		id       int
-		filename string
-		err      error
+		id      int
+		file    *types.BookFileDB
+		wantErr bool
 	}{
 		"case 01: bad request": {
-			id:       0,
-			filename: "",
-			err:      sql.ErrNoRows,
+			id:      0,
+			file:    nil,
+			wantErr: true,
 		},
-		"case 02: success": {
-			id:       1,
-			filename: "",
-			err:      nil,
+		"case 02: book without a file": {
+			id:      1,
+			file:    nil,
+			wantErr: true,
+		},
+		"case 03: success": {
+			id:      2,
+			file:    &file,
+			wantErr: false,
 		},
 	}
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			filename, err := repo.GetFileByBookId(tt.id)
-			require.Equal(t, tt.err, err)
-			require.Equal(t, tt.filename, filename)
+			res, err := repo.GetFileByBookId(tt.id)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.file, res)
 		})
 	}
 }
 
-func TestRepository_GetAllAuthors(t *testing.T) {
+func TestRepository_FileMetadata(t *testing.T) {
 	container := newTestContainer(t)
 	defer container.terminate(t)
 	db := container.getDB(t)
@@ -990,31 +1199,76 @@ func TestRepository_GetAllAuthors(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
 
+	id, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	for i := 1; i <= 2; i++ {
+		id, err = repo.CreateBook(types.CreateBookRequest{
+			Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+			GenreIds: []int{1},
+			Title:    "Kept",
+		})
+		require.NoError(t, err)
+		require.Equal(t, id, i)
+	}
+
+	size := int64(42)
+	_, err = repo.UploadFileByBookId(1, types.BookFileDB{Filename: "book.epub", ObjectKey: "sha256/aaa", Size: &size}, func() error { return nil })
+	require.NoError(t, err)
+
+	meta, err := repo.GetFileMetadata("sha256/aaa")
+	require.NoError(t, err)
+	require.Nil(t, meta.ExtractedAt, "not read yet")
+	require.Equal(t, sql.ErrNoRows, repo.FillBookFromFile(1), "nothing to fill from")
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	want := types.FileMetadataDB{
+		Title:       "The Go Programming Language",
+		Authors:     []string{"Alan A. A. Donovan", "Brian W. Kernighan"},
+		ISBN:        "9780134190440",
+		Language:    "en",
+		Description: "The authoritative resource.",
+		ExtractedAt: &now,
+	}
+	require.Equal(t, sql.ErrNoRows, repo.SetFileMetadata("sha256/bbb", want))
+	require.NoError(t, repo.SetFileMetadata("sha256/aaa", want))
+
+	meta, err = repo.GetFileMetadata("sha256/aaa")
+	require.NoError(t, err)
+	require.True(t, now.Equal(*meta.ExtractedAt))
+	meta.ExtractedAt = want.ExtractedAt
+	require.Equal(t, &want, meta)
+
 	tests := map[string]struct {
-		res []*types.AuthorDB
+		id  int
 		err error
 	}{
-		"case 01: success": {
-			res: []*types.AuthorDB{
-				{
-					ID:   1,
-					Name: "John",
-				},
-			},
+		"case 01: book without a file": {
+			id:  2,
+			err: sql.ErrNoRows,
+		},
+		"case 02: success": {
+			id:  1,
 			err: nil,
 		},
 	}
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			res, err := repo.GetAllAuthors()
-			require.Equal(t, tt.res, res)
+			err := repo.FillBookFromFile(tt.id)
 			require.Equal(t, tt.err, err)
 		})
 	}
+
+	book, err := repo.GetBookByID(1)
+	require.NoError(t, err)
+	require.Equal(t, "Kept", book.Title, "fields the book has are kept")
+	require.Equal(t, want.ISBN, book.ISBN)
+	require.Equal(t, want.Description, book.Description)
 }
 
-func TestRepository_GetAuthorById(t *testing.T) {
+func TestRepository_Uploads(t *testing.T) {
 	container := newTestContainer(t)
 	defer container.terminate(t)
 	db := container.getDB(t)
@@ -1025,35 +1279,94 @@ func TestRepository_GetAuthorById(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
 
-	tests := map[string]struct {
-		id  int
-		res *types.AuthorDB
-		err error
+	id, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	id, err = repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+	})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	err = repo.CreateUpload(types.UploadDB{
+		ID:              "upload-1",
+		BookId:          1,
+		Filename:        "book.m4b",
+		ObjectKey:       "uploads/upload-1",
+		StorageUploadID: "storage-1",
+		Length:          100,
+		CreatedAt:       time.Now(),
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		offset int64
+		size   int64
+		err    error
 	}{
-		"case 01: bad request": {
-			id:  0,
-			res: nil,
-			err: sql.ErrNoRows,
+		{
+			name:   "case 01: success",
+			offset: 0,
+			size:   60,
+			err:    nil,
 		},
-		"case 02: success": {
-			id: 1,
-			res: &types.AuthorDB{
-				ID:   1,
-				Name: "John",
-			},
+		{
+			name:   "case 02: chunk at an old offset",
+			offset: 0,
+			size:   40,
+			err:    sql.ErrNoRows,
+		},
+		{
+			name:   "case 03: next chunk",
+			offset: 60,
+			size:   40,
+			err:    nil,
 		},
 	}
 
-	for name, tt := range tests {
-		t.Run(name, func(t *testing.T) {
-			res, err := repo.GetAuthorById(tt.id)
-			require.Equal(t, tt.res, res)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			written, err := repo.GetWrittenUploads()
+			require.NoError(t, err)
+			require.Len(t, written, 0)
+
+			err = repo.AdvanceUpload("upload-1", tt.offset, tt.size, []byte(tt.name))
 			require.Equal(t, tt.err, err)
 		})
 	}
+
+	written, err := repo.GetWrittenUploads()
+	require.NoError(t, err)
+	require.Len(t, written, 1)
+	require.Equal(t, "upload-1", written[0].ID)
+
+	upload, err := repo.GetUpload("upload-1")
+	require.NoError(t, err)
+	require.Equal(t, int64(100), upload.Offset)
+	require.Equal(t, 2, upload.Parts)
+	require.Equal(t, []byte("case 03: next chunk"), upload.HashState)
+
+	exists, err := repo.UploadExists("storage-1")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	stale, err := repo.DeleteStaleUploads(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, stale, 0)
+
+	stale, err = repo.DeleteStaleUploads(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+	require.Equal(t, "storage-1", stale[0].StorageUploadID)
+
+	_, err = repo.GetUpload("upload-1")
+	require.Equal(t, sql.ErrNoRows, err)
 }
 
-func TestRepository_CreateAuthor(t *testing.T) {
+func TestRepository_GetAllAuthors(t *testing.T) {
 	container := newTestContainer(t)
 	defer container.terminate(t)
 	db := container.getDB(t)
@@ -1064,21 +1377,149 @@ func TestRepository_CreateAuthor(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
 
+	id, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "Jane"})
+	require.NoError(t, err)
+	require.Equal(t, id, 2)
+
 	tests := map[string]struct {
-		req types.CreateAuthorRequest
-		id  int
-		err error
+		req        types.PageRequest
+		res        []*types.AuthorDB
+		nextCursor string
+		err        error
 	}{
 		"case 01: success": {
-			req: types.CreateAuthorRequest{
-				Name: "Jane",
+			req: types.PageRequest{},
+			res: []*types.AuthorDB{
+				{
+					ID:   1,
+					Name: "John",
+				},
+				{
+					ID:   2,
+					Name: "Jane",
+				},
 			},
-			id:  2,
 			err: nil,
 		},
-		"case 02: bad request": {
-			req: types.CreateAuthorRequest{
-				Name: "John",
+		"case 02: success first page": {
+			req: types.PageRequest{Limit: 1},
+			res: []*types.AuthorDB{
+				{
+					ID:   1,
+					Name: "John",
+				},
+			},
+			nextCursor: encodeCursor(cursor{ID: 1}),
+			err:        nil,
+		},
+		"case 03: success last page": {
+			req: types.PageRequest{Cursor: encodeCursor(cursor{ID: 1}), Limit: 1},
+			res: []*types.AuthorDB{
+				{
+					ID:   2,
+					Name: "Jane",
+				},
+			},
+			err: nil,
+		},
+		"case 04: fail cursor": {
+			req: types.PageRequest{Cursor: "foo"},
+			res: nil,
+			err: errors.New("bad cursor"),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			res, nextCursor, err := repo.GetAllAuthors(tt.req)
+			require.Equal(t, tt.res, res)
+			require.Equal(t, tt.nextCursor, nextCursor)
+			require.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestRepository_CountAuthors(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	id, err := repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	count, err := repo.CountAuthors()
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestRepository_GetAuthorById(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	id, err := repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	tests := map[string]struct {
+		id  int
+		res *types.AuthorDB
+		err error
+	}{
+		"case 01: bad request": {
+			id:  0,
+			res: nil,
+			err: sql.ErrNoRows,
+		},
+		"case 02: success": {
+			id: 1,
+			res: &types.AuthorDB{
+				ID:   1,
+				Name: "John",
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			res, err := repo.GetAuthorById(tt.id)
+			require.Equal(t, tt.res, res)
+			require.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestRepository_CreateAuthor(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	id, err := repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+	require.Equal(t, id, 1)
+
+	tests := map[string]struct {
+		req types.CreateAuthorRequest
+		id  int
+		err error
+	}{
+		"case 01: success": {
+			req: types.CreateAuthorRequest{
+				Name: "Jane",
+			},
+			id:  2,
+			err: nil,
+		},
+		"case 02: bad request": {
+			req: types.CreateAuthorRequest{
+				Name: "John",
 			},
 			id:  0,
 			err: errors.New("bad request"),
@@ -1158,8 +1599,8 @@ func TestRepository_DeleteAuthor(t *testing.T) {
 	require.Equal(t, id, 1)
 
 	id, err = repo.CreateBook(types.CreateBookRequest{
-		AuthorId: 1,
-		GenreId:  1,
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
 	})
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
@@ -1214,7 +1655,7 @@ func TestRepository_GetAllGenres(t *testing.T) {
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			res, err := repo.GetAllGenres()
+			res, _, err := repo.GetAllGenres(types.PageRequest{})
 			require.Equal(t, tt.err, err)
 			require.Equal(t, tt.res, res)
 		})
@@ -1325,8 +1766,8 @@ func TestRepository_DeleteGenre(t *testing.T) {
 	require.Equal(t, id, 2)
 
 	id, err = repo.CreateBook(types.CreateBookRequest{
-		AuthorId: 1,
-		GenreId:  2})
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{2}})
 	require.NoError(t, err)
 	require.Equal(t, id, 1)
 
@@ -1351,3 +1792,1418 @@ func TestRepository_DeleteGenre(t *testing.T) {
 		})
 	}
 }
+
+func TestRepository_AddCartItem(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	bookId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "foo",
+	})
+	require.NoError(t, err)
+
+	// cases run in order, each one adds to the cart left by the previous
+	tests := []struct {
+		name string
+		req  types.AddCartItemRequest
+		res  []*types.CartItemDB
+		err  error
+	}{
+		{
+			name: "case 01: success",
+			req:  types.AddCartItemRequest{BookId: bookId, Quantity: 1},
+			res: []*types.CartItemDB{
+				{BookId: bookId, Title: "foo", Quantity: 1},
+			},
+			err: nil,
+		},
+		{
+			name: "case 02: success same book",
+			req:  types.AddCartItemRequest{BookId: bookId, Quantity: 2},
+			res: []*types.CartItemDB{
+				{BookId: bookId, Title: "foo", Quantity: 3},
+			},
+			err: nil,
+		},
+		{
+			name: "case 03: bad request",
+			req:  types.AddCartItemRequest{BookId: 100, Quantity: 1},
+			res: []*types.CartItemDB{
+				{BookId: bookId, Title: "foo", Quantity: 3},
+			},
+			err: errors.New("bad request"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := repo.AddCartItem(userId, tt.req)
+			require.Equal(t, tt.err, err)
+
+			res, err := repo.GetCartItems(userId)
+			require.NoError(t, err)
+			require.Equal(t, tt.res, res)
+		})
+	}
+}
+
+func TestRepository_UpdateCartItem(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	bookId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "foo",
+	})
+	require.NoError(t, err)
+
+	err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: bookId, Quantity: 1})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		bookId int
+		req    types.UpdateCartItemRequest
+		err    error
+	}{
+		"case 01: fail": {
+			bookId: 100,
+			req:    types.UpdateCartItemRequest{Quantity: 5},
+			err:    sql.ErrNoRows,
+		},
+		"case 02: success": {
+			bookId: bookId,
+			req:    types.UpdateCartItemRequest{Quantity: 5},
+			err:    nil,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := repo.UpdateCartItem(userId, tt.bookId, tt.req)
+			require.Equal(t, tt.err, err)
+		})
+	}
+
+	res, err := repo.GetCartItems(userId)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, 5, res[0].Quantity)
+}
+
+func TestRepository_ClearCart(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	for _, title := range []string{"foo", "bar"} {
+		bookId, err := repo.CreateBook(types.CreateBookRequest{
+			Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+			GenreIds: []int{1},
+			Title:    title,
+		})
+		require.NoError(t, err)
+
+		err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: bookId, Quantity: 1})
+		require.NoError(t, err)
+	}
+
+	err = repo.DeleteCartItem(userId, 1)
+	require.NoError(t, err)
+
+	res, err := repo.GetCartItems(userId)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	err = repo.ClearCart(userId)
+	require.NoError(t, err)
+
+	res, err = repo.GetCartItems(userId)
+	require.NoError(t, err)
+	require.Empty(t, res)
+}
+
+func TestRepository_CreateOrder(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateOrder(userId, types.CreateOrderDB{})
+	require.Equal(t, errors.New("cart is empty"), err)
+
+	_, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	bookId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "foo",
+	})
+	require.NoError(t, err)
+
+	err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: bookId, Quantity: 2})
+	require.NoError(t, err)
+
+	cart, err := repo.GetCartItems(userId)
+	require.NoError(t, err)
+
+	id, err := repo.CreateOrder(userId, types.CreateOrderDB{Items: cart})
+	require.NoError(t, err)
+
+	res, err := repo.GetOrderById(id)
+	require.NoError(t, err)
+	require.Equal(t, userId, res.UserId)
+	require.Equal(t, "pending", res.Status)
+	require.Len(t, res.Items, 1)
+	require.Equal(t, "foo", res.Items[0].Title)
+	require.Equal(t, 2, res.Items[0].Quantity)
+
+	cart, err = repo.GetCartItems(userId)
+	require.NoError(t, err)
+	require.Empty(t, cart)
+}
+
+func TestRepository_UpdateOrderStatus(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	bookId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "foo",
+	})
+	require.NoError(t, err)
+
+	err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: bookId, Quantity: 1})
+	require.NoError(t, err)
+
+	cart, err := repo.GetCartItems(userId)
+	require.NoError(t, err)
+
+	id, err := repo.CreateOrder(userId, types.CreateOrderDB{Items: cart})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		from string
+		to   string
+		err  error
+	}{
+		{
+			name: "case 01: success",
+			from: "pending",
+			to:   "paid",
+			err:  nil,
+		},
+		{
+			name: "case 02: stale status",
+			from: "pending",
+			to:   "cancelled",
+			err:  errors.New("order status has changed"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := repo.UpdateOrderStatus(id, tt.from, tt.to)
+			require.Equal(t, tt.err, err)
+		})
+	}
+
+	res, err := repo.GetOrderById(id)
+	require.NoError(t, err)
+	require.Equal(t, "paid", res.Status)
+}
+
+func TestRepository_GetBookPriceAt(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	_, err := repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	bookId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "foo",
+		Price:    1000,
+		Currency: "USD",
+	})
+	require.NoError(t, err)
+
+	created := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	salePrice := int64(700)
+	err = repo.UpdateBook(bookId, types.UpdateBookRequest{
+		Authors:   []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds:  []int{1},
+		Title:     "foo",
+		Price:     1200,
+		Currency:  "USD",
+		SalePrice: &salePrice,
+	})
+	require.NoError(t, err)
+
+	prices, err := repo.GetBookPrices(bookId)
+	require.NoError(t, err)
+	require.Len(t, prices, 2)
+
+	tests := map[string]struct {
+		at    time.Time
+		price int64
+		err   error
+	}{
+		"case 01: before the book existed": {
+			at:  created.Add(-time.Hour),
+			err: sql.ErrNoRows,
+		},
+		"case 02: first price": {
+			at:    created,
+			price: 1000,
+			err:   nil,
+		},
+		"case 03: on sale": {
+			at:    time.Now(),
+			price: 700,
+			err:   nil,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			res, err := repo.GetBookPriceAt(bookId, tt.at)
+			require.Equal(t, tt.err, err)
+			if tt.err == nil {
+				require.Equal(t, tt.price, res.EffectivePrice)
+			}
+		})
+	}
+
+	book, err := repo.GetBookByID(bookId)
+	require.NoError(t, err)
+	require.Equal(t, int64(1200), book.Price)
+	require.Equal(t, int64(700), book.EffectivePrice)
+}
+
+func TestRepository_CreateInventoryAdjustment(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	bookId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "foo",
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		req  types.CreateInventoryAdjustmentRequest
+		err  error
+	}{
+		{
+			name: "case 01: receipt",
+			req:  types.CreateInventoryAdjustmentRequest{Quantity: 10, Reason: "receipt"},
+			err:  nil,
+		},
+		{
+			name: "case 02: damage",
+			req:  types.CreateInventoryAdjustmentRequest{Quantity: -3, Reason: "damage", Note: "water"},
+			err:  nil,
+		},
+		{
+			name: "case 03: more than on hand",
+			req:  types.CreateInventoryAdjustmentRequest{Quantity: -8, Reason: "damage"},
+			err:  errors.New("not enough stock"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := repo.CreateInventoryAdjustment(bookId, userId, tt.req)
+			require.Equal(t, tt.err, err)
+		})
+	}
+
+	res, err := repo.GetInventory(bookId)
+	require.NoError(t, err)
+	require.True(t, res.Tracked)
+	require.Equal(t, 7, res.OnHand)
+
+	adjustments, _, err := repo.GetInventoryAdjustments(bookId, types.PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, adjustments, 2)
+	require.Equal(t, "damage", adjustments[0].Reason)
+	require.Equal(t, userId, adjustments[0].UserId)
+}
+
+func TestRepository_ReserveStock(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	bookId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "foo",
+	})
+	require.NoError(t, err)
+
+	_, err = repo.CreateInventoryAdjustment(bookId, userId, types.CreateInventoryAdjustmentRequest{Quantity: 3, Reason: "receipt"})
+	require.NoError(t, err)
+
+	err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: bookId, Quantity: 5})
+	require.NoError(t, err)
+
+	cart, err := repo.GetCartItems(userId)
+	require.NoError(t, err)
+
+	_, err = repo.CreateOrder(userId, types.CreateOrderDB{Items: cart})
+	require.Equal(t, errors.New("not enough stock: foo"), err)
+
+	err = repo.UpdateCartItem(userId, bookId, types.UpdateCartItemRequest{Quantity: 2})
+	require.NoError(t, err)
+
+	cart, err = repo.GetCartItems(userId)
+	require.NoError(t, err)
+
+	orderId, err := repo.CreateOrder(userId, types.CreateOrderDB{Items: cart})
+	require.NoError(t, err)
+
+	res, err := repo.GetInventory(bookId)
+	require.NoError(t, err)
+	require.Equal(t, 3, res.OnHand)
+	require.Equal(t, 2, res.Reserved)
+
+	lowStock, err := repo.GetLowStock()
+	require.NoError(t, err)
+	require.Len(t, lowStock, 1)
+
+	err = repo.UpdateOrderStatus(orderId, "pending", "paid")
+	require.NoError(t, err)
+
+	res, err = repo.GetInventory(bookId)
+	require.NoError(t, err)
+	require.Equal(t, 1, res.OnHand)
+	require.Equal(t, 0, res.Reserved)
+
+	adjustments, _, err := repo.GetInventoryAdjustments(bookId, types.PageRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "sale", adjustments[0].Reason)
+	require.Equal(t, -2, adjustments[0].Quantity)
+	require.Equal(t, orderId, adjustments[0].OrderId)
+
+	// a book stocked after checkout reserved nothing, so settling the order
+	// leaves its stock alone
+	otherId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "bar",
+	})
+	require.NoError(t, err)
+
+	for _, status := range []string{"paid", "cancelled"} {
+		err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: otherId, Quantity: 1})
+		require.NoError(t, err)
+
+		cart, err = repo.GetCartItems(userId)
+		require.NoError(t, err)
+
+		orderId, err = repo.CreateOrder(userId, types.CreateOrderDB{Items: cart})
+		require.NoError(t, err)
+
+		_, err = repo.CreateInventoryAdjustment(otherId, userId, types.CreateInventoryAdjustmentRequest{Quantity: 1, Reason: "receipt"})
+		require.NoError(t, err)
+
+		err = repo.UpdateOrderStatus(orderId, "pending", status)
+		require.NoError(t, err)
+	}
+
+	res, err = repo.GetInventory(otherId)
+	require.NoError(t, err)
+	require.Equal(t, 2, res.OnHand)
+	require.Equal(t, 0, res.Reserved)
+}
+
+func TestRepository_ProcessPaymentEvent(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	bookId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "foo",
+		Price:    1000,
+		Currency: "USD",
+	})
+	require.NoError(t, err)
+
+	err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: bookId, Quantity: 1})
+	require.NoError(t, err)
+
+	cart, err := repo.GetCartItems(userId)
+	require.NoError(t, err)
+
+	orderId, err := repo.CreateOrder(userId, types.CreateOrderDB{Items: cart})
+	require.NoError(t, err)
+
+	err = repo.SavePayment(types.PaymentDB{
+		OrderId:           orderId,
+		Provider:          "fake",
+		ProviderPaymentId: "fake_1",
+		Status:            "authorized",
+		Amount:            1000,
+		Currency:          "USD",
+	})
+	require.NoError(t, err)
+
+	event := types.PaymentEventDB{
+		Provider:          "fake",
+		EventId:           "evt_1",
+		Type:              "payment.captured",
+		ProviderPaymentId: "fake_1",
+	}
+
+	tests := []struct {
+		name    string
+		orderId int
+	}{
+		{
+			name:    "case 01: first delivery",
+			orderId: orderId,
+		},
+		{
+			name:    "case 02: redelivery",
+			orderId: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := repo.ProcessPaymentEvent(event, "captured")
+			require.NoError(t, err)
+			require.Equal(t, tt.orderId, id)
+		})
+	}
+
+	order, err := repo.GetOrderById(orderId)
+	require.NoError(t, err)
+	require.Equal(t, "paid", order.Status)
+	require.Equal(t, int64(1000), order.Total)
+
+	p, err := repo.GetPaymentByOrderId(orderId)
+	require.NoError(t, err)
+	require.Equal(t, "captured", p.Status)
+
+	// cancel, then the capture webhook
+	err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: bookId, Quantity: 1})
+	require.NoError(t, err)
+
+	cart, err = repo.GetCartItems(userId)
+	require.NoError(t, err)
+
+	cancelledId, err := repo.CreateOrder(userId, types.CreateOrderDB{Items: cart})
+	require.NoError(t, err)
+
+	err = repo.SavePayment(types.PaymentDB{
+		OrderId:           cancelledId,
+		Provider:          "fake",
+		ProviderPaymentId: "fake_2",
+		Status:            "authorized",
+		Amount:            1000,
+		Currency:          "USD",
+	})
+	require.NoError(t, err)
+
+	err = repo.UpdateOrderStatus(cancelledId, "pending", "cancelled")
+	require.NoError(t, err)
+
+	id, err := repo.ProcessPaymentEvent(types.PaymentEventDB{
+		Provider:          "fake",
+		EventId:           "evt_2",
+		Type:              "payment.captured",
+		ProviderPaymentId: "fake_2",
+	}, "captured")
+	require.Equal(t, ErrOrderNotPending, err)
+	require.Equal(t, cancelledId, id)
+
+	order, err = repo.GetOrderById(cancelledId)
+	require.NoError(t, err)
+	require.Equal(t, "cancelled", order.Status)
+
+	p, err = repo.GetPaymentByOrderId(cancelledId)
+	require.NoError(t, err)
+	require.Equal(t, "captured", p.Status)
+}
+
+func TestRepository_CreateOrderPromotion(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateAuthor(types.CreateAuthorRequest{Name: "John"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateGenre(types.CreateGenreRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	bookId, err := repo.CreateBook(types.CreateBookRequest{
+		Authors:  []types.BookAuthorRequest{{AuthorId: 1}},
+		GenreIds: []int{1},
+		Title:    "foo",
+		Price:    1000,
+	})
+	require.NoError(t, err)
+
+	promotionId, err := repo.CreatePromotion(types.CreatePromotionRequest{
+		Name:       "Ten off",
+		Code:       "TEN",
+		Kind:       "percent",
+		Value:      10,
+		UsageLimit: 1,
+	})
+	require.NoError(t, err)
+
+	res, err := repo.GetActivePromotions(userId, "TEN", time.Now())
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+
+	err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: bookId, Quantity: 2})
+	require.NoError(t, err)
+
+	cart, err := repo.GetCartItems(userId)
+	require.NoError(t, err)
+
+	discounts := []*types.DiscountDB{{BookId: bookId, PromotionId: promotionId, Description: "Ten off", Amount: 200}}
+	orderId, err := repo.CreateOrder(userId, types.CreateOrderDB{Items: cart, Discounts: discounts, CouponCode: "TEN"})
+	require.NoError(t, err)
+
+	order, err := repo.GetOrderById(orderId)
+	require.NoError(t, err)
+	require.Equal(t, int64(2000), order.Subtotal)
+	require.Equal(t, int64(200), order.Discount)
+	require.Equal(t, int64(1800), order.Total)
+	require.Equal(t, "TEN", order.CouponCode)
+	require.Len(t, order.Items[0].Discounts, 1)
+
+	err = repo.AddCartItem(userId, types.AddCartItemRequest{BookId: bookId, Quantity: 1})
+	require.NoError(t, err)
+
+	cart, err = repo.GetCartItems(userId)
+	require.NoError(t, err)
+
+	discounts = []*types.DiscountDB{{BookId: bookId, PromotionId: promotionId, Description: "Ten off", Amount: 100}}
+	_, err = repo.CreateOrder(userId, types.CreateOrderDB{Items: cart, Discounts: discounts, CouponCode: "TEN"})
+	require.Equal(t, errors.New("promotion is no longer available"), err)
+
+	err = repo.UpdateOrderStatus(orderId, "pending", "cancelled")
+	require.NoError(t, err)
+
+	_, err = repo.CreateOrder(userId, types.CreateOrderDB{Items: cart, Discounts: discounts, CouponCode: "TEN"})
+	require.NoError(t, err)
+}
+
+func TestRepository_Sessions(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	laptop, err := repo.GetSessionIdByUsername(types.GetSessionIdByUsernameRequest{
+		Username:  "foo",
+		Password:  "bar",
+		UserAgent: "laptop",
+	})
+	require.NoError(t, err)
+
+	phone, err := repo.GetSessionIdByUsername(types.GetSessionIdByUsernameRequest{
+		Username:  "foo",
+		Password:  "bar",
+		UserAgent: "phone",
+	})
+	require.NoError(t, err)
+
+	expired, err := repo.GetSessionIdByUsername(types.GetSessionIdByUsernameRequest{
+		Username:    "foo",
+		Password:    "bar",
+		IdleTimeout: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+
+	for _, sessionId := range []string{laptop.SessionId, phone.SessionId} {
+		id, err := repo.GetUserIdBySessionId(sessionId)
+		require.NoError(t, err)
+		require.Equal(t, userId, id)
+	}
+
+	_, err = repo.GetUserIdBySessionId(expired.SessionId)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	sessions, err := repo.GetSessions(userId, laptop.SessionId)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	var phoneId int
+	for _, s := range sessions {
+		require.Equal(t, s.UserAgent == "laptop", s.Current)
+		if s.UserAgent == "phone" {
+			phoneId = s.ID
+		}
+	}
+
+	err = repo.DeleteSession(userId+1, phoneId)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	err = repo.DeleteSession(userId, phoneId)
+	require.NoError(t, err)
+
+	_, err = repo.GetUserIdBySessionId(phone.SessionId)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	err = repo.DeleteOtherSessions(userId, laptop.SessionId)
+	require.NoError(t, err)
+
+	sessions, err = repo.GetSessions(userId, laptop.SessionId)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+
+	tokens, err := repo.GetSessionTokens(userId)
+	require.NoError(t, err)
+	require.Equal(t, []string{laptop.SessionId}, tokens)
+}
+
+func TestRepository_Roles(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	_, err := repo.CreateRole(types.CreateRoleRequest{Name: "support", Permissions: []string{"orders:fly"}})
+	require.Equal(t, errors.New("unknown permission"), err)
+
+	roleId, err := repo.CreateRole(types.CreateRoleRequest{
+		Name:        "support",
+		Permissions: []string{"orders:read", "users:read"},
+	})
+	require.NoError(t, err)
+
+	permissions, err := repo.GetRolePermissions(roleId)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"orders:read", "users:read"}, permissions)
+
+	err = repo.UpdateRole(roleId, types.UpdateRoleRequest{Name: "support", Permissions: []string{"orders:refund"}})
+	require.NoError(t, err)
+
+	role, err := repo.GetRoleById(roleId)
+	require.NoError(t, err)
+	require.Equal(t, &types.RoleDB{ID: roleId, Name: "support", Permissions: []string{"orders:refund"}}, role)
+
+	err = repo.UpdateRole(roleId+1, types.UpdateRoleRequest{Name: "foo"})
+	require.Equal(t, sql.ErrNoRows, err)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	err = repo.UpdateUserById(userId, types.UpdateUserByIdRequest{Username: "foo", Password: "bar", RoleId: roleId})
+	require.NoError(t, err)
+
+	err = repo.DeleteRole(roleId)
+	require.Equal(t, errors.New("cannot delete role"), err)
+
+	err = repo.DeleteUser(userId)
+	require.NoError(t, err)
+
+	err = repo.DeleteRole(roleId)
+	require.NoError(t, err)
+
+	roles, err := repo.GetAllRoles()
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+}
+
+func TestRepository_ResetPassword(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar", Email: "Foo@example.com"})
+	require.NoError(t, err)
+
+	users, err := repo.GetUsersByEmail("foo@example.com")
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	session, err := repo.GetSessionIdByUsername(types.GetSessionIdByUsernameRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+
+	err = repo.CreatePasswordReset(userId, "expired", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+
+	err = repo.CreatePasswordReset(userId, "first", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	err = repo.CreatePasswordReset(userId, "second", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		tokenHash string
+		tokens    []string
+		err       error
+	}{
+		{
+			name:      "case 01: expired token",
+			tokenHash: "expired",
+			err:       errors.New("invalid or expired token"),
+		},
+		{
+			name:      "case 02: success",
+			tokenHash: "first",
+			tokens:    []string{session.SessionId},
+		},
+		{
+			name:      "case 03: used token",
+			tokenHash: "first",
+			err:       errors.New("invalid or expired token"),
+		},
+		{
+			name:      "case 04: token issued before the reset",
+			tokenHash: "second",
+			err:       errors.New("invalid or expired token"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := repo.ResetPassword(tt.tokenHash, "baz")
+			require.Equal(t, tt.err, err)
+			require.Equal(t, tt.tokens, tokens)
+		})
+	}
+
+	_, err = repo.GetUserIdBySessionId(session.SessionId)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	_, err = repo.GetSessionIdByUsername(types.GetSessionIdByUsernameRequest{Username: "foo", Password: "baz"})
+	require.NoError(t, err)
+}
+
+func TestRepository_VerifyEmail(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar", Email: "foo@example.com"})
+	require.NoError(t, err)
+
+	verified, err := repo.IsEmailVerified(userId)
+	require.NoError(t, err)
+	require.False(t, verified)
+
+	email, err := repo.CreateEmailVerification(userId, "old", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, "foo@example.com", email)
+
+	err = repo.UpdateUserById(userId, types.UpdateUserByIdRequest{Username: "foo", Password: "bar", Email: "bar@example.com", RoleId: 1})
+	require.NoError(t, err)
+
+	_, err = repo.CreateEmailVerification(userId, "new", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	count, last, err := repo.CountEmailVerifications(userId, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.WithinDuration(t, time.Now(), last, time.Minute)
+
+	tests := []struct {
+		name      string
+		tokenHash string
+		err       error
+	}{
+		{
+			name:      "case 01: token for the old email",
+			tokenHash: "old",
+			err:       errors.New("invalid or expired token"),
+		},
+		{
+			name:      "case 02: success",
+			tokenHash: "new",
+			err:       nil,
+		},
+		{
+			name:      "case 03: used token",
+			tokenHash: "new",
+			err:       errors.New("invalid or expired token"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := repo.VerifyEmail(tt.tokenHash)
+			require.Equal(t, tt.err, err)
+		})
+	}
+
+	user, err := repo.GetUserByID(userId)
+	require.NoError(t, err)
+	require.True(t, user.EmailVerified)
+
+	email, err = repo.CreateEmailVerification(userId, "again", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, "", email)
+}
+
+func TestRepository_TwoFactor(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar", Email: "foo@example.com"})
+	require.NoError(t, err)
+
+	err = repo.SetTwoFactorSecret(userId, "SECRET")
+	require.NoError(t, err)
+
+	err = repo.EnableTwoFactor(userId, 100, []string{"code1", "code2"})
+	require.NoError(t, err)
+
+	tf, err := repo.GetTwoFactor(userId)
+	require.NoError(t, err)
+	require.Equal(t, &types.TwoFactorDB{Secret: "SECRET", Enabled: true, LastStep: 100, Email: "foo@example.com", Username: "foo"}, tf)
+
+	err = repo.SetTwoFactorSecret(userId, "OTHER")
+	require.Equal(t, errors.New("two-factor authentication is already enabled"), err)
+
+	err = repo.UseTwoFactorStep(userId, 100)
+	require.Equal(t, errors.New("code was already used"), err)
+
+	err = repo.UseTwoFactorStep(userId, 101)
+	require.NoError(t, err)
+
+	ok, err := repo.UseRecoveryCode(userId, "code1")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = repo.UseRecoveryCode(userId, "code1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	res, err := repo.GetSessionIdByUsername(types.GetSessionIdByUsernameRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+	require.Equal(t, "", res.SessionId)
+	require.NotEqual(t, "", res.ChallengeToken)
+
+	tests := []struct {
+		name string
+		fail int
+		err  error
+	}{
+		{
+			name: "case 01: success",
+			fail: 0,
+			err:  nil,
+		},
+		{
+			name: "case 02: too many attempts",
+			fail: loginChallengeAttempts,
+			err:  errors.New("invalid or expired challenge"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range tt.fail {
+				require.NoError(t, repo.FailLoginChallenge(res.ChallengeToken))
+			}
+
+			id, err := repo.GetLoginChallenge(res.ChallengeToken)
+			require.Equal(t, tt.err, err)
+			if tt.err == nil {
+				require.Equal(t, userId, id)
+			}
+		})
+	}
+
+	err = repo.DeleteLoginChallenge(res.ChallengeToken)
+	require.NoError(t, err)
+
+	err = repo.DeleteLoginChallenge(res.ChallengeToken)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	err = repo.DisableTwoFactor(userId)
+	require.NoError(t, err)
+
+	res, err = repo.GetSessionIdByUsername(types.GetSessionIdByUsernameRequest{Username: "foo", Password: "bar"})
+	require.NoError(t, err)
+	require.NotEqual(t, "", res.SessionId)
+}
+
+func TestRepository_AuditLog(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar", Email: "foo@example.com"})
+	require.NoError(t, err)
+
+	for _, action := range []string{"login.locked", "login.ip_locked", "login.unlocked"} {
+		_, err = repo.CreateAuditEntry(types.AuditEntryDB{Action: action, UserId: userId, Username: "foo", IP: "127.0.0.1"})
+		require.NoError(t, err)
+	}
+
+	count, err := repo.CountAuditLog()
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	tests := []struct {
+		name    string
+		limit   int
+		actions []string
+	}{
+		{
+			name:    "case 01: first page",
+			limit:   2,
+			actions: []string{"login.unlocked", "login.ip_locked"},
+		},
+		{
+			name:    "case 02: all",
+			limit:   10,
+			actions: []string{"login.unlocked", "login.ip_locked", "login.locked"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, _, err := repo.GetAuditLog(types.PageRequest{Limit: tt.limit})
+			require.NoError(t, err)
+
+			actions := make([]string, len(entries))
+			for i, e := range entries {
+				actions[i] = e.Action
+				require.Equal(t, userId, e.UserId)
+				require.Equal(t, 0, e.ActorId)
+			}
+			require.Equal(t, tt.actions, actions)
+		})
+	}
+
+	err = repo.DeleteUser(userId)
+	require.NoError(t, err)
+
+	entries, _, err := repo.GetAuditLog(types.PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, 0, entries[0].UserId)
+}
+
+func TestRepository_APIKeys(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar", Email: "foo@example.com"})
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-time.Hour)
+	_, err = repo.CreateAPIKey(userId, APIKeyPrefix+"expired-key", types.CreateAPIKeyRequest{Name: "old", Scopes: []string{"books:write"}, ExpiresAt: &expired})
+	require.NoError(t, err)
+
+	id, err := repo.CreateAPIKey(userId, APIKeyPrefix+"import-key", types.CreateAPIKeyRequest{Name: "import", Scopes: []string{"books:write"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		key  string
+		err  error
+	}{
+		{
+			name: "case 01: success",
+			key:  APIKeyPrefix + "import-key",
+			err:  nil,
+		},
+		{
+			name: "case 02: expired key",
+			key:  APIKeyPrefix + "expired-key",
+			err:  sql.ErrNoRows,
+		},
+		{
+			name: "case 03: unknown key",
+			key:  APIKeyPrefix + "other-key",
+			err:  sql.ErrNoRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := repo.GetUserIdBySessionId(tt.key)
+			require.Equal(t, tt.err, err)
+			if tt.err == nil {
+				require.Equal(t, userId, id)
+			}
+		})
+	}
+
+	scopes, err := repo.GetAPIKeyScopes(APIKeyPrefix + "import-key")
+	require.NoError(t, err)
+	require.Equal(t, []string{"books:write"}, scopes)
+
+	keys, err := repo.GetAPIKeys(userId)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, APIKeyPrefix+"import-k", keys[1].Prefix)
+	require.NotNil(t, keys[1].LastUsedAt)
+	require.Nil(t, keys[0].LastUsedAt)
+
+	err = repo.DeleteAPIKey(userId+1, id)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	err = repo.DeleteAPIKey(userId, id)
+	require.NoError(t, err)
+
+	_, err = repo.GetUserIdBySessionId(APIKeyPrefix + "import-key")
+	require.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestRepository_RefreshTokens(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar", Email: "foo@example.com"})
+	require.NoError(t, err)
+
+	expiresAt := time.Now().Add(time.Hour)
+	require.NoError(t, repo.CreateRefreshToken(userId, "token-1", expiresAt))
+	require.NoError(t, repo.CreateRefreshToken(userId, "expired", time.Now().Add(-time.Hour)))
+
+	tests := []struct {
+		name    string
+		token   string
+		next    string
+		wantErr bool
+	}{
+		{
+			name:    "case 01: success",
+			token:   "token-1",
+			next:    "token-2",
+			wantErr: false,
+		},
+		{
+			name:    "case 02: next token of the family",
+			token:   "token-2",
+			next:    "token-3",
+			wantErr: false,
+		},
+		{
+			name:    "case 03: expired token",
+			token:   "expired",
+			next:    "other",
+			wantErr: true,
+		},
+		{
+			name:    "case 04: unknown token",
+			token:   "unknown",
+			next:    "other",
+			wantErr: true,
+		},
+		{
+			name:    "case 05: reused token revokes the family",
+			token:   "token-1",
+			next:    "other",
+			wantErr: true,
+		},
+		{
+			name:    "case 06: latest token of a revoked family",
+			token:   "token-3",
+			next:    "other",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := repo.RotateRefreshToken(tt.token, tt.next, expiresAt)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, userId, id)
+		})
+	}
+
+	require.NoError(t, repo.CreateRefreshToken(userId, "token-4", expiresAt))
+
+	err = repo.RevokeRefreshToken("token-4")
+	require.NoError(t, err)
+
+	err = repo.RevokeRefreshToken("token-4")
+	require.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestRepository_SigningKeys(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	now := time.Now()
+	require.NoError(t, repo.CreateSigningKey(types.SigningKeyDB{Kid: "old", PrivateKey: "old-key", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}))
+	require.NoError(t, repo.CreateSigningKey(types.SigningKeyDB{Kid: "k1", PrivateKey: "key-1", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, repo.CreateSigningKey(types.SigningKeyDB{Kid: "k2", PrivateKey: "key-2", CreatedAt: now, ExpiresAt: now.Add(2 * time.Hour)}))
+
+	keys, err := repo.GetSigningKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, "k2", keys[0].Kid)
+	require.Equal(t, "k1", keys[1].Kid)
+}
+
+func TestRepository_GetOrCreateExternalUser(t *testing.T) {
+	container := newTestContainer(t)
+	defer container.terminate(t)
+	db := container.getDB(t)
+	defer db.Close()
+	repo := NewRepository(db)
+
+	userId, err := repo.CreateUser(types.CreateUserRequest{Username: "foo", Password: "bar", Email: "foo@example.com"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateEmailVerification(userId, "token", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	_, err = repo.VerifyEmail("token")
+	require.NoError(t, err)
+
+	twoFactorId, err := repo.CreateUser(types.CreateUserRequest{Username: "bar", Password: "bar", Email: "bar@example.com"})
+	require.NoError(t, err)
+
+	_, err = repo.CreateEmailVerification(twoFactorId, "token2", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	_, err = repo.VerifyEmail("token2")
+	require.NoError(t, err)
+
+	err = repo.SetTwoFactorSecret(twoFactorId, "SECRET")
+	require.NoError(t, err)
+
+	err = repo.EnableTwoFactor(twoFactorId, 100, []string{"code1"})
+	require.NoError(t, err)
+
+	// a role with admin permissions under another name
+	roleId, err := repo.CreateRole(types.CreateRoleRequest{Name: "superuser", Permissions: []string{"roles:write"}})
+	require.NoError(t, err)
+
+	superuserId, err := repo.CreateUser(types.CreateUserRequest{Username: "baz", Password: "bar", Email: "baz@example.com"})
+	require.NoError(t, err)
+
+	err = repo.UpdateUserById(superuserId, types.UpdateUserByIdRequest{Username: "baz", Password: "bar", Email: "baz@example.com", RoleId: roleId})
+	require.NoError(t, err)
+
+	_, err = repo.CreateEmailVerification(superuserId, "token3", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	_, err = repo.VerifyEmail("token3")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		identity types.ExternalIdentityDB
+		role     string
+		username string
+		err      error
+	}{
+		{
+			name:     "case 01: linked by verified email",
+			identity: types.ExternalIdentityDB{Issuer: "https://idp", Subject: "1", Email: "FOO@example.com", EmailVerified: true},
+			role:     "user",
+			username: "foo",
+			err:      nil,
+		},
+		{
+			name:     "case 02: linked identity",
+			identity: types.ExternalIdentityDB{Issuer: "https://idp", Subject: "1", Email: "foo@example.com"},
+			role:     "user",
+			username: "foo",
+			err:      nil,
+		},
+		{
+			name:     "case 03: unverified email makes a new user",
+			identity: types.ExternalIdentityDB{Issuer: "https://idp", Subject: "2", Email: "foo@example.com", Username: "foo"},
+			role:     "user",
+			username: "foo-2",
+			err:      nil,
+		},
+		{
+			name:     "case 04: user with two-factor authentication is not linked",
+			identity: types.ExternalIdentityDB{Issuer: "https://idp", Subject: "4", Email: "bar@example.com", EmailVerified: true, Username: "bar"},
+			role:     "user",
+			username: "bar-2",
+			err:      nil,
+		},
+		{
+			name:     "case 05: user with admin permissions is not linked",
+			identity: types.ExternalIdentityDB{Issuer: "https://idp", Subject: "5", Email: "baz@example.com", EmailVerified: true, Username: "baz"},
+			role:     "user",
+			username: "baz-2",
+			err:      nil,
+		},
+		{
+			name:     "case 06: unknown role",
+			identity: types.ExternalIdentityDB{Issuer: "https://idp", Subject: "3", Email: "baz@example.com"},
+			role:     "staff",
+			err:      fmt.Errorf("unknown role %q", "staff"),
+		},
+		{
+			name:     "case 07: no subject",
+			identity: types.ExternalIdentityDB{Issuer: "https://idp"},
+			role:     "user",
+			err:      errors.New("bad request"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := repo.GetOrCreateExternalUser(tt.identity, tt.role)
+			require.Equal(t, tt.err, err)
+			if tt.err != nil {
+				return
+			}
+
+			user, err := repo.GetUserByID(id)
+			require.NoError(t, err)
+			require.Equal(t, tt.username, user.Username)
+		})
+	}
+
+	users, _, err := repo.GetAllUsers(types.PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, users, 6)
+	require.Equal(t, "user", users[3].Role)
+	require.False(t, users[3].EmailVerified)
+}
@@ -10,66 +10,182 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/sabirov8872/bookstore/internal/handler"
-	"github.com/sabirov8872/bookstore/internal/repository"
+	"github.com/sabirov8872/bookstore/internal/service"
 	"github.com/sabirov8872/bookstore/internal/types"
 )
 
-func Run(hand handler.IHandler, port int, repo repository.IRepository) {
+func Run(hand handler.IHandler, port int, serv service.IService) {
 	r := mux.NewRouter()
 	r.HandleFunc("/signup", hand.CreateUser).Methods("POST")
 	r.HandleFunc("/login", hand.GetSessionIdByUsername).Methods("POST")
+	r.HandleFunc("/login/oidc", hand.StartOIDCLogin).Methods("GET")
+	r.HandleFunc("/login/oidc/callback", hand.CompleteOIDCLogin).Methods("GET")
+	r.HandleFunc("/login/2fa", hand.LoginTwoFactor).Methods("POST")
 	r.HandleFunc("/logout", hand.DeleteSessionId).Methods("POST")
-
-	r.HandleFunc("/users", AdminAuth(repo, hand.GetAllUsers)).Methods("GET")
-	r.HandleFunc("/users", UserAuth(repo, hand.UpdateUserBySessionId)).Methods("PUT")
-	r.HandleFunc("/users/{id}", AdminAuth(repo, hand.GetUserById)).Methods("GET")
-	r.HandleFunc("/users/{id}", AdminAuth(repo, hand.UpdateUserById)).Methods("PUT")
-	r.HandleFunc("/users/{id}", AdminAuth(repo, hand.DeleteUser)).Methods("DELETE")
+	r.HandleFunc("/token", hand.CreateToken).Methods("POST")
+	r.HandleFunc("/token/2fa", hand.CreateTokenTwoFactor).Methods("POST")
+	r.HandleFunc("/token/refresh", hand.RefreshToken).Methods("POST")
+	r.HandleFunc("/token/revoke", hand.RevokeToken).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", hand.GetJWKS).Methods("GET")
+	r.HandleFunc("/email/verify", hand.VerifyEmail).Methods("POST")
+	r.HandleFunc("/email/verify/resend", UserAuth(serv, hand.ResendEmailVerification)).Methods("POST")
+	r.HandleFunc("/password/reset", hand.RequestPasswordReset).Methods("POST")
+	r.HandleFunc("/password/reset/confirm", hand.ResetPassword).Methods("POST")
+	r.HandleFunc("/sessions", UserAuth(serv, hand.GetSessions)).Methods("GET")
+	r.HandleFunc("/sessions", UserAuth(serv, hand.DeleteOtherSessions)).Methods("DELETE")
+	r.HandleFunc("/sessions/{id}", UserAuth(serv, hand.DeleteSession)).Methods("DELETE")
+	r.HandleFunc("/api-keys", UserAuth(serv, hand.GetAPIKeys)).Methods("GET")
+	r.HandleFunc("/api-keys", UserAuth(serv, hand.CreateAPIKey)).Methods("POST")
+	r.HandleFunc("/api-keys/{id}", UserAuth(serv, hand.DeleteAPIKey)).Methods("DELETE")
+	r.HandleFunc("/2fa/setup", UserAuth(serv, hand.SetupTwoFactor)).Methods("POST")
+	r.HandleFunc("/2fa/confirm", UserAuth(serv, hand.ConfirmTwoFactor)).Methods("POST")
+	r.HandleFunc("/2fa", UserAuth(serv, hand.DisableTwoFactor)).Methods("DELETE")
+
+	r.HandleFunc("/users", Auth(serv, service.PermissionUsersRead, hand.GetAllUsers)).Methods("GET")
+	r.HandleFunc("/users", UserAuth(serv, hand.UpdateUserBySessionId)).Methods("PUT")
+	r.HandleFunc("/users/{id}", Auth(serv, service.PermissionUsersRead, hand.GetUserById)).Methods("GET")
+	r.HandleFunc("/users/{id}", Auth(serv, service.PermissionUsersWrite, hand.UpdateUserById)).Methods("PUT")
+	r.HandleFunc("/users/{id}", Auth(serv, service.PermissionUsersWrite, hand.DeleteUser)).Methods("DELETE")
+	r.HandleFunc("/users/{id}/lock", Auth(serv, service.PermissionUsersWrite, hand.UnlockUser)).Methods("DELETE")
+
+	r.HandleFunc("/admin/audit", Auth(serv, service.PermissionAuditRead, hand.GetAuditLog)).Methods("GET")
+
+	r.HandleFunc("/admin/permissions", Auth(serv, service.PermissionRolesRead, hand.GetAllPermissions)).Methods("GET")
+	r.HandleFunc("/admin/roles", Auth(serv, service.PermissionRolesRead, hand.GetAllRoles)).Methods("GET")
+	r.HandleFunc("/admin/roles", Auth(serv, service.PermissionRolesWrite, hand.CreateRole)).Methods("POST")
+	r.HandleFunc("/admin/roles/{id}", Auth(serv, service.PermissionRolesRead, hand.GetRoleById)).Methods("GET")
+	r.HandleFunc("/admin/roles/{id}", Auth(serv, service.PermissionRolesWrite, hand.UpdateRole)).Methods("PUT")
+	r.HandleFunc("/admin/roles/{id}", Auth(serv, service.PermissionRolesWrite, hand.DeleteRole)).Methods("DELETE")
 
 	r.HandleFunc("/books", hand.GetAllBooks).Methods("GET")
-	r.HandleFunc("/books", AdminAuth(repo, hand.CreateBook)).Methods("POST")
+	r.HandleFunc("/books", Auth(serv, service.PermissionBooksWrite, hand.CreateBook)).Methods("POST")
 	r.HandleFunc("/books/{id}", hand.GetBookById).Methods("GET")
-	r.HandleFunc("/books/{id}", AdminAuth(repo, hand.UpdateBookById)).Methods("PUT")
-	r.HandleFunc("/books/{id}", AdminAuth(repo, hand.DeleteBookById)).Methods("DELETE")
+	r.HandleFunc("/books/{id}", Auth(serv, service.PermissionBooksWrite, hand.UpdateBookById)).Methods("PUT")
+	r.HandleFunc("/books/{id}", Auth(serv, service.PermissionBooksWrite, hand.DeleteBookById)).Methods("DELETE")
+	r.HandleFunc("/books/{id}/prices", Auth(serv, service.PermissionPricesRead, hand.GetBookPrices)).Methods("GET")
+	r.HandleFunc("/books/{id}/price", hand.GetBookPriceAt).Methods("GET")
 
 	r.HandleFunc("/authors", hand.GetAllAuthors).Methods("GET")
-	r.HandleFunc("/authors", AdminAuth(repo, hand.CreateAuthor)).Methods("POST")
+	r.HandleFunc("/authors", Auth(serv, service.PermissionAuthorsWrite, hand.CreateAuthor)).Methods("POST")
 	r.HandleFunc("/authors/{id}", hand.GetAuthorById).Methods("GET")
-	r.HandleFunc("/authors/{id}", AdminAuth(repo, hand.UpdateAuthorById)).Methods("PUT")
-	r.HandleFunc("/authors/{id}", AdminAuth(repo, hand.DeleteAuthor)).Methods("DELETE")
+	r.HandleFunc("/authors/{id}", Auth(serv, service.PermissionAuthorsWrite, hand.UpdateAuthorById)).Methods("PUT")
+	r.HandleFunc("/authors/{id}", Auth(serv, service.PermissionAuthorsWrite, hand.DeleteAuthor)).Methods("DELETE")
 
 	r.HandleFunc("/genres", hand.GetAllGenres).Methods("GET")
-	r.HandleFunc("/genres", AdminAuth(repo, hand.CreateGenre)).Methods("POST")
-	r.HandleFunc("/genres/{id}", AdminAuth(repo, hand.UpdateGenre)).Methods("PUT")
-	r.HandleFunc("/genres/{id}", AdminAuth(repo, hand.DeleteGenre)).Methods("DELETE")
-
-	r.HandleFunc("/files/{id}", hand.GetFileByBookId).Methods("GET")
-	r.HandleFunc("/files/{id}", AdminAuth(repo, hand.UploadFileByBookId)).Methods("POST")
+	r.HandleFunc("/genres", Auth(serv, service.PermissionGenresWrite, hand.CreateGenre)).Methods("POST")
+	r.HandleFunc("/genres/{id}", Auth(serv, service.PermissionGenresWrite, hand.UpdateGenre)).Methods("PUT")
+	r.HandleFunc("/genres/{id}", Auth(serv, service.PermissionGenresWrite, hand.DeleteGenre)).Methods("DELETE")
+
+	r.HandleFunc("/files/{id}", VerifiedAuth(serv, hand.GetFileByBookId)).Methods("GET", "HEAD")
+	r.HandleFunc("/files/{id}", Auth(serv, service.PermissionBooksWrite, hand.UploadFileByBookId)).Methods("POST")
+	r.HandleFunc("/files/{id}/download", VerifiedAuth(serv, hand.GetFileURL)).Methods("GET")
+	r.HandleFunc("/files/{id}/metadata", Auth(serv, service.PermissionBooksWrite, hand.GetFileMetadata)).Methods("GET")
+	r.HandleFunc("/files/{id}/metadata/fill", Auth(serv, service.PermissionBooksWrite, hand.FillBookFromFile)).Methods("POST")
+	r.HandleFunc("/files/{id}/direct-uploads", Auth(serv, service.PermissionBooksWrite, hand.CreateFileUpload)).Methods("POST")
+	r.HandleFunc("/files/{id}/direct-uploads/{uploadId}/finalize", Auth(serv, service.PermissionBooksWrite, hand.FinalizeFileUpload)).Methods("POST")
+	r.HandleFunc("/files/{id}/uploads", hand.GetUploadOptions).Methods("OPTIONS")
+	r.HandleFunc("/files/{id}/uploads", Auth(serv, service.PermissionBooksWrite, hand.CreateUpload)).Methods("POST")
+	r.HandleFunc("/files/{id}/uploads/{uploadId}", Auth(serv, service.PermissionBooksWrite, hand.GetUpload)).Methods("HEAD")
+	r.HandleFunc("/files/{id}/uploads/{uploadId}", Auth(serv, service.PermissionBooksWrite, hand.WriteUpload)).Methods("PATCH")
+	r.HandleFunc("/files/{id}/uploads/{uploadId}", Auth(serv, service.PermissionBooksWrite, hand.DeleteUpload)).Methods("DELETE")
+
+	r.HandleFunc("/cart", UserAuth(serv, hand.GetCart)).Methods("GET")
+	r.HandleFunc("/cart", UserAuth(serv, hand.ClearCart)).Methods("DELETE")
+	r.HandleFunc("/cart/coupon", UserAuth(serv, hand.ApplyCoupon)).Methods("PUT")
+	r.HandleFunc("/cart/coupon", UserAuth(serv, hand.RemoveCoupon)).Methods("DELETE")
+	r.HandleFunc("/cart/items", UserAuth(serv, hand.AddCartItem)).Methods("POST")
+	r.HandleFunc("/cart/items/{id}", UserAuth(serv, hand.UpdateCartItem)).Methods("PUT")
+	r.HandleFunc("/cart/items/{id}", UserAuth(serv, hand.DeleteCartItem)).Methods("DELETE")
+
+	r.HandleFunc("/checkout", VerifiedAuth(serv, hand.Checkout)).Methods("POST")
+	r.HandleFunc("/orders", UserAuth(serv, hand.GetOrdersBySessionId)).Methods("GET")
+	r.HandleFunc("/orders/{id}", UserAuth(serv, hand.GetOrderBySessionId)).Methods("GET")
+	r.HandleFunc("/orders/{id}/pay", VerifiedAuth(serv, hand.PayOrder)).Methods("POST")
+	r.HandleFunc("/payments/webhook", hand.PaymentWebhook).Methods("POST")
+	r.HandleFunc("/admin/orders", Auth(serv, service.PermissionOrdersRead, hand.GetAllOrders)).Methods("GET")
+	r.HandleFunc("/admin/orders/{id}/status", Auth(serv, service.PermissionOrdersWrite, hand.UpdateOrderStatus)).Methods("PUT")
+
+	r.HandleFunc("/admin/inventory/{id}", Auth(serv, service.PermissionInventoryRead, hand.GetInventory)).Methods("GET")
+	r.HandleFunc("/admin/inventory/{id}", Auth(serv, service.PermissionInventoryWrite, hand.UpdateInventory)).Methods("PUT")
+	r.HandleFunc("/admin/inventory/{id}/adjustments", Auth(serv, service.PermissionInventoryRead, hand.GetInventoryAdjustments)).Methods("GET")
+	r.HandleFunc("/admin/inventory/{id}/adjustments", Auth(serv, service.PermissionInventoryWrite, hand.CreateInventoryAdjustment)).Methods("POST")
+	r.HandleFunc("/admin/reports/low-stock", Auth(serv, service.PermissionInventoryRead, hand.GetLowStock)).Methods("GET")
+
+	r.HandleFunc("/admin/promotions", Auth(serv, service.PermissionPromotionsRead, hand.GetAllPromotions)).Methods("GET")
+	r.HandleFunc("/admin/promotions", Auth(serv, service.PermissionPromotionsWrite, hand.CreatePromotion)).Methods("POST")
+	r.HandleFunc("/admin/promotions/{id}", Auth(serv, service.PermissionPromotionsRead, hand.GetPromotionById)).Methods("GET")
+	r.HandleFunc("/admin/promotions/{id}", Auth(serv, service.PermissionPromotionsWrite, hand.UpdatePromotion)).Methods("PUT")
+	r.HandleFunc("/admin/promotions/{id}", Auth(serv, service.PermissionPromotionsWrite, hand.DeletePromotion)).Methods("DELETE")
 
 	cors := handlers.CORS(
 		handlers.AllowedOrigins([]string{"*"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Cookie"}),
+		handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Cookie", "Authorization", "Range", "If-Range", "If-None-Match", "If-Modified-Since",
+			"Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset"}),
+		handlers.ExposedHeaders([]string{"ETag", "Content-Range", "Accept-Ranges", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension",
+			"Upload-Length", "Upload-Offset"}),
 		handlers.AllowCredentials(),
 	)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf("localhost:%d", port), cors(r)))
 }
 
-func UserAuth(repo repository.IRepository, handler http.HandlerFunc) http.HandlerFunc {
+// UserAuth lets through any request with a valid session or access token,
+// from the cookie or the Authorization header. API keys are refused: they
+// only reach routes that declare a permission, so a key cannot change the
+// account or make more keys.
+func UserAuth(serv service.IService, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkRole(r, repo, []int{1, 2})
+		sessionId, err := handler.GetSessionId(r)
 		if err != nil {
 			writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: err.Error()})
 			return
 		}
 
-		handler(w, r)
+		if service.IsAPIKey(sessionId) {
+			writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: "api keys are not accepted here"})
+			return
+		}
+
+		_, err = serv.GetUserRoleBySessionId(sessionId)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: err.Error()})
+			return
+		}
+
+		next(w, r)
 	}
 }
 
-func AdminAuth(repo repository.IRepository, handler http.HandlerFunc) http.HandlerFunc {
+// VerifiedAuth lets through requests whose user has confirmed the email.
+func VerifiedAuth(serv service.IService, next http.HandlerFunc) http.HandlerFunc {
+	return UserAuth(serv, func(w http.ResponseWriter, r *http.Request) {
+		sessionId, _ := handler.GetSessionId(r)
+
+		ok, err := serv.IsEmailVerified(sessionId)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: err.Error()})
+			return
+		}
+
+		if !ok {
+			writeJSON(w, http.StatusForbidden, types.ErrorResponse{Message: "email is not verified"})
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// Auth lets through requests whose session has a role with the permission.
+// It also takes API keys in the Authorization header that have the
+// permission as a scope.
+func Auth(serv service.IService, permission string, handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := checkRole(r, repo, []int{2})
+		err := checkPermission(r, serv, permission)
+		if errors.Is(err, service.ErrTwoFactorRequired) {
+			writeJSON(w, http.StatusForbidden, types.ErrorResponse{Message: err.Error()})
+			return
+		}
 		if err != nil {
 			writeJSON(w, http.StatusUnauthorized, types.ErrorResponse{Message: err.Error()})
 			return
@@ -79,24 +195,22 @@ func AdminAuth(repo repository.IRepository, handler http.HandlerFunc) http.Handl
 	}
 }
 
-func checkRole(r *http.Request, repo repository.IRepository, roleIds []int) error {
-	cookie, err := r.Cookie("sessionId")
+func checkPermission(r *http.Request, serv service.IService, permission string) error {
+	sessionId, err := handler.GetSessionId(r)
 	if err != nil {
 		return err
 	}
 
-	resRoleId, err := repo.GetUserRoleBySessionId(cookie.Value)
+	ok, err := serv.HasPermission(sessionId, permission)
 	if err != nil {
 		return err
 	}
 
-	for _, roleId := range roleIds {
-		if roleId == resRoleId {
-			return nil
-		}
+	if !ok {
+		return errors.New("permission denied")
 	}
 
-	return errors.New("unknown role")
+	return nil
 }
 
 func writeJSON(w http.ResponseWriter, statusCode int, data any) {
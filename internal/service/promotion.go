@@ -0,0 +1,212 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/sabirov8872/bookstore/internal/types"
+)
+
+var errCouponInvalid = errors.New("coupon is not valid")
+
+// applyPromotions works out the discounts for a cart. Promotions are applied
+// in the given order, automatic ones before the coupon, and each takes its
+// share of what earlier ones left of a line, so no line goes below zero.
+func applyPromotions(items []*types.CartItemDB, promotions []*types.PromotionDB) []*types.DiscountDB {
+	remaining := make(map[int]int64, len(items))
+	for _, item := range items {
+		remaining[item.BookId] = item.UnitPrice * int64(item.Quantity)
+	}
+
+	var discounts []*types.DiscountDB
+	for _, p := range promotions {
+		var lines []*types.CartItemDB
+		for _, item := range items {
+			if promotionMatches(p, item) && remaining[item.BookId] > 0 {
+				lines = append(lines, item)
+			}
+		}
+
+		if len(lines) == 0 {
+			continue
+		}
+
+		var amounts map[int]int64
+		switch p.Kind {
+		case "percent":
+			amounts = percentOff(p, lines, remaining)
+		case "fixed":
+			amounts = fixedOff(p, lines, remaining)
+		case "buy_x_get_y":
+			amounts = buyXGetY(p, lines)
+		}
+
+		for _, item := range lines {
+			amount := min(amounts[item.BookId], remaining[item.BookId])
+			if amount <= 0 {
+				continue
+			}
+
+			remaining[item.BookId] -= amount
+			discounts = append(discounts, &types.DiscountDB{
+				BookId:      item.BookId,
+				PromotionId: p.ID,
+				Description: promotionDescription(p),
+				Amount:      amount,
+			})
+		}
+	}
+
+	return discounts
+}
+
+func promotionMatches(p *types.PromotionDB, item *types.CartItemDB) bool {
+	if p.BookId != 0 && p.BookId != item.BookId {
+		return false
+	}
+
+	if p.GenreId != 0 && !slices.Contains(item.GenreIds, p.GenreId) {
+		return false
+	}
+
+	return true
+}
+
+func percentOff(p *types.PromotionDB, lines []*types.CartItemDB, remaining map[int]int64) map[int]int64 {
+	amounts := make(map[int]int64, len(lines))
+	for _, item := range lines {
+		amounts[item.BookId] = remaining[item.BookId] * p.Value / 100
+	}
+
+	return amounts
+}
+
+// fixedOff spreads the amount over the lines in proportion to what is left
+// of them; the last line takes the rounding difference.
+func fixedOff(p *types.PromotionDB, lines []*types.CartItemDB, remaining map[int]int64) map[int]int64 {
+	var total int64
+	for _, item := range lines {
+		total += remaining[item.BookId]
+	}
+
+	amount := min(p.Value, total)
+	amounts := make(map[int]int64, len(lines))
+	var given int64
+	for i, item := range lines {
+		if i == len(lines)-1 {
+			amounts[item.BookId] = amount - given
+			break
+		}
+
+		share := amount * remaining[item.BookId] / total
+		amounts[item.BookId] = share
+		given += share
+	}
+
+	return amounts
+}
+
+// buyXGetY lines up the matching copies from the most to the least
+// expensive and makes the last GetQuantity copies of every group free.
+func buyXGetY(p *types.PromotionDB, lines []*types.CartItemDB) map[int]int64 {
+	amounts := make(map[int]int64)
+	if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+		return amounts
+	}
+
+	var copies []*types.CartItemDB
+	for _, item := range lines {
+		for range item.Quantity {
+			copies = append(copies, item)
+		}
+	}
+
+	sort.SliceStable(copies, func(i, j int) bool {
+		return copies[i].UnitPrice > copies[j].UnitPrice
+	})
+
+	group := p.BuyQuantity + p.GetQuantity
+	for i := range len(copies) / group * group {
+		if i%group >= p.BuyQuantity {
+			amounts[copies[i].BookId] += copies[i].UnitPrice
+		}
+	}
+
+	return amounts
+}
+
+func promotionDescription(p *types.PromotionDB) string {
+	switch p.Kind {
+	case "percent":
+		return fmt.Sprintf("%s (%d%% off)", p.Name, p.Value)
+	case "fixed":
+		return fmt.Sprintf("%s (%d off)", p.Name, p.Value)
+	case "buy_x_get_y":
+		return fmt.Sprintf("%s (buy %d get %d free)", p.Name, p.BuyQuantity, p.GetQuantity)
+	}
+
+	return p.Name
+}
+
+// usablePromotions drops promotions that reached a usage limit and reports
+// errCouponInvalid when the coupon is not among the rest.
+func usablePromotions(promotions []*types.PromotionDB, code string) ([]*types.PromotionDB, error) {
+	var usable []*types.PromotionDB
+	var couponFound bool
+	for _, p := range promotions {
+		if p.UsageLimit > 0 && p.Used >= p.UsageLimit {
+			continue
+		}
+
+		if p.PerUserLimit > 0 && p.UsedByUser >= p.PerUserLimit {
+			continue
+		}
+
+		if p.Code != "" {
+			couponFound = true
+		}
+
+		usable = append(usable, p)
+	}
+
+	if code != "" && !couponFound {
+		return nil, errCouponInvalid
+	}
+
+	return usable, nil
+}
+
+func checkPromotion(req types.CreatePromotionRequest) error {
+	if req.Name == "" {
+		return errors.New("bad name")
+	}
+
+	switch req.Kind {
+	case "percent":
+		if req.Value <= 0 || req.Value > 100 {
+			return errors.New("bad value")
+		}
+	case "fixed":
+		if req.Value <= 0 {
+			return errors.New("bad value")
+		}
+	case "buy_x_get_y":
+		if req.BuyQuantity <= 0 || req.GetQuantity <= 0 {
+			return errors.New("bad quantity")
+		}
+	default:
+		return errors.New("bad kind")
+	}
+
+	if req.StartsAt != nil && req.EndsAt != nil && !req.StartsAt.Before(*req.EndsAt) {
+		return errors.New("bad promotion window")
+	}
+
+	if req.UsageLimit < 0 || req.PerUserLimit < 0 {
+		return errors.New("bad usage limit")
+	}
+
+	return nil
+}
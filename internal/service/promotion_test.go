@@ -0,0 +1,201 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/sabirov8872/bookstore/internal/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyPromotions(t *testing.T) {
+	items := []*types.CartItemDB{
+		{BookId: 1, Quantity: 2, UnitPrice: 1000, GenreIds: []int{1}},
+		{BookId: 2, Quantity: 1, UnitPrice: 500, GenreIds: []int{2}},
+	}
+
+	tests := map[string]struct {
+		promotions []*types.PromotionDB
+		want       []*types.DiscountDB
+	}{
+		"case 01: no promotions": {
+			promotions: nil,
+			want:       nil,
+		},
+		"case 02: promotion for a genre": {
+			promotions: []*types.PromotionDB{
+				{ID: 1, Name: "Sale", Kind: "percent", Value: 10, GenreId: 1},
+			},
+			want: []*types.DiscountDB{
+				{BookId: 1, PromotionId: 1, Description: "Sale (10% off)", Amount: 200},
+			},
+		},
+		"case 03: coupon takes its share of what is left": {
+			promotions: []*types.PromotionDB{
+				{ID: 1, Name: "Sale", Kind: "percent", Value: 10, GenreId: 1},
+				{ID: 2, Name: "Coupon", Code: "SAVE", Kind: "fixed", Value: 300},
+			},
+			want: []*types.DiscountDB{
+				{BookId: 1, PromotionId: 1, Description: "Sale (10% off)", Amount: 200},
+				{BookId: 1, PromotionId: 2, Description: "Coupon (300 off)", Amount: 234},
+				{BookId: 2, PromotionId: 2, Description: "Coupon (300 off)", Amount: 66},
+			},
+		},
+		"case 04: line that is already free is skipped": {
+			promotions: []*types.PromotionDB{
+				{ID: 1, Name: "Free", Kind: "percent", Value: 100, BookId: 1},
+				{ID: 2, Name: "Coupon", Kind: "fixed", Value: 800},
+			},
+			want: []*types.DiscountDB{
+				{BookId: 1, PromotionId: 1, Description: "Free (100% off)", Amount: 2000},
+				{BookId: 2, PromotionId: 2, Description: "Coupon (800 off)", Amount: 500},
+			},
+		},
+		"case 05: discount is capped at what is left of the line": {
+			promotions: []*types.PromotionDB{
+				{ID: 1, Name: "Sale", Kind: "percent", Value: 90, BookId: 1},
+				{ID: 2, Name: "Pair", Kind: "buy_x_get_y", BuyQuantity: 1, GetQuantity: 1, BookId: 1},
+			},
+			want: []*types.DiscountDB{
+				{BookId: 1, PromotionId: 1, Description: "Sale (90% off)", Amount: 1800},
+				{BookId: 1, PromotionId: 2, Description: "Pair (buy 1 get 1 free)", Amount: 200},
+			},
+		},
+		"case 06: promotion for another book": {
+			promotions: []*types.PromotionDB{
+				{ID: 1, Name: "Sale", Kind: "percent", Value: 10, BookId: 3},
+			},
+			want: nil,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.want, applyPromotions(items, tt.promotions))
+		})
+	}
+}
+
+func TestPercentOff(t *testing.T) {
+	tests := map[string]struct {
+		value     int64
+		lines     []*types.CartItemDB
+		remaining map[int]int64
+		want      map[int]int64
+	}{
+		"case 01: whole amounts": {
+			value:     10,
+			lines:     []*types.CartItemDB{{BookId: 1}},
+			remaining: map[int]int64{1: 2000},
+			want:      map[int]int64{1: 200},
+		},
+		"case 02: rounds down": {
+			value:     15,
+			lines:     []*types.CartItemDB{{BookId: 1}, {BookId: 2}},
+			remaining: map[int]int64{1: 999, 2: 5997},
+			want:      map[int]int64{1: 149, 2: 899},
+		},
+		"case 03: takes the percentage of what is left": {
+			value:     50,
+			lines:     []*types.CartItemDB{{BookId: 1}},
+			remaining: map[int]int64{1: 301},
+			want:      map[int]int64{1: 150},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := &types.PromotionDB{Kind: "percent", Value: tt.value}
+			require.Equal(t, tt.want, percentOff(p, tt.lines, tt.remaining))
+		})
+	}
+}
+
+func TestFixedOff(t *testing.T) {
+	lines := []*types.CartItemDB{{BookId: 1}, {BookId: 2}, {BookId: 3}}
+
+	tests := map[string]struct {
+		value     int64
+		remaining map[int]int64
+		want      map[int]int64
+	}{
+		"case 01: split in proportion": {
+			value:     500,
+			remaining: map[int]int64{1: 1000, 2: 3000, 3: 1000},
+			want:      map[int]int64{1: 100, 2: 300, 3: 100},
+		},
+		"case 02: last line takes the rounding difference": {
+			value:     100,
+			remaining: map[int]int64{1: 1000, 2: 1000, 3: 1000},
+			want:      map[int]int64{1: 33, 2: 33, 3: 34},
+		},
+		"case 03: capped at the remaining total": {
+			value:     9000,
+			remaining: map[int]int64{1: 1000, 2: 3000, 3: 500},
+			want:      map[int]int64{1: 1000, 2: 3000, 3: 500},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := &types.PromotionDB{Kind: "fixed", Value: tt.value}
+			require.Equal(t, tt.want, fixedOff(p, lines, tt.remaining))
+		})
+	}
+}
+
+func TestBuyXGetY(t *testing.T) {
+	tests := map[string]struct {
+		buy, get int
+		lines    []*types.CartItemDB
+		want     map[int]int64
+	}{
+		"case 01: one book": {
+			buy: 2, get: 1,
+			lines: []*types.CartItemDB{{BookId: 1, Quantity: 3, UnitPrice: 700}},
+			want:  map[int]int64{1: 700},
+		},
+		"case 02: cheapest copy of the group is free": {
+			buy: 2, get: 1,
+			lines: []*types.CartItemDB{
+				{BookId: 1, Quantity: 1, UnitPrice: 300},
+				{BookId: 2, Quantity: 1, UnitPrice: 1000},
+				{BookId: 3, Quantity: 1, UnitPrice: 500},
+			},
+			want: map[int]int64{1: 300},
+		},
+		"case 03: mixed prices over several groups": {
+			buy: 1, get: 1,
+			lines: []*types.CartItemDB{
+				{BookId: 1, Quantity: 1, UnitPrice: 1000},
+				{BookId: 2, Quantity: 2, UnitPrice: 500},
+				{BookId: 3, Quantity: 1, UnitPrice: 300},
+			},
+			want: map[int]int64{2: 500, 3: 300},
+		},
+		"case 04: incomplete group is not free": {
+			buy: 2, get: 1,
+			lines: []*types.CartItemDB{
+				{BookId: 1, Quantity: 2, UnitPrice: 1000},
+				{BookId: 2, Quantity: 2, UnitPrice: 500},
+			},
+			want: map[int]int64{2: 500},
+		},
+		"case 05: not enough copies": {
+			buy: 2, get: 1,
+			lines: []*types.CartItemDB{{BookId: 1, Quantity: 2, UnitPrice: 1000}},
+			want:  map[int]int64{},
+		},
+		"case 06: bad quantities": {
+			buy: 0, get: 1,
+			lines: []*types.CartItemDB{{BookId: 1, Quantity: 4, UnitPrice: 1000}},
+			want:  map[int]int64{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := &types.PromotionDB{Kind: "buy_x_get_y", BuyQuantity: tt.buy, GetQuantity: tt.get}
+			require.Equal(t, tt.want, buyXGetY(p, tt.lines))
+		})
+	}
+}
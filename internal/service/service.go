@@ -2,14 +2,44 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sabirov8872/bookstore/internal/repository"
 	"github.com/sabirov8872/bookstore/internal/types"
+	"github.com/sabirov8872/bookstore/pkg/bookmeta"
+	"github.com/sabirov8872/bookstore/pkg/jwt"
+	"github.com/sabirov8872/bookstore/pkg/mailer"
 	"github.com/sabirov8872/bookstore/pkg/minio"
+	"github.com/sabirov8872/bookstore/pkg/oidc"
+	"github.com/sabirov8872/bookstore/pkg/payment"
 	"github.com/sabirov8872/bookstore/pkg/redis"
+	"github.com/sabirov8872/bookstore/pkg/totp"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -19,20 +49,308 @@ const (
 	allAuthors = "allAuthors"
 	authorID   = "authorID"
 	allGenres  = "allGenres"
+	cartUserID = "cartUserID"
+	sessionID  = "sessionID"
+	roleID     = "roleID"
+	userRole   = "userRole"
+	// sessionTwoFactor caches whether the user of an admin session has
+	// two-factor authentication, by the hash of the session.
+	sessionTwoFactor = "sessionTwoFactor"
+
+	// loginRetryUser and loginRetryIP hold the time, in unix milliseconds,
+	// before which the next login is refused after a failure.
+	loginRetryUser = "loginRetryUser"
+	loginRetryIP   = "loginRetryIP"
+
+	loginFailuresUser = "loginFailuresUser"
+	loginFailuresIP   = "loginFailuresIP"
+	loginLockUser     = "loginLockUser"
+	loginLockIP       = "loginLockIP"
+	oidcState         = "oidcState"
+	fileUpload        = "fileUpload"
+	fileUploadLock    = "fileUploadLock"
+
+	// sessionTTL is short because a cached session is not renewed in the
+	// database, and an expired one stays usable until its entry expires.
+	sessionTTL = time.Minute
+
+	passwordResetTTL = time.Hour
+
+	emailVerificationTTL = 24 * time.Hour
+	// a user may ask for another verification email once a minute and five
+	// times an hour
+	emailVerificationInterval = time.Minute
+	emailVerificationsPerHour = 5
+
+	// oidcStateTTL is how long a user has to sign in at the identity
+	// provider.
+	oidcStateTTL    = 10 * time.Minute
+	defaultOIDCRole = "user"
+
+	// access tokens are meant for this API only
+	tokenAudience = "bookstore-api"
+	// signing keys are cached this long, so keys made by other instances
+	// are picked up soon
+	keyringTTL = time.Minute
+
+	defaultTwoFactorIssuer = "Bookstore"
+	recoveryCodeCount      = 10
+
+	defaultCurrency = "USD"
+
+	// fileObjectPrefix starts the object keys of uploads, which are named
+	// by the SHA-256 of their content.
+	fileObjectPrefix = "sha256/"
+	// uploadObjectPrefix holds files that clients put in storage
+	// themselves until they are finalized.
+	uploadObjectPrefix = "uploads/"
+	downloadURLTTL     = 5 * time.Minute
+	uploadURLTTL       = time.Hour
+	// resumable uploads that get no chunk for uploadIdleTTL are thrown away
+	// by the cleanup, which runs every uploadCleanupInterval
+	uploadIdleTTL         = 24 * time.Hour
+	uploadCleanupInterval = time.Hour
+	// uploadLockTTL bounds how long one chunk may take
+	uploadLockTTL = time.Hour
 )
 
+// Actions written to the audit log.
+const (
+	auditLoginLocked   = "login.locked"
+	auditLoginIPLocked = "login.ip_locked"
+	auditLoginUnlocked = "login.unlocked"
+)
+
+var (
+	ErrTooManyRequests   = errors.New("too many requests, try again later")
+	ErrTwoFactorRequired = errors.New("two-factor authentication is required for this role")
+	ErrAccountLocked     = errors.New("account is temporarily locked after too many failed logins")
+	ErrUploadNotFound    = errors.New("upload not found")
+	ErrUploadOffset      = errors.New("upload offset does not match")
+	ErrUploadLocked      = errors.New("another chunk of the upload is being written")
+	ErrUploadChunk       = errors.New("chunks must fit in the upload and be at most 5 GiB, and all but the last must be at least 5 MiB")
+)
+
+// RetryError refuses a request until After has passed. It matches
+// ErrTooManyRequests.
+type RetryError struct {
+	After time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return ErrTooManyRequests.Error()
+}
+
+func (e *RetryError) Is(target error) bool {
+	return target == ErrTooManyRequests
+}
+
+// Permissions name what a role allows. Routes declare the one they need, so
+// new roles only take rows in the database.
+const (
+	PermissionUsersRead       = "users:read"
+	PermissionUsersWrite      = "users:write"
+	PermissionRolesRead       = "roles:read"
+	PermissionRolesWrite      = "roles:write"
+	PermissionBooksWrite      = "books:write"
+	PermissionAuthorsWrite    = "authors:write"
+	PermissionGenresWrite     = "genres:write"
+	PermissionPricesRead      = "prices:read"
+	PermissionOrdersRead      = "orders:read"
+	PermissionOrdersWrite     = "orders:write"
+	PermissionOrdersRefund    = "orders:refund"
+	PermissionInventoryRead   = "inventory:read"
+	PermissionInventoryWrite  = "inventory:write"
+	PermissionPromotionsRead  = "promotions:read"
+	PermissionPromotionsWrite = "promotions:write"
+	PermissionAuditRead       = "audit:read"
+)
+
+// builtinRoles cannot be deleted: new users are given one of them.
+var builtinRoles = []string{"user", "admin"}
+
+// adminPermissions make a role an admin role whatever it is called, as they
+// let it hand out any permission or take over accounts.
+var adminPermissions = []string{PermissionUsersWrite, PermissionRolesWrite}
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Delivered, cancelled and refunded orders are final unless listed here.
+var orderTransitions = map[string][]string{
+	"pending":   {"paid", "cancelled"},
+	"paid":      {"shipped", "refunded"},
+	"shipped":   {"delivered"},
+	"delivered": {"refunded"},
+}
+
+// fileContentTypes are the types of book files that the system MIME table
+// may not know.
+var fileContentTypes = map[string]string{
+	".epub": "application/epub+zip",
+	".fb2":  "application/x-fictionbook+xml",
+	".mobi": "application/x-mobipocket-ebook",
+	".azw3": "application/vnd.amazon.ebook",
+	".djvu": "image/vnd.djvu",
+	".pdf":  "application/pdf",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".m4b":  "audio/mp4",
+	".zip":  "application/zip",
+}
+
+// paymentStatuses maps webhook events to the status of the payment.
+var paymentStatuses = map[string]string{
+	payment.EventCaptured: payment.StatusCaptured,
+	payment.EventFailed:   payment.StatusFailed,
+	payment.EventRefunded: payment.StatusRefunded,
+}
+
+// ErrBadCursor is returned by the paged lists for a cursor they did not hand
+// out.
+var ErrBadCursor = repository.ErrBadCursor
+
 type Service struct {
-	repo  repository.IRepository
-	redis redis.IClient
-	minio minio.IClient
+	repo      repository.IRepository
+	redis     redis.IClient
+	minio     minio.IClient
+	payment   payment.IProvider
+	mailer    mailer.IMailer
+	session   SessionConfig
+	twoFactor TwoFactorConfig
+	login     LoginConfig
+	oidc      oidc.IProvider
+	oidcRole  string
+	tokens    TokenConfig
+	keys      *keyring
+}
+
+// SessionConfig holds the session timeouts; zero values fall back to the
+// repository defaults.
+type SessionConfig struct {
+	IdleTimeout     time.Duration `yaml:"idleTimeout"`
+	AbsoluteTimeout time.Duration `yaml:"absoluteTimeout"`
+}
+
+// TwoFactorConfig sets the issuer shown in authenticator apps and whether
+// admin roles, those with adminPermissions, have to use two-factor
+// authentication.
+type TwoFactorConfig struct {
+	Issuer           string `yaml:"issuer"`
+	RequiredForAdmin bool   `yaml:"requiredForAdmin"`
+}
+
+// TokenConfig sets up the access tokens for clients that cannot keep
+// cookies. Access tokens are signed with keys kept in the database, and a
+// new key is made every KeyRotation. Zero values fall back to the defaults.
+type TokenConfig struct {
+	Issuer      string        `yaml:"issuer"`
+	AccessTTL   time.Duration `yaml:"accessTTL"`
+	RefreshTTL  time.Duration `yaml:"refreshTTL"`
+	KeyRotation time.Duration `yaml:"keyRotation"`
+}
+
+func (c TokenConfig) withDefaults() TokenConfig {
+	if c.Issuer == "" {
+		c.Issuer = "bookstore"
+	}
+	if c.AccessTTL <= 0 {
+		c.AccessTTL = 15 * time.Minute
+	}
+	if c.RefreshTTL <= 0 {
+		c.RefreshTTL = 30 * 24 * time.Hour
+	}
+	if c.KeyRotation <= 0 {
+		c.KeyRotation = 7 * 24 * time.Hour
+	}
+
+	return c
+}
+
+// LoginConfig limits failed logins. Failures are counted per username and
+// per client IP within Window; at the limit further logins are refused for
+// LockoutDuration. After every failure the next login is refused for a delay
+// that starts at Delay and doubles up to MaxDelay. Zero values fall back to
+// the defaults.
+type LoginConfig struct {
+	MaxAttempts     int           `yaml:"maxAttempts"`
+	MaxIPAttempts   int           `yaml:"maxIPAttempts"`
+	Window          time.Duration `yaml:"window"`
+	LockoutDuration time.Duration `yaml:"lockoutDuration"`
+	Delay           time.Duration `yaml:"delay"`
+	MaxDelay        time.Duration `yaml:"maxDelay"`
+}
+
+func (c LoginConfig) withDefaults() LoginConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+	if c.MaxIPAttempts <= 0 {
+		c.MaxIPAttempts = 50
+	}
+	if c.Window <= 0 {
+		c.Window = 15 * time.Minute
+	}
+	if c.LockoutDuration <= 0 {
+		c.LockoutDuration = 15 * time.Minute
+	}
+	if c.Delay <= 0 {
+		c.Delay = 250 * time.Millisecond
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = 4 * time.Second
+	}
+
+	return c
+}
+
+// delay returns how long logins are refused after the nth failure.
+func (c LoginConfig) delay(n int64) time.Duration {
+	d := c.Delay
+	for i := int64(1); i < n && d < c.MaxDelay; i++ {
+		d *= 2
+	}
+
+	return min(d, c.MaxDelay)
 }
 
 type IService interface {
 	CreateUser(req types.CreateUserRequest) (*types.CreateUserResponse, error)
 	GetSessionIdByUsername(req types.GetSessionIdByUsernameRequest) (*types.GetSessionIdByUsernameResponse, error)
+	StartOIDCLogin() (*types.StartOIDCLoginResponse, error)
+	CompleteOIDCLogin(req types.CompleteOIDCLoginRequest) (*types.GetSessionIdByUsernameResponse, error)
+	LoginTwoFactor(req types.LoginTwoFactorRequest) (*types.GetSessionIdByUsernameResponse, error)
+	CreateToken(req types.GetSessionIdByUsernameRequest) (*types.TokenResponse, error)
+	CreateTokenTwoFactor(req types.LoginTwoFactorRequest) (*types.TokenResponse, error)
+	RefreshToken(req types.RefreshTokenRequest) (*types.TokenResponse, error)
+	RevokeToken(req types.RefreshTokenRequest) error
+	GetJWKS() (*jwt.KeySet, error)
 	DeleteSessionId(sessionId string) error
-
-	GetAllUsers() (*types.ListUserResponse, error)
+	GetSessions(sessionId string) (*types.ListSessionResponse, error)
+	DeleteSession(sessionId string, id int) error
+	DeleteOtherSessions(sessionId string) error
+	GetUserRoleBySessionId(sessionId string) (int, error)
+	HasPermission(sessionId, permission string) (bool, error)
+	RequestPasswordReset(req types.RequestPasswordResetRequest) error
+	ResetPassword(req types.ResetPasswordRequest) error
+	ResendEmailVerification(sessionId string) error
+	VerifyEmail(req types.VerifyEmailRequest) error
+	IsEmailVerified(sessionId string) (bool, error)
+	UnlockUser(sessionId string, id int) error
+	GetAPIKeys(sessionId string) (*types.ListAPIKeyResponse, error)
+	CreateAPIKey(sessionId string, req types.CreateAPIKeyRequest) (*types.CreateAPIKeyResponse, error)
+	DeleteAPIKey(sessionId string, id int) error
+	GetAuditLog(req types.PageRequest) (*types.ListAuditEntryResponse, error)
+	SetupTwoFactor(sessionId string) (*types.SetupTwoFactorResponse, error)
+	ConfirmTwoFactor(sessionId string, req types.TwoFactorCodeRequest) (*types.ConfirmTwoFactorResponse, error)
+	DisableTwoFactor(sessionId string, req types.TwoFactorCodeRequest) error
+
+	GetAllPermissions() (*types.ListPermissionResponse, error)
+	GetAllRoles() (*types.ListRoleResponse, error)
+	GetRoleById(id int) (*types.Role, error)
+	CreateRole(req types.CreateRoleRequest) (*types.CreateRoleResponse, error)
+	UpdateRole(id int, req types.UpdateRoleRequest) error
+	DeleteRole(id int) error
+
+	GetAllUsers(req types.PageRequest) (*types.ListUserResponse, error)
 	GetUserById(id int) (*types.User, error)
 	UpdateUserBySessionId(req types.UpdateUserRequest, sessionId string) error
 	UpdateUserById(id int, userRole types.UpdateUserByIdRequest) error
@@ -43,277 +361,3273 @@ type IService interface {
 	CreateBook(req types.CreateBookRequest) (*types.CreateBookResponse, error)
 	UpdateBook(id int, req types.UpdateBookRequest) error
 	DeleteBook(id int) error
+	GetBookPrices(bookId int) (*types.ListBookPriceResponse, error)
+	GetBookPriceAt(bookId int, at time.Time) (*types.BookPrice, error)
 
-	GetAllAuthors() (*types.ListAuthorResponse, error)
+	GetAllAuthors(req types.PageRequest) (*types.ListAuthorResponse, error)
 	GetAuthorById(id int) (*types.Author, error)
 	CreateAuthor(req types.CreateAuthorRequest) (*types.CreateAuthorResponse, error)
 	UpdateAuthor(id int, req types.UpdateAuthorRequest) error
 	DeleteAuthor(id int) error
 
-	GetAllGenres() (*types.ListGenreResponse, error)
+	GetAllGenres(req types.PageRequest) (*types.ListGenreResponse, error)
 	CreateGenre(req types.CreateGenreRequest) (*types.CreateGenreResponse, error)
 	UpdateGenre(id int, req types.UpdateGenreRequest) error
 	DeleteGenre(id int) error
 
-	UploadFileByBookId(req types.UploadFileByBookIdRequest) error
+	UploadFileByBookId(req types.UploadFileByBookIdRequest) (*types.BookMetadata, error)
 	GetFileByBookId(id int) (res *types.GetFileByBookIdResponse, err error)
+	GetFileURL(id int) (string, error)
+	CreateFileUpload(id int, req types.CreateFileUploadRequest) (*types.CreateFileUploadResponse, error)
+	FinalizeFileUpload(id int, uploadId string) error
+	GetFileMetadata(id int) (*types.BookMetadata, error)
+	FillBookFromFile(id int) (*types.Book, error)
+	CreateUpload(id int, req types.CreateUploadRequest) (string, error)
+	GetUpload(id int, uploadId string) (*types.UploadResponse, error)
+	WriteUpload(req types.WriteUploadRequest) (int64, error)
+	DeleteUpload(id int, uploadId string) error
+
+	GetCart(sessionId string) (*types.Cart, error)
+	AddCartItem(sessionId string, req types.AddCartItemRequest) error
+	UpdateCartItem(sessionId string, bookId int, req types.UpdateCartItemRequest) error
+	DeleteCartItem(sessionId string, bookId int) error
+	ClearCart(sessionId string) error
+	ApplyCoupon(sessionId string, req types.ApplyCouponRequest) error
+	RemoveCoupon(sessionId string) error
+
+	Checkout(sessionId string) (*types.CreateOrderResponse, error)
+	GetOrdersBySessionId(sessionId string, req types.PageRequest) (*types.ListOrderResponse, error)
+	GetOrderBySessionId(sessionId string, id int) (*types.Order, error)
+	GetAllOrders(req types.GetAllOrdersRequest) (*types.ListOrderResponse, error)
+	UpdateOrderStatus(id int, req types.UpdateOrderStatusRequest) error
+	PayOrder(sessionId string, id int) (*types.PayOrderResponse, error)
+	HandlePaymentWebhook(payload []byte, header http.Header) error
+
+	GetAllPromotions(req types.PageRequest) (*types.ListPromotionResponse, error)
+	GetPromotionById(id int) (*types.Promotion, error)
+	CreatePromotion(req types.CreatePromotionRequest) (*types.CreatePromotionResponse, error)
+	UpdatePromotion(id int, req types.UpdatePromotionRequest) error
+	DeletePromotion(id int) error
+
+	GetInventory(bookId int) (*types.Inventory, error)
+	UpdateInventory(bookId int, req types.UpdateInventoryRequest) error
+	CreateInventoryAdjustment(sessionId string, bookId int, req types.CreateInventoryAdjustmentRequest) (*types.CreateInventoryAdjustmentResponse, error)
+	GetInventoryAdjustments(bookId int, req types.PageRequest) (*types.ListInventoryAdjustmentResponse, error)
+	GetLowStock() (*types.ListInventoryResponse, error)
 }
 
-func NewService(repo repository.IRepository, redis redis.IClient, minio minio.IClient) *Service {
+func NewService(repo repository.IRepository, redis redis.IClient, minio minio.IClient, payment payment.IProvider, mailer mailer.IMailer, session SessionConfig, twoFactor TwoFactorConfig, login LoginConfig, oidcProvider oidc.IProvider, oidcConfig oidc.Config, tokens TokenConfig) *Service {
+	oidcRole := oidcConfig.DefaultRole
+	if oidcRole == "" {
+		oidcRole = defaultOIDCRole
+	}
+
 	return &Service{
-		repo:  repo,
-		redis: redis,
-		minio: minio,
+		repo:      repo,
+		redis:     redis,
+		minio:     minio,
+		payment:   payment,
+		mailer:    mailer,
+		session:   session,
+		twoFactor: twoFactor,
+		login:     login.withDefaults(),
+		oidc:      oidcProvider,
+		oidcRole:  oidcRole,
+		tokens:    tokens.withDefaults(),
+		keys:      &keyring{},
 	}
 }
 
 func (s *Service) CreateUser(req types.CreateUserRequest) (*types.CreateUserResponse, error) {
+	_, err := mail.ParseAddress(req.Email)
+	if err != nil {
+		return nil, errors.New("bad email")
+	}
+
 	id, err := s.repo.CreateUser(req)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.redis.Del(context.Background(), []string{allUsers})
+	err = s.redis.DelByPattern(context.Background(), allUsers+":*")
 	if err != nil {
 		return nil, err
 	}
 
+	// the account exists either way; the user can ask for another email
+	err = s.sendEmailVerification(id)
+	if err != nil {
+		log.Printf("verification email for user %d: %v", id, err)
+	}
+
 	return &types.CreateUserResponse{
 		ID: id,
 	}, nil
 }
 
 func (s *Service) GetSessionIdByUsername(req types.GetSessionIdByUsernameRequest) (*types.GetSessionIdByUsernameResponse, error) {
-	return s.repo.GetSessionIdByUsername(req)
+	username := strings.ToLower(req.Username)
+	err := s.checkLoginLock(username, req.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	req.IdleTimeout = s.session.IdleTimeout
+	req.AbsoluteTimeout = s.session.AbsoluteTimeout
+
+	res, err := s.repo.GetSessionIdByUsername(req)
+	err = s.countLogin(username, req.IP, err, res != nil && res.ChallengeToken == "")
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
 }
 
-func (s *Service) DeleteSessionId(sessionId string) error {
-	return s.repo.DeleteSessionId(sessionId)
+// countLogin records the outcome of a password check: failures count towards
+// a lockout and a success clears the failures of the username when done, that
+// is when no second factor is asked for. Otherwise the failures stay until
+// the code is right, so that wrong codes add up.
+func (s *Service) countLogin(username, ip string, err error, done bool) error {
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return s.loginFailed(username, ip, err)
+	}
+	if err != nil || !done {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{loginFailuresUser + username})
 }
 
-func (s *Service) GetAllUsers() (*types.ListUserResponse, error) {
-	if data, err := s.redis.Get(context.Background(), allUsers); err == nil {
-		res := &types.ListUserResponse{}
-		err = json.Unmarshal([]byte(data), res)
+func (s *Service) checkLoginLock(username, ip string) error {
+	if _, err := s.redis.Get(context.Background(), loginLockUser+username); err == nil {
+		return ErrAccountLocked
+	}
+
+	if ip != "" {
+		if lockedAt, err := s.redis.Get(context.Background(), loginLockIP+ip); err == nil {
+			at, _ := strconv.ParseInt(lockedAt, 10, 64)
+			return &RetryError{After: time.Until(time.Unix(at, 0).Add(s.login.LockoutDuration))}
+		}
+	}
+
+	var until time.Time
+	for _, key := range []string{loginRetryUser + username, loginRetryIP + ip} {
+		v, err := s.redis.Get(context.Background(), key)
 		if err != nil {
-			return nil, err
+			continue
 		}
 
-		return res, nil
+		ms, _ := strconv.ParseInt(v, 10, 64)
+		if t := time.UnixMilli(ms); t.After(until) {
+			until = t
+		}
+	}
+
+	if d := time.Until(until); d > 0 {
+		return &RetryError{After: d}
+	}
+
+	return nil
+}
+
+// loginFailed counts a failed login against the username and the client IP,
+// locks whichever reached its limit and refuses the next login of either for
+// a while. It returns loginErr unless counting fails.
+func (s *Service) loginFailed(username, ip string, loginErr error) error {
+	n, err := s.redis.Incr(context.Background(), loginFailuresUser+username, s.login.Window)
+	if err != nil {
+		return err
+	}
+
+	err = s.holdLogin(loginRetryUser+username, n)
+	if err != nil {
+		return err
+	}
+
+	if n >= int64(s.login.MaxAttempts) {
+		err = s.lockLogin(loginLockUser+username, loginFailuresUser+username, types.AuditEntryDB{
+			Action:   auditLoginLocked,
+			Username: username,
+			IP:       ip,
+			Details:  fmt.Sprintf("%d failed logins", n),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if ip != "" {
+		m, err := s.redis.Incr(context.Background(), loginFailuresIP+ip, s.login.Window)
+		if err != nil {
+			return err
+		}
+
+		err = s.holdLogin(loginRetryIP+ip, m)
+		if err != nil {
+			return err
+		}
+
+		if m >= int64(s.login.MaxIPAttempts) {
+			err = s.lockLogin(loginLockIP+ip, loginFailuresIP+ip, types.AuditEntryDB{
+				Action:  auditLoginIPLocked,
+				IP:      ip,
+				Details: fmt.Sprintf("%d failed logins", m),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return loginErr
+}
+
+// holdLogin refuses logins under key for the delay that follows the nth
+// failure.
+func (s *Service) holdLogin(key string, n int64) error {
+	d := s.login.delay(n)
+	return s.redis.Set(context.Background(), key, time.Now().Add(d).UnixMilli(), d)
+}
+
+// lockLogin sets the lock key and starts counting afresh for when it
+// expires.
+func (s *Service) lockLogin(lockKey, failuresKey string, entry types.AuditEntryDB) error {
+	err := s.redis.Set(context.Background(), lockKey, time.Now().Unix(), s.login.LockoutDuration)
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.Del(context.Background(), []string{failuresKey})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.repo.CreateAuditEntry(entry)
+	return err
+}
+
+// UnlockUser lifts the lockout of a user before it expires.
+func (s *Service) UnlockUser(sessionId string, id int) error {
+	actorId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.repo.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	username := strings.ToLower(user.Username)
+	err = s.redis.Del(context.Background(), []string{loginLockUser + username, loginFailuresUser + username})
+	if err != nil {
+		return err
 	}
 
-	res, err := s.repo.GetAllUsers()
+	_, err = s.repo.CreateAuditEntry(types.AuditEntryDB{
+		Action:   auditLoginUnlocked,
+		UserId:   id,
+		ActorId:  actorId,
+		Username: user.Username,
+	})
+	return err
+}
+
+// IsAPIKey tells API keys from session ids.
+func IsAPIKey(sessionId string) bool {
+	return strings.HasPrefix(sessionId, repository.APIKeyPrefix)
+}
+
+func (s *Service) GetAPIKeys(sessionId string) (*types.ListAPIKeyResponse, error) {
+	userId, err := s.getUserId(sessionId)
 	if err != nil {
 		return nil, err
 	}
 
-	resp := make([]*types.User, len(res))
+	res, err := s.repo.GetAPIKeys(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.APIKey, len(res))
 	for i, v := range res {
-		resp[i] = &types.User{
-			ID:       v.ID,
-			Username: v.Username,
-			Password: v.Password,
-			Email:    v.Email,
-			Phone:    v.Phone,
-			Role:     v.Role,
+		resp[i] = &types.APIKey{
+			ID:         v.ID,
+			Prefix:     v.Prefix,
+			Name:       v.Name,
+			Scopes:     v.Scopes,
+			CreatedAt:  v.CreatedAt,
+			ExpiresAt:  v.ExpiresAt,
+			LastUsedAt: v.LastUsedAt,
 		}
 	}
 
-	data := &types.ListUserResponse{
-		UsersCount: len(resp),
-		Items:      resp,
+	return &types.ListAPIKeyResponse{
+		Items: resp,
+	}, nil
+}
+
+// CreateAPIKey makes a key limited to the scopes, each of which the role of
+// the user must have. The key is returned only here.
+func (s *Service) CreateAPIKey(sessionId string, req types.CreateAPIKeyRequest) (*types.CreateAPIKeyResponse, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Scopes) == 0 {
+		return nil, errors.New("bad request")
 	}
 
-	jsonData, err := json.Marshal(data)
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiresAt is in the past")
+	}
+
+	userId, err := s.getUserId(sessionId)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.redis.Set(context.Background(), allUsers, jsonData, time.Minute*30)
+	roleId, err := s.GetUserRoleBySessionId(sessionId)
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	permissions, err := s.rolePermissions(roleId)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+	for _, scope := range req.Scopes {
+		if !slices.Contains(permissions, scope) {
+			return nil, fmt.Errorf("scope %q is not a permission of your role", scope)
+		}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	key := repository.APIKeyPrefix + token
+	id, err := s.repo.CreateAPIKey(userId, key, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.CreateAPIKeyResponse{
+		ID:  id,
+		Key: key,
+	}, nil
 }
 
-func (s *Service) GetUserById(id int) (*types.User, error) {
-	if data, err := s.redis.Get(context.Background(), userID+strconv.Itoa(id)); err == nil {
-		res := &types.User{}
-		err = json.Unmarshal([]byte(data), res)
-		if err != nil {
-			return nil, err
+func (s *Service) DeleteAPIKey(sessionId string, id int) error {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.DeleteAPIKey(userId, id)
+}
+
+func (s *Service) GetAuditLog(req types.PageRequest) (*types.ListAuditEntryResponse, error) {
+	res, nextCursor, err := s.repo.GetAuditLog(req)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := s.repo.CountAuditLog()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.AuditEntry, len(res))
+	for i, v := range res {
+		resp[i] = &types.AuditEntry{
+			ID:        v.ID,
+			Action:    v.Action,
+			UserId:    v.UserId,
+			ActorId:   v.ActorId,
+			Username:  v.Username,
+			IP:        v.IP,
+			Details:   v.Details,
+			CreatedAt: v.CreatedAt,
 		}
+	}
 
-		return res, nil
+	return &types.ListAuditEntryResponse{
+		EntriesCount: count,
+		Items:        resp,
+		NextCursor:   nextCursor,
+	}, nil
+}
+
+type oidcLogin struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// StartOIDCLogin begins a sign-in with the identity provider. The PKCE
+// verifier and the nonce wait in Redis for the callback.
+func (s *Service) StartOIDCLogin() (*types.StartOIDCLoginResponse, error) {
+	if s.oidc == nil {
+		return nil, errors.New("single sign-on is not configured")
 	}
-	res, err := s.repo.GetUserByID(id)
+
+	state, err := newToken()
 	if err != nil {
 		return nil, err
 	}
 
-	data := &types.User{
-		ID:       res.ID,
-		Username: res.Username,
-		Password: res.Password,
-		Email:    res.Email,
-		Phone:    res.Phone,
-		Role:     res.Role,
+	nonce, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := s.oidc.AuthCodeURL(context.Background(), state, nonce, oidc.Challenge(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(oidcLogin{Verifier: verifier, Nonce: nonce})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), oidcState+state, jsonData, oidcStateTTL)
+	if err != nil {
+		return nil, err
 	}
 
-	jsonData, err := json.Marshal(data)
+	return &types.StartOIDCLoginResponse{
+		URL:   url,
+		State: state,
+	}, nil
+}
+
+// CompleteOIDCLogin redeems the code the identity provider sent back and
+// starts a session for the user linked to the identity, creating one on the
+// first login. Users with two-factor authentication get the same challenge
+// as with a password, to be answered at /login/2fa.
+func (s *Service) CompleteOIDCLogin(req types.CompleteOIDCLoginRequest) (*types.GetSessionIdByUsernameResponse, error) {
+	if s.oidc == nil {
+		return nil, errors.New("single sign-on is not configured")
+	}
+
+	if req.Code == "" || req.State == "" {
+		return nil, errors.New("bad request")
+	}
+
+	data, err := s.redis.Get(context.Background(), oidcState+req.State)
+	if err != nil {
+		return nil, errors.New("invalid or expired state")
+	}
+
+	// a state is good for one try
+	err = s.redis.Del(context.Background(), []string{oidcState + req.State})
+	if err != nil {
+		return nil, err
+	}
+
+	var login oidcLogin
+	err = json.Unmarshal([]byte(data), &login)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := s.oidc.Exchange(context.Background(), req.Code, login.Verifier, login.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := s.repo.GetOrCreateExternalUser(types.ExternalIdentityDB{
+		Issuer:        identity.Issuer,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Username:      identity.Username,
+	}, s.oidcRole)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allUsers+":*")
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if tf.Enabled {
+		token, err := s.repo.CreateLoginChallenge(userId)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.GetSessionIdByUsernameResponse{
+			UserId:         userId,
+			ChallengeToken: token,
+		}, nil
+	}
+
+	return s.repo.CreateSession(userId, types.GetSessionIdByUsernameRequest{
+		UserAgent:       req.UserAgent,
+		IP:              req.IP,
+		IdleTimeout:     s.session.IdleTimeout,
+		AbsoluteTimeout: s.session.AbsoluteTimeout,
+	})
+}
+
+// LoginTwoFactor answers the challenge that GetSessionIdByUsername returns
+// for accounts with two-factor authentication, and starts the session.
+func (s *Service) LoginTwoFactor(req types.LoginTwoFactorRequest) (*types.GetSessionIdByUsernameResponse, error) {
+	userId, err := s.answerChallenge(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.CreateSession(userId, types.GetSessionIdByUsernameRequest{
+		UserAgent:       req.UserAgent,
+		IP:              req.IP,
+		IdleTimeout:     s.session.IdleTimeout,
+		AbsoluteTimeout: s.session.AbsoluteTimeout,
+	})
+}
+
+// answerChallenge checks the code for a login challenge and uses the
+// challenge up, returning its user. Wrong codes count towards the same
+// lockout as wrong passwords, so that new challenges do not give an
+// attacker with the password more guesses.
+func (s *Service) answerChallenge(req types.LoginTwoFactorRequest) (int, error) {
+	if req.ChallengeToken == "" || req.Code == "" {
+		return 0, errors.New("bad request")
+	}
+
+	userId, err := s.repo.GetLoginChallenge(req.ChallengeToken)
+	if err != nil {
+		return 0, err
+	}
+
+	user, err := s.repo.GetUserByID(userId)
+	if err != nil {
+		return 0, err
+	}
+
+	username := strings.ToLower(user.Username)
+	err = s.checkLoginLock(username, req.IP)
+	if err != nil {
+		return 0, err
+	}
+
+	ok, err := s.checkTwoFactorCode(userId, req.Code)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		err = s.repo.FailLoginChallenge(req.ChallengeToken)
+		if err != nil {
+			return 0, err
+		}
+
+		return 0, s.loginFailed(username, req.IP, errors.New("invalid code"))
+	}
+
+	err = s.repo.DeleteLoginChallenge(req.ChallengeToken)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.redis.Del(context.Background(), []string{loginFailuresUser + username})
+	if err != nil {
+		return 0, err
+	}
+
+	return userId, nil
+}
+
+// CreateToken logs in like GetSessionIdByUsername, with the same lockout,
+// but answers with an access and a refresh token instead of a session.
+func (s *Service) CreateToken(req types.GetSessionIdByUsernameRequest) (*types.TokenResponse, error) {
+	username := strings.ToLower(req.Username)
+	err := s.checkLoginLock(username, req.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, twoFactor, err := s.repo.CheckPassword(req.Username, req.Password)
+	err = s.countLogin(username, req.IP, err, !twoFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	if twoFactor {
+		token, err := s.repo.CreateLoginChallenge(userId)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.TokenResponse{
+			ChallengeToken: token,
+		}, nil
+	}
+
+	return s.issueTokens(userId)
+}
+
+// CreateTokenTwoFactor answers the challenge that CreateToken returns for
+// accounts with two-factor authentication.
+func (s *Service) CreateTokenTwoFactor(req types.LoginTwoFactorRequest) (*types.TokenResponse, error) {
+	userId, err := s.answerChallenge(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(userId)
+}
+
+// RefreshToken trades a refresh token for a new access and refresh token.
+// A refresh token works once; using it again revokes the whole login.
+func (s *Service) RefreshToken(req types.RefreshTokenRequest) (*types.TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, errors.New("bad request")
+	}
+
+	refresh, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := s.repo.RotateRefreshToken(hashToken(req.RefreshToken), hashToken(refresh), time.Now().Add(s.tokens.RefreshTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.tokenResponse(userId, refresh)
+}
+
+// RevokeToken ends the login of a refresh token. Access tokens already
+// handed out stay good until they expire.
+func (s *Service) RevokeToken(req types.RefreshTokenRequest) error {
+	if req.RefreshToken == "" {
+		return errors.New("bad request")
+	}
+
+	return s.repo.RevokeRefreshToken(hashToken(req.RefreshToken))
+}
+
+func (s *Service) issueTokens(userId int) (*types.TokenResponse, error) {
+	refresh, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.CreateRefreshToken(userId, hashToken(refresh), time.Now().Add(s.tokens.RefreshTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.tokenResponse(userId, refresh)
+}
+
+func (s *Service) tokenResponse(userId int, refresh string) (*types.TokenResponse, error) {
+	key, err := s.signingKey()
+	if err != nil {
+		return nil, err
+	}
+
+	jti, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	// the token names the user only; the role is looked up by the subject,
+	// so that a new role applies to tokens already handed out
+	access, err := jwt.Sign(jwt.Claims{
+		Issuer:    s.tokens.Issuer,
+		Subject:   strconv.Itoa(userId),
+		Audience:  jwt.Audience{tokenAudience},
+		ExpiresAt: now.Add(s.tokens.AccessTTL).Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        jti,
+	}, key.kid, key.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.TokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.tokens.AccessTTL.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// isAccessToken tells access tokens apart from session ids and API keys,
+// which have no dots.
+func isAccessToken(sessionId string) bool {
+	return strings.Count(sessionId, ".") == 2
+}
+
+func (s *Service) parseAccessToken(token string) (*jwt.Claims, error) {
+	var claims jwt.Claims
+	_, err := jwt.Parse(token, s.publicKey, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	err = claims.Validate(s.tokens.Issuer, tokenAudience, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// getUserId returns the user of a session id, API key or access token.
+func (s *Service) getUserId(sessionId string) (int, error) {
+	if isAccessToken(sessionId) {
+		claims, err := s.parseAccessToken(sessionId)
+		if err != nil {
+			return 0, err
+		}
+
+		return strconv.Atoi(claims.Subject)
+	}
+
+	return s.repo.GetUserIdBySessionId(sessionId)
+}
+
+type keyring struct {
+	mu       sync.Mutex
+	keys     []*signingKey
+	loadedAt time.Time
+}
+
+type signingKey struct {
+	kid       string
+	key       *rsa.PrivateKey
+	createdAt time.Time
+}
+
+// loadKeys returns the signing keys, newest first, reading them again when
+// the cached ones are older than maxAge.
+func (s *Service) loadKeys(maxAge time.Duration) ([]*signingKey, error) {
+	s.keys.mu.Lock()
+	defer s.keys.mu.Unlock()
+
+	if time.Since(s.keys.loadedAt) < maxAge {
+		return s.keys.keys, nil
+	}
+
+	res, err := s.repo.GetSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*signingKey, 0, len(res))
+	for _, v := range res {
+		block, _ := pem.Decode([]byte(v.PrivateKey))
+		if block == nil {
+			return nil, fmt.Errorf("signing key %s is not PEM", v.Kid)
+		}
+
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s is not RSA", v.Kid)
+		}
+
+		keys = append(keys, &signingKey{
+			kid:       v.Kid,
+			key:       rsaKey,
+			createdAt: v.CreatedAt,
+		})
+	}
+
+	s.keys.keys = keys
+	s.keys.loadedAt = time.Now()
+	return keys, nil
+}
+
+// signingKey returns the newest key, making a new one once it is older
+// than KeyRotation. Old keys stay published until the last token they
+// signed has expired.
+func (s *Service) signingKey() (*signingKey, error) {
+	keys, err := s.loadKeys(keyringTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) > 0 && time.Since(keys[0].createdAt) < s.tokens.KeyRotation {
+		return keys[0], nil
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.repo.CreateSigningKey(types.SigningKeyDB{
+		Kid:        kid[:16],
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(s.tokens.KeyRotation + s.tokens.AccessTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err = s.loadKeys(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys[0], nil
+}
+
+// publicKey returns the key named kid. A kid that is not cached makes the
+// keys be read again, but not more than once a second.
+func (s *Service) publicKey(kid string) (*rsa.PublicKey, error) {
+	for _, maxAge := range []time.Duration{keyringTTL, time.Second} {
+		keys, err := s.loadKeys(maxAge)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range keys {
+			if k.kid == kid {
+				return &k.key.PublicKey, nil
+			}
+		}
+	}
+
+	return nil, jwt.ErrUnknownKey
+}
+
+// GetJWKS returns the public keys that access tokens may be signed with.
+func (s *Service) GetJWKS() (*jwt.KeySet, error) {
+	keys, err := s.loadKeys(keyringTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &jwt.KeySet{Keys: make([]jwt.JWK, len(keys))}
+	for i, k := range keys {
+		res.Keys[i] = jwt.NewJWK(k.kid, &k.key.PublicKey)
+	}
+
+	return res, nil
+}
+
+func (s *Service) DeleteSessionId(sessionId string) error {
+	err := s.repo.DeleteSessionId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), sessionCacheKeys(sessionId))
+}
+
+// sessionCacheKeys returns the cache keys of a session.
+func sessionCacheKeys(sessionId string) []string {
+	return []string{sessionID + sessionId, sessionTwoFactor + hashToken(sessionId)}
+}
+
+func (s *Service) GetUserRoleBySessionId(sessionId string) (int, error) {
+	if isAccessToken(sessionId) {
+		userId, err := s.getUserId(sessionId)
+		if err != nil {
+			return 0, err
+		}
+
+		return s.userRoleId(userId)
+	}
+
+	// API keys are not cached, so that revoking one takes effect at once
+	if IsAPIKey(sessionId) {
+		return s.repo.GetUserRoleBySessionId(sessionId)
+	}
+
+	if data, err := s.redis.Get(context.Background(), sessionID+sessionId); err == nil {
+		return strconv.Atoi(data)
+	}
+
+	roleId, err := s.repo.GetUserRoleBySessionId(sessionId)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.redis.Set(context.Background(), sessionID+sessionId, roleId, sessionTTL)
+	if err != nil {
+		return 0, err
+	}
+
+	return roleId, nil
+}
+
+// userRoleId returns the role of the user of an access token. It is cached
+// like the role of a session and dropped along with it.
+func (s *Service) userRoleId(userId int) (int, error) {
+	key := userRole + strconv.Itoa(userId)
+	if data, err := s.redis.Get(context.Background(), key); err == nil {
+		return strconv.Atoi(data)
+	}
+
+	roleId, err := s.repo.GetUserRoleId(userId)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.redis.Set(context.Background(), key, roleId, sessionTTL)
+	if err != nil {
+		return 0, err
+	}
+
+	return roleId, nil
+}
+
+func (s *Service) HasPermission(sessionId, permission string) (bool, error) {
+	roleId, err := s.GetUserRoleBySessionId(sessionId)
+	if err != nil {
+		return false, err
+	}
+
+	permissions, err := s.rolePermissions(roleId)
+	if err != nil {
+		return false, err
+	}
+
+	if !slices.Contains(permissions, permission) {
+		return false, nil
+	}
+
+	if IsAPIKey(sessionId) {
+		scopes, err := s.repo.GetAPIKeyScopes(sessionId)
+		if err != nil {
+			return false, err
+		}
+
+		if !slices.Contains(scopes, permission) {
+			return false, nil
+		}
+	}
+
+	if s.twoFactor.RequiredForAdmin && isAdmin(permissions) {
+		err = s.checkAdminTwoFactor(sessionId)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// isAdmin tells whether a role with the permissions is an admin role.
+func isAdmin(permissions []string) bool {
+	for _, p := range adminPermissions {
+		if slices.Contains(permissions, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkAdminTwoFactor refuses admin sessions of users who have not turned
+// on two-factor authentication yet. Whether they have is cached like the
+// role of the session, under the hash of the session, since API keys and
+// access tokens come this way too. Turning two-factor on or off drops it for
+// the sessions of the user; access tokens and API keys see the change within
+// sessionTTL.
+func (s *Service) checkAdminTwoFactor(sessionId string) error {
+	key := sessionTwoFactor + hashToken(sessionId)
+	if data, err := s.redis.Get(context.Background(), key); err == nil {
+		if data != "true" {
+			return ErrTwoFactorRequired
+		}
+		return nil
+	}
+
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	tf, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.Set(context.Background(), key, strconv.FormatBool(tf.Enabled), sessionTTL)
+	if err != nil {
+		return err
+	}
+
+	if !tf.Enabled {
+		return ErrTwoFactorRequired
+	}
+
+	return nil
+}
+
+// dropSessionCache makes the sessions of a user read their role and
+// two-factor state again.
+func (s *Service) dropSessionCache(userId int) error {
+	keys, err := s.sessionKeys(userId)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), keys)
+}
+
+func (s *Service) rolePermissions(roleId int) ([]string, error) {
+	key := roleID + strconv.Itoa(roleId)
+	if data, err := s.redis.Get(context.Background(), key); err == nil {
+		var permissions []string
+		err = json.Unmarshal([]byte(data), &permissions)
+		if err != nil {
+			return nil, err
+		}
+
+		return permissions, nil
+	}
+
+	permissions, err := s.repo.GetRolePermissions(roleId)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), key, jsonData, time.Minute*30)
+	if err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// RequestPasswordReset mails a reset token to every account with the email.
+// It does not tell whether there is one, so it cannot be used to find out
+// who has an account.
+func (s *Service) RequestPasswordReset(req types.RequestPasswordResetRequest) error {
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		return errors.New("bad email")
+	}
+
+	users, err := s.repo.GetUsersByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		token, err := newToken()
+		if err != nil {
+			return err
+		}
+
+		err = s.repo.CreatePasswordReset(u.ID, hashToken(token), time.Now().Add(passwordResetTTL))
+		if err != nil {
+			return err
+		}
+
+		err = s.mailer.Send(context.Background(), mailer.Message{
+			To:      u.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hello %s,\n\n"+
+				"use this token to choose a new password for your account:\n\n%s\n\n"+
+				"It works once and expires in %s. If you did not ask for it, ignore this email.\n",
+				u.Username, token, passwordResetTTL),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) ResetPassword(req types.ResetPasswordRequest) error {
+	if req.Token == "" || req.Password == "" {
+		return errors.New("bad request")
+	}
+
+	tokens, err := s.repo.ResetPassword(hashToken(req.Token), req.Password)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for _, token := range tokens {
+		keys = append(keys, sessionCacheKeys(token)...)
+	}
+
+	return s.redis.Del(context.Background(), keys)
+}
+
+func (s *Service) ResendEmailVerification(sessionId string) error {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	count, last, err := s.repo.CountEmailVerifications(userId, time.Now().Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+
+	if count >= emailVerificationsPerHour || time.Since(last) < emailVerificationInterval {
+		return ErrTooManyRequests
+	}
+
+	return s.sendEmailVerification(userId)
+}
+
+func (s *Service) VerifyEmail(req types.VerifyEmailRequest) error {
+	if req.Token == "" {
+		return errors.New("bad request")
+	}
+
+	userId, err := s.repo.VerifyEmail(hashToken(req.Token))
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.Del(context.Background(), []string{userID + strconv.Itoa(userId)})
+	if err != nil {
+		return err
+	}
+
+	return s.redis.DelByPattern(context.Background(), allUsers+":*")
+}
+
+func (s *Service) IsEmailVerified(sessionId string) (bool, error) {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return false, err
+	}
+
+	return s.repo.IsEmailVerified(userId)
+}
+
+func (s *Service) sendEmailVerification(userId int) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	email, err := s.repo.CreateEmailVerification(userId, hashToken(token), time.Now().Add(emailVerificationTTL))
+	if err != nil {
+		return err
+	}
+
+	if email == "" {
+		return errors.New("nothing to verify")
+	}
+
+	return s.mailer.Send(context.Background(), mailer.Message{
+		To:      email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf("Hello,\n\n"+
+			"use this token to confirm your email address:\n\n%s\n\n"+
+			"It works once and expires in %s.\n",
+			token, emailVerificationTTL),
+	})
+}
+
+// SetupTwoFactor gives the user a new secret to add to an authenticator app.
+// It is not used for logins until ConfirmTwoFactor.
+func (s *Service) SetupTwoFactor(sessionId string) (*types.SetupTwoFactorResponse, error) {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.SetTwoFactorSecret(userId, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := s.twoFactor.Issuer
+	if issuer == "" {
+		issuer = defaultTwoFactorIssuer
+	}
+
+	account := tf.Email
+	if account == "" {
+		account = tf.Username
+	}
+
+	return &types.SetupTwoFactorResponse{
+		Secret: secret,
+		URI:    totp.URI(issuer, account, secret),
+	}, nil
+}
+
+// ConfirmTwoFactor turns on two-factor authentication once the user shows a
+// code from the app, and returns recovery codes. They are shown only once.
+func (s *Service) ConfirmTwoFactor(sessionId string, req types.TwoFactorCodeRequest) (*types.ConfirmTwoFactorResponse, error) {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if tf.Enabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	if tf.Secret == "" {
+		return nil, errors.New("two-factor authentication is not set up")
+	}
+
+	step, ok := totp.Validate(tf.Secret, req.Code, time.Now())
+	if !ok {
+		return nil, errors.New("invalid code")
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	err = s.repo.EnableTwoFactor(userId, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.dropSessionCache(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.ConfirmTwoFactorResponse{
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off. It asks for a code
+// so that a stolen session alone cannot do it.
+func (s *Service) DisableTwoFactor(sessionId string, req types.TwoFactorCodeRequest) error {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	ok, err := s.checkTwoFactorCode(userId, req.Code)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errors.New("invalid code")
+	}
+
+	err = s.repo.DisableTwoFactor(userId)
+	if err != nil {
+		return err
+	}
+
+	return s.dropSessionCache(userId)
+}
+
+// checkTwoFactorCode accepts a code from the authenticator app that was not
+// used before, or an unused recovery code, which is used up.
+func (s *Service) checkTwoFactorCode(userId int, code string) (bool, error) {
+	tf, err := s.repo.GetTwoFactor(userId)
+	if err != nil {
+		return false, err
+	}
+
+	if !tf.Enabled {
+		return false, errors.New("two-factor authentication is not enabled")
+	}
+
+	if step, ok := totp.Validate(tf.Secret, code, time.Now()); ok {
+		if step <= tf.LastStep {
+			return false, nil
+		}
+
+		err = s.repo.UseTwoFactorStep(userId, step)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return s.repo.UseRecoveryCode(userId, hashToken(normalizeRecoveryCode(code)))
+}
+
+// newRecoveryCode returns a random code like "k3vq-7xma".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return code[:4] + "-" + code[4:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// newToken returns a random URL-safe token. Only its hash is stored, so a
+// leaked database does not give working tokens away.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Service) GetAllPermissions() (*types.ListPermissionResponse, error) {
+	res, err := s.repo.GetAllPermissions()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.Permission, len(res))
+	for i, v := range res {
+		resp[i] = &types.Permission{
+			Name:        v.Name,
+			Description: v.Description,
+		}
+	}
+
+	return &types.ListPermissionResponse{
+		PermissionsCount: len(resp),
+		Items:            resp,
+	}, nil
+}
+
+func (s *Service) GetAllRoles() (*types.ListRoleResponse, error) {
+	res, err := s.repo.GetAllRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.Role, len(res))
+	for i, v := range res {
+		resp[i] = roleFromDB(v)
+	}
+
+	return &types.ListRoleResponse{
+		RolesCount: len(resp),
+		Items:      resp,
+	}, nil
+}
+
+func (s *Service) GetRoleById(id int) (*types.Role, error) {
+	res, err := s.repo.GetRoleById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return roleFromDB(res), nil
+}
+
+func (s *Service) CreateRole(req types.CreateRoleRequest) (*types.CreateRoleResponse, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, errors.New("bad name")
+	}
+
+	slices.Sort(req.Permissions)
+	req.Permissions = slices.Compact(req.Permissions)
+
+	id, err := s.repo.CreateRole(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.CreateRoleResponse{
+		ID: id,
+	}, nil
+}
+
+func (s *Service) UpdateRole(id int, req types.UpdateRoleRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("bad name")
+	}
+
+	role, err := s.repo.GetRoleById(id)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(builtinRoles, role.Name) && req.Name != role.Name {
+		return errors.New("cannot rename role")
+	}
+
+	slices.Sort(req.Permissions)
+	req.Permissions = slices.Compact(req.Permissions)
+
+	err = s.repo.UpdateRole(id, req)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{roleID + strconv.Itoa(id)})
+}
+
+func (s *Service) DeleteRole(id int) error {
+	role, err := s.repo.GetRoleById(id)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(builtinRoles, role.Name) {
+		return errors.New("cannot delete role")
+	}
+
+	err = s.repo.DeleteRole(id)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{roleID + strconv.Itoa(id)})
+}
+
+func roleFromDB(v *types.RoleDB) *types.Role {
+	return &types.Role{
+		ID:          v.ID,
+		Name:        v.Name,
+		Permissions: v.Permissions,
+	}
+}
+
+// sessionKeys returns the cache keys of every session of a user. They have
+// to be read before the sessions are deleted and dropped afterwards.
+func (s *Service) sessionKeys(userId int) ([]string, error) {
+	tokens, err := s.repo.GetSessionTokens(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// the role cached for the access tokens of the user
+	keys := []string{userRole + strconv.Itoa(userId)}
+	for _, token := range tokens {
+		keys = append(keys, sessionCacheKeys(token)...)
+	}
+
+	return keys, nil
+}
+
+func (s *Service) GetSessions(sessionId string) (*types.ListSessionResponse, error) {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.repo.GetSessions(userId, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.Session, len(res))
+	for i, v := range res {
+		resp[i] = &types.Session{
+			ID:         v.ID,
+			UserAgent:  v.UserAgent,
+			IP:         v.IP,
+			CreatedAt:  v.CreatedAt,
+			LastSeenAt: v.LastSeenAt,
+			ExpiresAt:  v.ExpiresAt,
+			Current:    v.Current,
+		}
+	}
+
+	return &types.ListSessionResponse{
+		SessionsCount: len(resp),
+		Items:         resp,
+	}, nil
+}
+
+func (s *Service) DeleteSession(sessionId string, id int) error {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.sessionKeys(userId)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteSession(userId, id)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), keys)
+}
+
+func (s *Service) DeleteOtherSessions(sessionId string) error {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.sessionKeys(userId)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteOtherSessions(userId, sessionId)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), keys)
+}
+
+func (s *Service) GetAllUsers(req types.PageRequest) (*types.ListUserResponse, error) {
+	key := pageKey(allUsers, req)
+	if data, err := s.redis.Get(context.Background(), key); err == nil {
+		res := &types.ListUserResponse{}
+		err = json.Unmarshal([]byte(data), res)
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+
+	res, nextCursor, err := s.repo.GetAllUsers(req)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := s.repo.CountUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.User, len(res))
+	for i, v := range res {
+		resp[i] = &types.User{
+			ID:            v.ID,
+			Username:      v.Username,
+			Password:      v.Password,
+			Email:         v.Email,
+			Phone:         v.Phone,
+			Role:          v.Role,
+			EmailVerified: v.EmailVerified,
+		}
+	}
+
+	data := &types.ListUserResponse{
+		UsersCount: count,
+		Items:      resp,
+		NextCursor: nextCursor,
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), key, jsonData, time.Minute*30)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *Service) GetUserById(id int) (*types.User, error) {
+	if data, err := s.redis.Get(context.Background(), userID+strconv.Itoa(id)); err == nil {
+		res := &types.User{}
+		err = json.Unmarshal([]byte(data), res)
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+	res, err := s.repo.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &types.User{
+		ID:            res.ID,
+		Username:      res.Username,
+		Password:      res.Password,
+		Email:         res.Email,
+		Phone:         res.Phone,
+		Role:          res.Role,
+		EmailVerified: res.EmailVerified,
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), userID+strconv.Itoa(id), jsonData, time.Minute*30)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *Service) UpdateUserBySessionId(req types.UpdateUserRequest, sessionId string) error {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	keys, err := s.sessionKeys(userId)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.UpdateUser(userId, req)
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.Del(context.Background(), append(keys, userID+strconv.Itoa(userId)))
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allUsers+":*")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) UpdateUserById(id int, req types.UpdateUserByIdRequest) error {
+	keys, err := s.sessionKeys(id)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.UpdateUserById(id, req)
+	if err != nil {
+		return err
+	}
+
+	// cached sessions still carry the old role
+	err = s.redis.Del(context.Background(), append(keys, userID+strconv.Itoa(id)))
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allUsers+":*")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) DeleteUser(id int) error {
+	keys, err := s.sessionKeys(id)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteUser(id)
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.Del(context.Background(), append(keys, userID+strconv.Itoa(id)))
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allUsers+":*")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) GetAllBooks(req types.GetAllBooksRequest) (*types.ListBookResponse, error) {
+	res, nextCursor, err := s.repo.GetAllBooks(req)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := s.repo.CountBooks(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.Book, len(res))
+	for i, v := range res {
+		resp[i] = bookFromDB(v)
+	}
+
+	return &types.ListBookResponse{
+		BooksCount: count,
+		Items:      resp,
+		NextCursor: nextCursor,
+	}, nil
+}
+
+func (s *Service) GetBookById(id int) (*types.Book, error) {
+	if data, err := s.redis.Get(context.Background(), bookID+strconv.Itoa(id)); err == nil {
+		res := &types.Book{}
+		err = json.Unmarshal([]byte(data), res)
+		if err != nil {
+			return nil, err
+		}
+
+		// a sale may have started or ended since the book was cached
+		res.EffectivePrice = effectivePrice(res.Price, res.SalePrice, res.SaleStartsAt, res.SaleEndsAt, time.Now())
+
+		return res, nil
+	}
+
+	res, err := s.repo.GetBookByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := bookFromDB(res)
+
+	jsonData, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), bookID+strconv.Itoa(id), jsonData, time.Minute*30)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (s *Service) CreateBook(req types.CreateBookRequest) (*types.CreateBookResponse, error) {
+	err := checkBookPrice(req.Price, &req.Currency, req.SalePrice, req.SaleStartsAt, req.SaleEndsAt)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.repo.CreateBook(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.CreateBookResponse{
+		ID: id,
+	}, nil
+}
+
+func (s *Service) UpdateBook(id int, req types.UpdateBookRequest) error {
+	err := checkBookPrice(req.Price, &req.Currency, req.SalePrice, req.SaleStartsAt, req.SaleEndsAt)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.UpdateBook(id, req)
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.Del(context.Background(), []string{bookID + strconv.Itoa(id)})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) DeleteBook(id int) error {
+	unused, err := s.repo.DeleteBook(id)
+	if err != nil {
+		return err
+	}
+
+	if unused != "" {
+		err = s.deleteFile(unused)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.redis.Del(context.Background(), []string{bookID + strconv.Itoa(id)})
+	if err != nil {
+		return err
+	}
+
+	// carts holding the book lost their line through the foreign key
+	err = s.redis.DelByPattern(context.Background(), cartUserID+"*")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) GetBookPrices(bookId int) (*types.ListBookPriceResponse, error) {
+	res, err := s.repo.GetBookPrices(bookId)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.BookPrice, len(res))
+	for i, v := range res {
+		resp[i] = bookPriceFromDB(v)
+	}
+
+	return &types.ListBookPriceResponse{
+		PricesCount: len(resp),
+		Items:       resp,
+	}, nil
+}
+
+func (s *Service) GetBookPriceAt(bookId int, at time.Time) (*types.BookPrice, error) {
+	res, err := s.repo.GetBookPriceAt(bookId, at)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := bookPriceFromDB(res)
+	resp.EffectivePrice = &res.EffectivePrice
+
+	return resp, nil
+}
+
+func (s *Service) GetAllAuthors(req types.PageRequest) (*types.ListAuthorResponse, error) {
+	key := pageKey(allAuthors, req)
+	if data, err := s.redis.Get(context.Background(), key); err == nil {
+		res := &types.ListAuthorResponse{}
+		err = json.Unmarshal([]byte(data), res)
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+
+	authors, nextCursor, err := s.repo.GetAllAuthors(req)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := s.repo.CountAuthors()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.Author, len(authors))
+	for i, author := range authors {
+		resp[i] = &types.Author{
+			ID:   author.ID,
+			Name: author.Name,
+		}
+	}
+
+	data := &types.ListAuthorResponse{
+		AuthorsCount: count,
+		Items:        resp,
+		NextCursor:   nextCursor,
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), key, jsonData, time.Minute*30)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *Service) GetAuthorById(id int) (*types.Author, error) {
+	if data, err := s.redis.Get(context.Background(), authorID+strconv.Itoa(id)); err == nil {
+		res := &types.Author{}
+		err = json.Unmarshal([]byte(data), res)
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+
+	res, err := s.repo.GetAuthorById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &types.Author{
+		ID:   res.ID,
+		Name: res.Name,
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), authorID+strconv.Itoa(id), jsonData, time.Minute*30)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *Service) CreateAuthor(req types.CreateAuthorRequest) (*types.CreateAuthorResponse, error) {
+	id, err := s.repo.CreateAuthor(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allAuthors+":*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.CreateAuthorResponse{
+		AuthorId: id,
+	}, nil
+}
+
+func (s *Service) UpdateAuthor(id int, req types.UpdateAuthorRequest) error {
+	err := s.repo.UpdateAuthor(id, req)
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.Del(context.Background(), []string{authorID + strconv.Itoa(id)})
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allAuthors+":*")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) DeleteAuthor(id int) error {
+	err := s.repo.DeleteAuthor(id)
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.Del(context.Background(), []string{authorID + strconv.Itoa(id)})
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allAuthors+":*")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UploadFileByBookId stores the file under the hash of its content, so
+// books with the same file share one object. The uploaded filename is only
+// kept to name downloads.
+func (s *Service) UploadFileByBookId(req types.UploadFileByBookIdRequest) (*types.BookMetadata, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, req.File)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = req.File.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	objectKey := fileObjectPrefix + hex.EncodeToString(hash.Sum(nil))
+	meta, err := s.attachFile(req.ID, types.BookFileDB{
+		Filename:  req.FileHeader.Filename,
+		ObjectKey: objectKey,
+		Size:      &size,
+	}, func() error {
+		return s.minio.PutFile(context.Background(), objectKey, req.File)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the book has its file, so the upload succeeded even when the file
+	// could not be read; GetFileMetadata tries again
+	if meta == nil {
+		return &types.BookMetadata{}, nil
+	}
+
+	if req.Fill {
+		_, err = s.FillBookFromFile(req.ID)
+		if err != nil {
+			log.Printf("fill book %d from its file: %v", req.ID, err)
+		}
+	}
+
+	return bookMetadata(meta), nil
+}
+
+// attachFile points the book at an object, calling store to write it when
+// no book has it yet, and removes the object the book had before when no
+// book uses that any more. It returns the metadata of the file, or nil when
+// the file could not be read.
+func (s *Service) attachFile(bookId int, file types.BookFileDB, store func() error) (*types.FileMetadataDB, error) {
+	unused, err := s.repo.UploadFileByBookId(bookId, file, store)
+	if err != nil {
+		return nil, err
+	}
+
+	if unused != "" {
+		err = s.deleteFile(unused)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.redis.Del(context.Background(), []string{bookID + strconv.Itoa(bookId)})
+	if err != nil {
+		return nil, err
+	}
+
+	// the book has its file even when it cannot be read
+	meta, err := s.readFileMetadata(file.ObjectKey)
+	if err != nil {
+		log.Printf("file metadata of %s: %v", file.ObjectKey, err)
+		return nil, nil
+	}
+
+	return meta, nil
+}
+
+// readFileMetadata reads what the object says about the book in it, once
+// per object, as books with the same content share it. Files that are not
+// EPUB or PDF, or that are broken, get empty metadata, so they are not read
+// again either.
+func (s *Service) readFileMetadata(objectKey string) (*types.FileMetadataDB, error) {
+	meta, err := s.repo.GetFileMetadata(objectKey)
+	if err != nil || meta.ExtractedAt != nil {
+		return meta, err
+	}
+
+	file, err := s.minio.GetFile(context.Background(), objectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	extracted, err := bookmeta.Extract(file, info.Size)
+	switch {
+	case err == nil:
+	case errors.Is(err, bookmeta.ErrUnsupported), errors.Is(err, bookmeta.ErrMalformed), errors.Is(err, bookmeta.ErrEncrypted):
+		extracted = &bookmeta.Metadata{}
+	default:
+		// storage failed, so try again next time
+		return nil, err
+	}
+
+	now := time.Now()
+	meta = &types.FileMetadataDB{
+		Title:       extracted.Title,
+		Authors:     extracted.Authors,
+		ISBN:        extracted.ISBN,
+		Language:    extracted.Language,
+		Description: extracted.Description,
+		ExtractedAt: &now,
+	}
+
+	err = s.repo.SetFileMetadata(objectKey, *meta)
+	if err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// GetFileMetadata returns the title, authors and other fields the file of
+// the book carries.
+func (s *Service) GetFileMetadata(id int) (*types.BookMetadata, error) {
+	bookFile, err := s.repo.GetFileByBookId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := s.readFileMetadata(bookFile.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return bookMetadata(meta), nil
+}
+
+func bookMetadata(meta *types.FileMetadataDB) *types.BookMetadata {
+	return &types.BookMetadata{
+		Title:       meta.Title,
+		Authors:     meta.Authors,
+		ISBN:        meta.ISBN,
+		Language:    meta.Language,
+		Description: meta.Description,
+	}
+}
+
+// FillBookFromFile sets the title, ISBN and description the book lacks to
+// what its file says and returns the book. Authors are left alone, as they
+// are linked records rather than names.
+func (s *Service) FillBookFromFile(id int) (*types.Book, error) {
+	_, err := s.GetFileMetadata(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.FillBookFromFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Del(context.Background(), []string{bookID + strconv.Itoa(id)})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetBookById(id)
+}
+
+// ContentType returns the MIME type of a book file by its name, or "" when
+// it is not known.
+func ContentType(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	if contentType, ok := fileContentTypes[ext]; ok {
+		return contentType
+	}
+
+	return mime.TypeByExtension(ext)
+}
+
+// GetFileURL returns a short-lived link that downloads the file of the book
+// straight from storage.
+func (s *Service) GetFileURL(id int) (string, error) {
+	bookFile, err := s.repo.GetFileByBookId(id)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": bookFile.Filename}))
+	if contentType := ContentType(bookFile.Filename); contentType != "" {
+		params.Set("response-content-type", contentType)
+	}
+
+	return s.minio.PresignGet(context.Background(), bookFile.ObjectKey, downloadURLTTL, params)
+}
+
+type pendingUpload struct {
+	BookId    int    `json:"bookId"`
+	ObjectKey string `json:"objectKey"`
+	Filename  string `json:"filename"`
+}
+
+// CreateFileUpload lets the client put a file in storage itself. The file
+// goes to a temporary object and is attached to the book by
+// FinalizeFileUpload.
+func (s *Service) CreateFileUpload(id int, req types.CreateFileUploadRequest) (*types.CreateFileUploadResponse, error) {
+	if req.Filename == "" {
+		return nil, errors.New("bad request")
+	}
+
+	_, err := s.repo.GetBookByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadId, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	objectKey := uploadObjectPrefix + uploadId
+	uploadURL, err := s.minio.PresignPut(context.Background(), objectKey, uploadURLTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(pendingUpload{BookId: id, ObjectKey: objectKey, Filename: path.Base(req.Filename)})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), fileUpload+uploadId, jsonData, uploadURLTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.CreateFileUploadResponse{
+		UploadID:  uploadId,
+		URL:       uploadURL,
+		Method:    http.MethodPut,
+		ExpiresAt: time.Now().Add(uploadURLTTL),
+	}, nil
+}
+
+// FinalizeFileUpload hashes the uploaded object and moves it under its
+// content key, then attaches it like UploadFileByBookId.
+func (s *Service) FinalizeFileUpload(id int, uploadId string) error {
+	data, err := s.redis.Get(context.Background(), fileUpload+uploadId)
+	if err != nil {
+		return errors.New("invalid or expired upload")
+	}
+
+	var upload pendingUpload
+	err = json.Unmarshal([]byte(data), &upload)
+	if err != nil {
+		return err
+	}
+
+	if upload.BookId != id {
+		return errors.New("invalid or expired upload")
+	}
+
+	// the upload stays until the book has the file, so a failed finalize
+	// can be tried again
+	err = s.storeUpload(id, upload.ObjectKey, upload.Filename)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{fileUpload + uploadId})
+}
+
+// storeUpload moves an uploaded object under the hash of its content and
+// attaches it to the book.
+func (s *Service) storeUpload(bookId int, uploadKey, filename string) error {
+	file, err := s.minio.GetFile(context.Background(), uploadKey)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return errors.New("the file was not uploaded")
+	}
+
+	objectKey := fileObjectPrefix + hex.EncodeToString(hash.Sum(nil))
+	_, err = s.attachFile(bookId, types.BookFileDB{
+		Filename:  filename,
+		ObjectKey: objectKey,
+		Size:      &size,
+	}, func() error {
+		return s.minio.CopyFile(context.Background(), uploadKey, objectKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the book has its file; CleanupUploads removes what is left over
+	err = s.minio.DeleteFile(context.Background(), uploadKey)
+	if err != nil {
+		log.Printf("delete upload %s: %v", uploadKey, err)
+	}
+
+	return nil
+}
+
+// deleteFile removes an object no book uses any more, unless an upload
+// took it up again in the meantime.
+func (s *Service) deleteFile(objectKey string) error {
+	return s.repo.DeleteFile(objectKey, func() error {
+		return s.minio.DeleteFile(context.Background(), objectKey)
+	})
+}
+
+// CreateUpload starts a resumable upload for files too large for one
+// request. The client sends the file in chunks with WriteUpload and can
+// ask GetUpload where to go on after a broken connection.
+func (s *Service) CreateUpload(id int, req types.CreateUploadRequest) (string, error) {
+	if req.Filename == "" || req.Length <= 0 {
+		return "", errors.New("bad request")
+	}
+
+	_, err := s.repo.GetBookByID(id)
+	if err != nil {
+		return "", err
+	}
+
+	uploadId, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	objectKey := uploadObjectPrefix + uploadId
+	storageUploadId, err := s.minio.CreateMultipartUpload(context.Background(), objectKey)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.repo.CreateUpload(types.UploadDB{
+		ID:              uploadId,
+		BookId:          id,
+		Filename:        path.Base(req.Filename),
+		ObjectKey:       objectKey,
+		StorageUploadID: storageUploadId,
+		Length:          req.Length,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return uploadId, nil
+}
+
+func (s *Service) getUpload(id int, uploadId string) (*types.UploadDB, error) {
+	upload, err := s.repo.GetUpload(uploadId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.BookId != id {
+		return nil, ErrUploadNotFound
+	}
+
+	return upload, nil
+}
+
+// GetUpload tells where the upload is. It does not change it: an upload
+// that was written in full but not attached is finished by a chunk at its
+// length or by CleanupUploads.
+func (s *Service) GetUpload(id int, uploadId string) (*types.UploadResponse, error) {
+	upload, err := s.getUpload(id, uploadId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.UploadResponse{
+		Offset: upload.Offset,
+		Length: upload.Length,
+	}, nil
+}
+
+// lockUpload keeps other requests off the upload until unlock is called.
+func (s *Service) lockUpload(uploadId string) (unlock func(), err error) {
+	lock := fileUploadLock + uploadId
+	n, err := s.redis.Incr(context.Background(), lock, uploadLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if n > 1 {
+		return nil, ErrUploadLocked
+	}
+
+	return func() {
+		err := s.redis.Del(context.Background(), []string{lock})
+		if err != nil {
+			log.Printf("unlock upload %s: %v", uploadId, err)
+		}
+	}, nil
+}
+
+// WriteUpload stores a chunk as the next part of the upload and returns the
+// new offset. The chunk is hashed on its way to storage, so the last one
+// can attach the file to the book without reading it again.
+func (s *Service) WriteUpload(req types.WriteUploadRequest) (int64, error) {
+	unlock, err := s.lockUpload(req.UploadID)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	upload, err := s.getUpload(req.BookId, req.UploadID)
+	if err != nil {
+		return 0, err
+	}
+
+	if req.Offset != upload.Offset {
+		return 0, ErrUploadOffset
+	}
+
+	// the last chunk was written but the file could not be attached
+	if upload.Offset == upload.Length {
+		return upload.Length, s.completeUpload(upload)
+	}
+
+	end := upload.Offset + req.Size
+	if req.Size <= 0 || req.Size > minio.MaxPartSize || end > upload.Length || upload.Parts >= minio.MaxParts {
+		return 0, ErrUploadChunk
+	}
+
+	// storage takes small parts only at the end
+	if end < upload.Length && req.Size < minio.MinPartSize {
+		return 0, ErrUploadChunk
+	}
+
+	hash, err := uploadHash(upload)
+	if err != nil {
+		return 0, err
+	}
+
+	body := io.TeeReader(req.Body, hash)
+	err = s.minio.PutPart(context.Background(), upload.ObjectKey, upload.StorageUploadID, upload.Parts+1, body, req.Size)
+	if err != nil {
+		return 0, err
+	}
+
+	hashState, err := hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.repo.AdvanceUpload(upload.ID, upload.Offset, req.Size, hashState)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrUploadOffset
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if end < upload.Length {
+		return end, nil
+	}
+
+	upload.Offset = end
+	upload.HashState = hashState
+
+	return end, s.completeUpload(upload)
+}
+
+// uploadHash returns the sha256 of the bytes written to the upload so far.
+func uploadHash(upload *types.UploadDB) (hash.Hash, error) {
+	h := sha256.New()
+	if len(upload.HashState) == 0 {
+		return h, nil
+	}
+
+	err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.HashState)
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// completeUpload joins the parts of an upload that was written in full and
+// attaches the file to the book. The upload is only forgotten once the book
+// has the file, so that WriteUpload or CleanupUploads can try again after a
+// failure.
+func (s *Service) completeUpload(upload *types.UploadDB) error {
+	err := s.minio.CompleteMultipartUpload(context.Background(), upload.ObjectKey, upload.StorageUploadID)
+	if err != nil {
+		// an earlier try may have got as far
+		_, statErr := s.minio.StatFile(context.Background(), upload.ObjectKey)
+		if statErr != nil {
+			return err
+		}
+	}
+
+	hash, err := uploadHash(upload)
+	if err != nil {
+		return err
+	}
+
+	objectKey := fileObjectPrefix + hex.EncodeToString(hash.Sum(nil))
+	_, err = s.attachFile(upload.BookId, types.BookFileDB{
+		Filename:  upload.Filename,
+		ObjectKey: objectKey,
+		Size:      &upload.Length,
+	}, func() error {
+		return s.minio.CopyFile(context.Background(), upload.ObjectKey, objectKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteUpload(upload.ID)
+	if err != nil {
+		return err
+	}
+
+	// the book has its file; CleanupUploads removes what is left over
+	err = s.minio.DeleteFile(context.Background(), upload.ObjectKey)
+	if err != nil {
+		log.Printf("delete upload %s: %v", upload.ObjectKey, err)
+	}
+
+	return nil
+}
+
+// retryUpload completes an upload written in full unless a request is at it.
+func (s *Service) retryUpload(id int, uploadId string) error {
+	unlock, err := s.lockUpload(uploadId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the request that wrote the last chunk may have finished it meanwhile
+	upload, err := s.getUpload(id, uploadId)
+	if errors.Is(err, ErrUploadNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.completeUpload(upload)
+}
+
+// discardUpload throws away the parts of an upload, or the object they were
+// joined into when the file was never attached.
+func (s *Service) discardUpload(upload *types.UploadDB) error {
+	err := s.minio.AbortMultipartUpload(context.Background(), upload.ObjectKey, upload.StorageUploadID)
+	if err != nil && upload.Offset == upload.Length {
+		return s.minio.DeleteFile(context.Background(), upload.ObjectKey)
+	}
+
+	return err
+}
+
+func (s *Service) DeleteUpload(id int, uploadId string) error {
+	upload, err := s.getUpload(id, uploadId)
+	if err != nil {
+		return err
+	}
+
+	err = s.discardUpload(upload)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.DeleteUpload(upload.ID)
+}
+
+// RunUploadCleanup calls CleanupUploads now and every
+// uploadCleanupInterval. It does not return.
+func (s *Service) RunUploadCleanup() {
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		err := s.CleanupUploads()
+		if err != nil {
+			log.Printf("upload cleanup: %v", err)
+		}
+
+		<-ticker.C
+	}
+}
+
+// CleanupUploads completes resumable uploads that got all their bytes but
+// were not attached to their book, then throws away uploads that got no
+// chunk for uploadIdleTTL, parts left in storage without an upload, and files
+// put in storage directly that were never finalized.
+func (s *Service) CleanupUploads() error {
+	written, err := s.repo.GetWrittenUploads()
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range written {
+		err = s.retryUpload(upload.BookId, upload.ID)
+		if err != nil && !errors.Is(err, ErrUploadLocked) {
+			log.Printf("complete upload %s: %v", upload.ID, err)
+		}
+	}
+
+	before := time.Now().Add(-uploadIdleTTL)
+
+	stale, err := s.repo.DeleteStaleUploads(before)
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range stale {
+		err = s.discardUpload(upload)
+		if err != nil {
+			return err
+		}
+	}
+
+	// uploads of deleted books, or of a server that stopped midway
+	uploads, err := s.minio.ListMultipartUploads(context.Background(), uploadObjectPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, upload := range uploads {
+		if upload.Initiated.After(before) {
+			continue
+		}
+
+		exists, err := s.repo.UploadExists(upload.UploadID)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			err = s.minio.AbortMultipartUpload(context.Background(), upload.Filename, upload.UploadID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	files, err := s.minio.ListFiles(context.Background(), uploadObjectPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.LastModified.Before(before) {
+			err = s.minio.DeleteFile(context.Background(), file.Key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) GetFileByBookId(id int) (res *types.GetFileByBookIdResponse, err error) {
+	bookFile, err := s.repo.GetFileByBookId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.minio.GetFile(context.Background(), bookFile.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &types.GetFileByBookIdResponse{
+		Filename:     bookFile.Filename,
+		File:         file,
+		ETag:         `"` + info.ETag + `"`,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (s *Service) GetAllGenres(req types.PageRequest) (*types.ListGenreResponse, error) {
+	key := pageKey(allGenres, req)
+	if data, err := s.redis.Get(context.Background(), key); err == nil {
+		res := &types.ListGenreResponse{}
+		err = json.Unmarshal([]byte(data), res)
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+
+	genres, nextCursor, err := s.repo.GetAllGenres(req)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := s.repo.CountGenres()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*types.Genre, len(genres))
+	for i, genre := range genres {
+		resp[i] = &types.Genre{
+			ID:   genre.ID,
+			Name: genre.Name,
+		}
+	}
+
+	data := &types.ListGenreResponse{
+		GenresCount: count,
+		Items:       resp,
+		NextCursor:  nextCursor,
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), key, jsonData, time.Minute*30)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *Service) CreateGenre(req types.CreateGenreRequest) (*types.CreateGenreResponse, error) {
+	res, err := s.repo.CreateGenre(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allGenres+":*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.CreateGenreResponse{
+		ID: res,
+	}, nil
+}
+
+func (s *Service) UpdateGenre(id int, req types.UpdateGenreRequest) error {
+	err := s.repo.UpdateGenre(id, req)
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allGenres+":*")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) DeleteGenre(id int) error {
+	err := s.repo.DeleteGenre(id)
+	if err != nil {
+		return err
+	}
+
+	err = s.redis.DelByPattern(context.Background(), allGenres+":*")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) GetCart(sessionId string) (*types.Cart, error) {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := s.redis.Get(context.Background(), cartUserID+strconv.Itoa(userId)); err == nil {
+		res := &types.Cart{}
+		err = json.Unmarshal([]byte(data), res)
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+
+	items, err := s.repo.GetCartItems(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := s.repo.GetCartCoupon(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	discounts, err := s.priceCart(userId, items, code)
+	if errors.Is(err, errCouponInvalid) {
+		// the coupon expired or ran out since it was entered
+		err = s.repo.DeleteCartCoupon(userId)
+		if err != nil {
+			return nil, err
+		}
+
+		code = ""
+		discounts, err = s.priceCart(userId, items, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := &types.Cart{
+		ItemsCount: len(items),
+		Items:      make([]*types.CartItem, len(items)),
+		CouponCode: code,
+	}
+	for i, item := range items {
+		subtotal := item.UnitPrice * int64(item.Quantity)
+		data.Items[i] = &types.CartItem{
+			BookId:    item.BookId,
+			Title:     item.Title,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+			Subtotal:  subtotal,
+		}
+
+		for _, d := range discounts {
+			if d.BookId == item.BookId {
+				data.Items[i].Discount += d.Amount
+				data.Items[i].Discounts = append(data.Items[i].Discounts, discountFromDB(d))
+			}
+		}
+
+		data.Subtotal += subtotal
+		data.Discount += data.Items[i].Discount
+	}
+	data.Total = data.Subtotal - data.Discount
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(context.Background(), cartUserID+strconv.Itoa(userId), jsonData, time.Minute*30)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *Service) AddCartItem(sessionId string, req types.AddCartItemRequest) error {
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	if req.Quantity < 0 {
+		return errors.New("bad quantity")
+	}
+
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.AddCartItem(userId, req)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{cartUserID + strconv.Itoa(userId)})
+}
+
+func (s *Service) UpdateCartItem(sessionId string, bookId int, req types.UpdateCartItemRequest) error {
+	if req.Quantity <= 0 {
+		return errors.New("bad quantity")
+	}
+
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.UpdateCartItem(userId, bookId, req)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{cartUserID + strconv.Itoa(userId)})
+}
+
+func (s *Service) DeleteCartItem(sessionId string, bookId int) error {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteCartItem(userId, bookId)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{cartUserID + strconv.Itoa(userId)})
+}
+
+func (s *Service) ClearCart(sessionId string) error {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.ClearCart(userId)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{cartUserID + strconv.Itoa(userId)})
+}
+
+func (s *Service) ApplyCoupon(sessionId string, req types.ApplyCouponRequest) error {
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	if code == "" {
+		return errCouponInvalid
+	}
+
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	promotions, err := s.repo.GetActivePromotions(userId, code, time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = usablePromotions(promotions, code)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.SetCartCoupon(userId, code)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{cartUserID + strconv.Itoa(userId)})
+}
+
+func (s *Service) RemoveCoupon(sessionId string) error {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteCartCoupon(userId)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Del(context.Background(), []string{cartUserID + strconv.Itoa(userId)})
+}
+
+// Checkout prices the cart again rather than trusting the cached one, and
+// fails if the coupon is no longer valid instead of charging more silently.
+func (s *Service) Checkout(sessionId string) (*types.CreateOrderResponse, error) {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.repo.GetCartItems(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := s.repo.GetCartCoupon(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	discounts, err := s.priceCart(userId, items, code)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.repo.CreateOrder(userId, types.CreateOrderDB{
+		Items:      items,
+		Discounts:  discounts,
+		CouponCode: code,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Del(context.Background(), []string{cartUserID + strconv.Itoa(userId)})
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.CreateOrderResponse{
+		ID: id,
+	}, nil
+}
+
+func (s *Service) GetOrdersBySessionId(sessionId string, req types.PageRequest) (*types.ListOrderResponse, error) {
+	userId, err := s.getUserId(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetAllOrders(types.GetAllOrdersRequest{
+		UserId: userId,
+		Cursor: req.Cursor,
+		Limit:  req.Limit,
+	})
+}
+
+func (s *Service) GetOrderBySessionId(sessionId string, id int) (*types.Order, error) {
+	userId, err := s.getUserId(sessionId)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.redis.Set(context.Background(), userID+strconv.Itoa(id), jsonData, time.Minute*30)
+	res, err := s.repo.GetOrderById(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	if res.UserId != userId {
+		return nil, sql.ErrNoRows
+	}
+
+	return orderFromDB(res), nil
 }
 
-func (s *Service) UpdateUserBySessionId(req types.UpdateUserRequest, sessionId string) error {
-	id, err := s.repo.UpdateUserBySessionId(req, sessionId)
+func (s *Service) GetAllOrders(req types.GetAllOrdersRequest) (*types.ListOrderResponse, error) {
+	res, nextCursor, err := s.repo.GetAllOrders(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = s.redis.Del(context.Background(), []string{allUsers, userID + strconv.Itoa(id)})
+	count, err := s.repo.CountOrders(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	resp := make([]*types.Order, len(res))
+	for i, v := range res {
+		resp[i] = orderFromDB(v)
+	}
+
+	return &types.ListOrderResponse{
+		OrdersCount: count,
+		Items:       resp,
+		NextCursor:  nextCursor,
+	}, nil
 }
 
-func (s *Service) UpdateUserById(id int, req types.UpdateUserByIdRequest) error {
-	err := s.repo.UpdateUserById(id, req)
+func (s *Service) UpdateOrderStatus(id int, req types.UpdateOrderStatusRequest) error {
+	order, err := s.repo.GetOrderById(id)
 	if err != nil {
 		return err
 	}
 
-	err = s.redis.Del(context.Background(), []string{allUsers, userID + strconv.Itoa(id)})
-	if err != nil {
-		return err
+	if !slices.Contains(orderTransitions[order.Status], req.Status) {
+		return fmt.Errorf("cannot change order status from %s to %s", order.Status, req.Status)
 	}
 
-	return nil
-}
+	// the authorization goes before the order is cancelled, so it cannot be
+	// captured afterwards
+	if req.Status == "cancelled" {
+		err = s.voidOrder(id)
+		if err != nil {
+			return err
+		}
+	}
 
-func (s *Service) DeleteUser(id int) error {
-	err := s.repo.DeleteUser(id)
+	// the status is taken before the money moves, so two requests cannot
+	// both refund, and given back if the refund fails
+	err = s.repo.UpdateOrderStatus(id, order.Status, req.Status)
 	if err != nil {
 		return err
 	}
 
-	err = s.redis.Del(context.Background(), []string{allUsers, userID + strconv.Itoa(id)})
-	if err != nil {
-		return err
+	if req.Status == "refunded" {
+		err = s.refundOrder(id)
+		if err != nil {
+			undoErr := s.repo.UpdateOrderStatus(id, req.Status, order.Status)
+			if undoErr != nil {
+				log.Printf("order %d stays refunded after the refund failed: %v", id, undoErr)
+			}
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (s *Service) GetAllBooks(req types.GetAllBooksRequest) (*types.ListBookResponse, error) {
-	res, err := s.repo.GetAllBooks(req)
+// PayOrder charges the order total. The order becomes paid once the capture
+// goes through, either right here or when the provider's webhook arrives.
+func (s *Service) PayOrder(sessionId string, id int) (*types.PayOrderResponse, error) {
+	userId, err := s.getUserId(sessionId)
 	if err != nil {
 		return nil, err
 	}
 
-	resp := make([]*types.Book, len(res))
-	for i, v := range res {
-		resp[i] = &types.Book{
-			ID:    v.ID,
-			Title: v.Title,
-			Author: types.Author{
-				ID:   v.Author.ID,
-				Name: v.Author.Name,
-			},
-			Genre: types.Genre{
-				ID:   v.Genre.ID,
-				Name: v.Genre.Name,
-			},
-			ISBN:        v.ISBN,
-			Filename:    v.Filename,
-			Description: v.Description,
-			CreatedAt:   v.CreatedAt,
-			UpdatedAt:   v.UpdatedAt,
-		}
+	order, err := s.repo.GetOrderById(id)
+	if err != nil {
+		return nil, err
 	}
 
-	return &types.ListBookResponse{
-		BooksCount: len(resp),
-		Items:      resp,
-	}, nil
-}
+	if order.UserId != userId {
+		return nil, sql.ErrNoRows
+	}
 
-func (s *Service) GetBookById(id int) (*types.Book, error) {
-	if data, err := s.redis.Get(context.Background(), bookID+strconv.Itoa(id)); err == nil {
-		res := &types.Book{}
-		err = json.Unmarshal([]byte(data), res)
+	if order.Status != "pending" {
+		return nil, errors.New("order is not pending")
+	}
+
+	// nothing to charge for free books
+	if order.Total == 0 {
+		err = s.repo.UpdateOrderStatus(id, "pending", "paid")
 		if err != nil {
 			return nil, err
 		}
 
-		return res, nil
+		return &types.PayOrderResponse{
+			Status: "paid",
+		}, nil
 	}
 
-	res, err := s.repo.GetBookByID(id)
+	p, err := s.payment.Authorize(context.Background(), payment.AuthorizeRequest{
+		OrderId:        id,
+		Amount:         order.Total,
+		Currency:       order.Currency,
+		IdempotencyKey: "order-" + strconv.Itoa(id),
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	resp := &types.Book{
-		ID:    res.ID,
-		Title: res.Title,
-		Author: types.Author{
-			ID:   res.Author.ID,
-			Name: res.Author.Name,
-		},
-		Genre: types.Genre{
-			ID:   res.Genre.ID,
-			Name: res.Genre.Name,
-		},
-		ISBN:        res.ISBN,
-		Filename:    res.Filename,
-		Description: res.Description,
-		CreatedAt:   res.CreatedAt,
-		UpdatedAt:   res.UpdatedAt,
+	err = s.repo.SavePayment(types.PaymentDB{
+		OrderId:           id,
+		Provider:          s.payment.Name(),
+		ProviderPaymentId: p.ID,
+		Status:            p.Status,
+		Amount:            p.Amount,
+		Currency:          p.Currency,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	jsonData, err := json.Marshal(resp)
+	p, err = s.payment.Capture(context.Background(), p.ID, order.Total)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.redis.Set(context.Background(), bookID+strconv.Itoa(id), jsonData, time.Minute*30)
+	// recorded like a webhook so a real one for the same capture is a no-op
+	_, err = s.repo.ProcessPaymentEvent(types.PaymentEventDB{
+		Provider:          s.payment.Name(),
+		EventId:           "capture:" + p.ID,
+		Type:              payment.EventCaptured,
+		ProviderPaymentId: p.ID,
+	}, p.Status)
+	if errors.Is(err, repository.ErrOrderNotPending) {
+		err = s.refundUnpaidOrder(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errors.New("order is not pending")
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return resp, nil
-}
-
-func (s *Service) CreateBook(req types.CreateBookRequest) (*types.CreateBookResponse, error) {
-	id, err := s.repo.CreateBook(req)
+	order, err = s.repo.GetOrderById(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return &types.CreateBookResponse{
-		ID: id,
+	return &types.PayOrderResponse{
+		PaymentId: p.ID,
+		Status:    order.Status,
 	}, nil
 }
 
-func (s *Service) UpdateBook(id int, req types.UpdateBookRequest) error {
-	err := s.repo.UpdateBook(id, req)
+// HandlePaymentWebhook applies a provider notification. Redelivered events
+// and event types we do not track are accepted and ignored.
+func (s *Service) HandlePaymentWebhook(payload []byte, header http.Header) error {
+	event, err := s.payment.VerifyWebhook(payload, header)
 	if err != nil {
 		return err
 	}
 
-	err = s.redis.Del(context.Background(), []string{bookID + strconv.Itoa(id)})
+	status, ok := paymentStatuses[event.Type]
+	if !ok {
+		return nil
+	}
+
+	orderId, err := s.repo.ProcessPaymentEvent(types.PaymentEventDB{
+		Provider:          s.payment.Name(),
+		EventId:           event.ID,
+		Type:              event.Type,
+		ProviderPaymentId: event.PaymentId,
+	}, status)
+	if errors.Is(err, repository.ErrOrderNotPending) {
+		return s.refundUnpaidOrder(orderId)
+	}
 	if err != nil {
 		return err
 	}
@@ -321,265 +3635,444 @@ func (s *Service) UpdateBook(id int, req types.UpdateBookRequest) error {
 	return nil
 }
 
-func (s *Service) DeleteBook(id int) error {
-	filename, err := s.repo.DeleteBook(id)
+// refundUnpaidOrder gives back money captured for an order that was
+// cancelled meanwhile. The order stays cancelled.
+func (s *Service) refundUnpaidOrder(id int) error {
+	err := s.refundOrder(id)
 	if err != nil {
-		return err
+		log.Printf("order %d was charged after it stopped pending and the refund failed: %v", id, err)
 	}
 
-	err = s.minio.DeleteFile(context.Background(), filename)
+	return err
+}
+
+// voidOrder lets go of the authorization of an order that was not paid.
+func (s *Service) voidOrder(id int) error {
+	p, err := s.repo.GetPaymentByOrderId(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	err = s.redis.Del(context.Background(), []string{bookID + strconv.Itoa(id)})
+	if p.Status != payment.StatusAuthorized {
+		return nil
+	}
+
+	if p.Provider != s.payment.Name() {
+		return errors.New("order was paid through " + p.Provider)
+	}
+
+	res, err := s.payment.Void(context.Background(), p.ProviderPaymentId)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	p.Status = res.Status
+	return s.repo.SavePayment(*p)
 }
 
-func (s *Service) GetAllAuthors() (*types.ListAuthorResponse, error) {
-	if data, err := s.redis.Get(context.Background(), allAuthors); err == nil {
-		res := &types.ListAuthorResponse{}
-		err = json.Unmarshal([]byte(data), res)
-		if err != nil {
-			return nil, err
-		}
+func (s *Service) refundOrder(id int) error {
+	p, err := s.repo.GetPaymentByOrderId(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-		return res, nil
+	if p.Status != payment.StatusCaptured {
+		return nil
+	}
+
+	if p.Provider != s.payment.Name() {
+		return errors.New("order was paid through " + p.Provider)
 	}
 
-	authors, err := s.repo.GetAllAuthors()
+	res, err := s.payment.Refund(context.Background(), p.ProviderPaymentId, p.Amount)
+	if err != nil {
+		return err
+	}
+
+	p.Status = res.Status
+	return s.repo.SavePayment(*p)
+}
+
+func (s *Service) GetInventory(bookId int) (*types.Inventory, error) {
+	res, err := s.repo.GetInventory(bookId)
 	if err != nil {
 		return nil, err
 	}
 
-	resp := make([]*types.Author, len(authors))
-	for i, author := range authors {
-		resp[i] = &types.Author{
-			ID:   author.ID,
-			Name: author.Name,
-		}
+	return inventoryFromDB(res), nil
+}
+
+func (s *Service) UpdateInventory(bookId int, req types.UpdateInventoryRequest) error {
+	if req.LowStockThreshold < 0 {
+		return errors.New("bad threshold")
 	}
 
-	data := &types.ListAuthorResponse{
-		AuthorsCount: len(resp),
-		Items:        resp,
+	return s.repo.UpdateInventory(bookId, req)
+}
+
+func (s *Service) CreateInventoryAdjustment(sessionId string, bookId int, req types.CreateInventoryAdjustmentRequest) (*types.CreateInventoryAdjustmentResponse, error) {
+	err := checkInventoryAdjustment(req)
+	if err != nil {
+		return nil, err
 	}
 
-	jsonData, err := json.Marshal(data)
+	userId, err := s.getUserId(sessionId)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.redis.Set(context.Background(), allAuthors, jsonData, time.Minute*30)
+	id, err := s.repo.CreateInventoryAdjustment(bookId, userId, req)
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	return &types.CreateInventoryAdjustmentResponse{
+		ID: id,
+	}, nil
 }
 
-func (s *Service) GetAuthorById(id int) (*types.Author, error) {
-	if data, err := s.redis.Get(context.Background(), authorID+strconv.Itoa(id)); err == nil {
-		res := &types.Author{}
-		err = json.Unmarshal([]byte(data), res)
-		if err != nil {
-			return nil, err
-		}
-
-		return res, nil
+func (s *Service) GetInventoryAdjustments(bookId int, req types.PageRequest) (*types.ListInventoryAdjustmentResponse, error) {
+	res, nextCursor, err := s.repo.GetInventoryAdjustments(bookId, req)
+	if err != nil {
+		return nil, err
 	}
 
-	res, err := s.repo.GetAuthorById(id)
+	count, err := s.repo.CountInventoryAdjustments(bookId)
 	if err != nil {
 		return nil, err
 	}
 
-	data := &types.Author{
-		ID:   res.ID,
-		Name: res.Name,
+	resp := make([]*types.InventoryAdjustment, len(res))
+	for i, v := range res {
+		resp[i] = &types.InventoryAdjustment{
+			ID:        v.ID,
+			BookId:    v.BookId,
+			Quantity:  v.Quantity,
+			Reason:    v.Reason,
+			Note:      v.Note,
+			OrderId:   v.OrderId,
+			UserId:    v.UserId,
+			CreatedAt: v.CreatedAt,
+		}
 	}
 
-	jsonData, err := json.Marshal(data)
+	return &types.ListInventoryAdjustmentResponse{
+		AdjustmentsCount: count,
+		Items:            resp,
+		NextCursor:       nextCursor,
+	}, nil
+}
+
+func (s *Service) GetLowStock() (*types.ListInventoryResponse, error) {
+	res, err := s.repo.GetLowStock()
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.redis.Set(context.Background(), authorID+strconv.Itoa(id), jsonData, time.Minute*30)
-	if err != nil {
-		return nil, err
+	resp := make([]*types.Inventory, len(res))
+	for i, v := range res {
+		resp[i] = inventoryFromDB(v)
 	}
 
-	return data, nil
+	return &types.ListInventoryResponse{
+		ItemsCount: len(resp),
+		Items:      resp,
+	}, nil
 }
 
-func (s *Service) CreateAuthor(req types.CreateAuthorRequest) (*types.CreateAuthorResponse, error) {
-	id, err := s.repo.CreateAuthor(req)
+// checkInventoryAdjustment makes the sign of the quantity match the reason.
+// Sales are only recorded by orders, never by hand.
+func checkInventoryAdjustment(req types.CreateInventoryAdjustmentRequest) error {
+	switch req.Reason {
+	case "receipt", "return":
+		if req.Quantity <= 0 {
+			return errors.New("bad quantity")
+		}
+	case "damage":
+		if req.Quantity >= 0 {
+			return errors.New("bad quantity")
+		}
+	case "correction":
+		if req.Quantity == 0 {
+			return errors.New("bad quantity")
+		}
+	default:
+		return errors.New("bad reason")
+	}
+
+	return nil
+}
+
+func inventoryFromDB(v *types.InventoryDB) *types.Inventory {
+	return &types.Inventory{
+		BookId:            v.BookId,
+		Title:             v.Title,
+		Tracked:           v.Tracked,
+		OnHand:            v.OnHand,
+		Reserved:          v.Reserved,
+		Available:         v.OnHand - v.Reserved,
+		LowStockThreshold: v.LowStockThreshold,
+	}
+}
+
+func (s *Service) GetAllPromotions(req types.PageRequest) (*types.ListPromotionResponse, error) {
+	res, nextCursor, err := s.repo.GetAllPromotions(req)
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.redis.Del(context.Background(), []string{allAuthors})
+	count, err := s.repo.CountPromotions()
 	if err != nil {
 		return nil, err
 	}
 
-	return &types.CreateAuthorResponse{
-		AuthorId: id,
+	resp := make([]*types.Promotion, len(res))
+	for i, v := range res {
+		resp[i] = promotionFromDB(v)
+	}
+
+	return &types.ListPromotionResponse{
+		PromotionsCount: count,
+		Items:           resp,
+		NextCursor:      nextCursor,
 	}, nil
 }
 
-func (s *Service) UpdateAuthor(id int, req types.UpdateAuthorRequest) error {
-	err := s.repo.UpdateAuthor(id, req)
+func (s *Service) GetPromotionById(id int) (*types.Promotion, error) {
+	res, err := s.repo.GetPromotionById(id)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = s.redis.Del(context.Background(), []string{allAuthors, authorID + strconv.Itoa(id)})
+	return promotionFromDB(res), nil
+}
+
+func (s *Service) CreatePromotion(req types.CreatePromotionRequest) (*types.CreatePromotionResponse, error) {
+	err := checkPromotion(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
-}
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
 
-func (s *Service) DeleteAuthor(id int) error {
-	err := s.repo.DeleteAuthor(id)
+	id, err := s.repo.CreatePromotion(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = s.redis.Del(context.Background(), []string{allAuthors, authorID + strconv.Itoa(id)})
+	// cached carts were priced without it
+	err = s.redis.DelByPattern(context.Background(), cartUserID+"*")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &types.CreatePromotionResponse{
+		ID: id,
+	}, nil
 }
 
-func (s *Service) UploadFileByBookId(req types.UploadFileByBookIdRequest) error {
-	oldFilename, err := s.repo.UploadFileByBookId(req.ID, req.FileHeader.Filename)
+func (s *Service) UpdatePromotion(id int, req types.UpdatePromotionRequest) error {
+	err := checkPromotion(types.CreatePromotionRequest(req))
 	if err != nil {
 		return err
 	}
 
-	if oldFilename != "" {
-		err = s.minio.DeleteFile(context.Background(), oldFilename)
-		if err != nil {
-			return err
-		}
+	req.Code = strings.ToUpper(strings.TrimSpace(req.Code))
+
+	err = s.repo.UpdatePromotion(id, req)
+	if err != nil {
+		return err
 	}
 
-	err = s.minio.PutFile(context.Background(), req.FileHeader.Filename, req.File)
+	return s.redis.DelByPattern(context.Background(), cartUserID+"*")
+}
+
+func (s *Service) DeletePromotion(id int) error {
+	err := s.repo.DeletePromotion(id)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return s.redis.DelByPattern(context.Background(), cartUserID+"*")
 }
 
-func (s *Service) GetFileByBookId(id int) (res *types.GetFileByBookIdResponse, err error) {
-	filename, err := s.repo.GetFileByBookId(id)
+// priceCart returns the discounts the running promotions and the coupon give
+// on items. Cached carts keep their discounts until the cart, its coupon or a
+// promotion changes, so Checkout always prices from scratch.
+func (s *Service) priceCart(userId int, items []*types.CartItemDB, code string) ([]*types.DiscountDB, error) {
+	promotions, err := s.repo.GetActivePromotions(userId, code, time.Now())
 	if err != nil {
 		return nil, err
 	}
 
-	file, err := s.minio.GetFile(context.Background(), filename)
+	usable, err := usablePromotions(promotions, code)
 	if err != nil {
 		return nil, err
 	}
 
-	return &types.GetFileByBookIdResponse{
-		Filename: filename,
-		File:     file,
-	}, nil
+	return applyPromotions(items, usable), nil
 }
 
-func (s *Service) GetAllGenres() (*types.ListGenreResponse, error) {
-	if data, err := s.redis.Get(context.Background(), allGenres); err == nil {
-		res := &types.ListGenreResponse{}
-		err = json.Unmarshal([]byte(data), res)
-		if err != nil {
-			return nil, err
+func promotionFromDB(v *types.PromotionDB) *types.Promotion {
+	return &types.Promotion{
+		ID:           v.ID,
+		Name:         v.Name,
+		Code:         v.Code,
+		Kind:         v.Kind,
+		Value:        v.Value,
+		BuyQuantity:  v.BuyQuantity,
+		GetQuantity:  v.GetQuantity,
+		BookId:       v.BookId,
+		GenreId:      v.GenreId,
+		StartsAt:     v.StartsAt,
+		EndsAt:       v.EndsAt,
+		UsageLimit:   v.UsageLimit,
+		PerUserLimit: v.PerUserLimit,
+		Active:       v.Active,
+		CreatedAt:    v.CreatedAt,
+		UpdatedAt:    v.UpdatedAt,
+	}
+}
+
+func discountFromDB(v *types.DiscountDB) *types.Discount {
+	return &types.Discount{
+		PromotionId: v.PromotionId,
+		Description: v.Description,
+		Amount:      v.Amount,
+	}
+}
+
+func orderFromDB(v *types.OrderDB) *types.Order {
+	items := make([]*types.OrderItem, len(v.Items))
+	for i, item := range v.Items {
+		items[i] = &types.OrderItem{
+			BookId:    item.BookId,
+			Title:     item.Title,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+			Subtotal:  item.UnitPrice * int64(item.Quantity),
 		}
 
-		return res, nil
+		for _, d := range item.Discounts {
+			items[i].Discount += d.Amount
+			items[i].Discounts = append(items[i].Discounts, discountFromDB(d))
+		}
 	}
 
-	genres, err := s.repo.GetAllGenres()
-	if err != nil {
-		return nil, err
+	return &types.Order{
+		ID:         v.ID,
+		UserId:     v.UserId,
+		Status:     v.Status,
+		Subtotal:   v.Subtotal,
+		Discount:   v.Discount,
+		Total:      v.Total,
+		Currency:   v.Currency,
+		CouponCode: v.CouponCode,
+		Items:      items,
+		CreatedAt:  v.CreatedAt,
+		UpdatedAt:  v.UpdatedAt,
 	}
+}
 
-	resp := make([]*types.Genre, len(genres))
-	for i, genre := range genres {
-		resp[i] = &types.Genre{
-			ID:   genre.ID,
-			Name: genre.Name,
+func bookFromDB(v *types.BookDB) *types.Book {
+	authors := make([]types.BookAuthor, len(v.Authors))
+	for i, a := range v.Authors {
+		authors[i] = types.BookAuthor{
+			ID:   a.ID,
+			Name: a.Name,
+			Role: a.Role,
 		}
 	}
 
-	data := &types.ListGenreResponse{
-		GenresCount: len(resp),
-		Items:       resp,
+	genres := make([]types.Genre, len(v.Genres))
+	for i, g := range v.Genres {
+		genres[i] = types.Genre{
+			ID:   g.ID,
+			Name: g.Name,
+		}
 	}
 
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
+	return &types.Book{
+		ID:             v.ID,
+		Title:          v.Title,
+		Authors:        authors,
+		Genres:         genres,
+		ISBN:           v.ISBN,
+		Filename:       v.Filename,
+		Description:    v.Description,
+		Price:          v.Price,
+		Currency:       v.Currency,
+		SalePrice:      v.SalePrice,
+		SaleStartsAt:   v.SaleStartsAt,
+		SaleEndsAt:     v.SaleEndsAt,
+		EffectivePrice: v.EffectivePrice,
+		CreatedAt:      v.CreatedAt,
+		UpdatedAt:      v.UpdatedAt,
+		Snippet:        v.Snippet,
 	}
+}
 
-	err = s.redis.Set(context.Background(), allGenres, jsonData, time.Minute*30)
-	if err != nil {
-		return nil, err
+func bookPriceFromDB(v *types.BookPriceDB) *types.BookPrice {
+	return &types.BookPrice{
+		Price:        v.Price,
+		Currency:     v.Currency,
+		SalePrice:    v.SalePrice,
+		SaleStartsAt: v.SaleStartsAt,
+		SaleEndsAt:   v.SaleEndsAt,
+		ValidFrom:    v.ValidFrom,
 	}
-
-	return data, nil
 }
 
-func (s *Service) CreateGenre(req types.CreateGenreRequest) (*types.CreateGenreResponse, error) {
-	res, err := s.repo.CreateGenre(req)
-	if err != nil {
-		return nil, err
+// checkBookPrice validates the pricing part of a book request. Prices are in
+// minor units of an ISO 4217 currency, which defaults to USD.
+func checkBookPrice(price int64, currency *string, salePrice *int64, startsAt, endsAt *time.Time) error {
+	if *currency == "" {
+		*currency = defaultCurrency
 	}
 
-	err = s.redis.Del(context.Background(), []string{allGenres})
-	if err != nil {
-		return nil, err
+	*currency = strings.ToUpper(*currency)
+	if len(*currency) != 3 || strings.Trim(*currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return errors.New("bad currency")
 	}
 
-	return &types.CreateGenreResponse{
-		ID: res,
-	}, nil
-}
+	if price < 0 {
+		return errors.New("bad price")
+	}
 
-func (s *Service) UpdateGenre(id int, req types.UpdateGenreRequest) error {
-	err := s.repo.UpdateGenre(id, req)
-	if err != nil {
-		return err
+	if salePrice != nil && (*salePrice < 0 || *salePrice > price) {
+		return errors.New("bad sale price")
 	}
 
-	err = s.redis.Del(context.Background(), []string{allGenres})
-	if err != nil {
-		return err
+	if startsAt != nil && endsAt != nil && !startsAt.Before(*endsAt) {
+		return errors.New("bad sale window")
 	}
 
 	return nil
 }
 
-func (s *Service) DeleteGenre(id int) error {
-	err := s.repo.DeleteGenre(id)
-	if err != nil {
-		return err
+// effectivePrice mirrors book_effective_price in the database.
+func effectivePrice(price int64, salePrice *int64, startsAt, endsAt *time.Time, at time.Time) int64 {
+	if salePrice == nil {
+		return price
 	}
 
-	err = s.redis.Del(context.Background(), []string{allGenres})
-	if err != nil {
-		return err
+	if startsAt != nil && startsAt.After(at) {
+		return price
 	}
 
-	return nil
+	if endsAt != nil && !endsAt.After(at) {
+		return price
+	}
+
+	return *salePrice
+}
+
+func pageKey(prefix string, req types.PageRequest) string {
+	return prefix + ":" + strconv.Itoa(req.Limit) + ":" + req.Cursor
 }
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAdmin(t *testing.T) {
+	tests := map[string]struct {
+		permissions []string
+		want        bool
+	}{
+		"case 01: admin": {
+			permissions: []string{PermissionUsersRead, PermissionUsersWrite, PermissionRolesWrite, PermissionBooksWrite},
+			want:        true,
+		},
+		"case 02: custom role that edits roles": {
+			permissions: []string{PermissionRolesWrite},
+			want:        true,
+		},
+		"case 03: custom role that edits users": {
+			permissions: []string{PermissionBooksWrite, PermissionUsersWrite},
+			want:        true,
+		},
+		"case 04: custom role that edits books": {
+			permissions: []string{PermissionBooksWrite, PermissionUsersRead, PermissionRolesRead},
+			want:        false,
+		},
+		"case 05: no permissions": {
+			permissions: nil,
+			want:        false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.want, isAdmin(tt.permissions))
+		})
+	}
+}
+
+func TestLoginConfig_Delay(t *testing.T) {
+	c := LoginConfig{}.withDefaults()
+
+	tests := map[string]struct {
+		n    int64
+		want time.Duration
+	}{
+		"case 01: first failure": {
+			n:    1,
+			want: 250 * time.Millisecond,
+		},
+		"case 02: third failure": {
+			n:    3,
+			want: time.Second,
+		},
+		"case 03: capped": {
+			n:    40,
+			want: 4 * time.Second,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.want, c.delay(tt.n))
+		})
+	}
+}
+
+func TestRetryError(t *testing.T) {
+	var err error = &RetryError{After: time.Second}
+	require.ErrorIs(t, err, ErrTooManyRequests)
+	require.Equal(t, ErrTooManyRequests.Error(), err.Error())
+}
@@ -9,18 +9,146 @@ type UserDB struct {
 	Email    string `postgres:"email"`
 	Phone    string `postgres:"phone"`
 	Role     string `postgres:"role"`
+	// EmailVerified is false until the user confirms the email.
+	EmailVerified bool `postgres:"emailVerified"`
+}
+
+type SessionDB struct {
+	ID         int       `postgres:"id"`
+	UserAgent  string    `postgres:"userAgent"`
+	IP         string    `postgres:"ip"`
+	CreatedAt  time.Time `postgres:"createdAt"`
+	LastSeenAt time.Time `postgres:"lastSeenAt"`
+	ExpiresAt  time.Time `postgres:"expiresAt"`
+	Current    bool      `postgres:"current"`
+}
+
+type TwoFactorDB struct {
+	Secret   string `postgres:"totpSecret"`
+	Enabled  bool   `postgres:"totpEnabled"`
+	LastStep int64  `postgres:"totpLastStep"`
+	Email    string `postgres:"email"`
+	Username string `postgres:"username"`
+}
+
+// ExternalIdentityDB is a user as an OpenID provider knows them.
+type ExternalIdentityDB struct {
+	Issuer        string `postgres:"issuer"`
+	Subject       string `postgres:"subject"`
+	Email         string `postgres:"email"`
+	EmailVerified bool   `postgres:"emailVerified"`
+	Username      string `postgres:"username"`
+}
+
+// BookFileDB is the file of a book. Books with the same content share one
+// object, named by its hash; Filename is what the uploader called it.
+type BookFileDB struct {
+	Filename  string `postgres:"filename"`
+	ObjectKey string `postgres:"objectKey"`
+	// Size is unknown for files uploaded before objects were named by hash.
+	Size *int64 `postgres:"size"`
+}
+
+// FileMetadataDB is what a file says about the book in it.
+type FileMetadataDB struct {
+	Title       string     `postgres:"title"`
+	Authors     []string   `postgres:"authors"`
+	ISBN        string     `postgres:"isbn"`
+	Language    string     `postgres:"language"`
+	Description string     `postgres:"description"`
+	ExtractedAt *time.Time `postgres:"metadataAt"`
+}
+
+// UploadDB is a resumable upload. Each chunk the client sends is stored as
+// one part of a multipart upload in storage.
+type UploadDB struct {
+	ID              string    `postgres:"id"`
+	BookId          int       `postgres:"bookId"`
+	Filename        string    `postgres:"filename"`
+	ObjectKey       string    `postgres:"objectKey"`
+	StorageUploadID string    `postgres:"storageUploadId"`
+	Length          int64     `postgres:"length"`
+	Offset          int64     `postgres:"offset"`
+	Parts           int       `postgres:"parts"`
+	CreatedAt       time.Time `postgres:"createdAt"`
+	UpdatedAt       time.Time `postgres:"updatedAt"`
+	HashState       []byte    `postgres:"hashState"`
+}
+
+// SigningKeyDB is a key for access tokens. PrivateKey is PKCS #8 in PEM.
+type SigningKeyDB struct {
+	Kid        string    `postgres:"kid"`
+	PrivateKey string    `postgres:"privateKey"`
+	CreatedAt  time.Time `postgres:"createdAt"`
+	ExpiresAt  time.Time `postgres:"expiresAt"`
+}
+
+type APIKeyDB struct {
+	ID         int        `postgres:"id"`
+	Name       string     `postgres:"name"`
+	Prefix     string     `postgres:"prefix"`
+	Scopes     []string   `postgres:"scopes"`
+	CreatedAt  time.Time  `postgres:"createdAt"`
+	ExpiresAt  *time.Time `postgres:"expiresAt"`
+	LastUsedAt *time.Time `postgres:"lastUsedAt"`
+}
+
+type AuditEntryDB struct {
+	ID        int       `postgres:"id"`
+	Action    string    `postgres:"action"`
+	UserId    int       `postgres:"userId"`
+	ActorId   int       `postgres:"actorId"`
+	Username  string    `postgres:"username"`
+	IP        string    `postgres:"ip"`
+	Details   string    `postgres:"details"`
+	CreatedAt time.Time `postgres:"createdAt"`
+}
+
+type RoleDB struct {
+	ID          int      `postgres:"id"`
+	Name        string   `postgres:"name"`
+	Permissions []string `postgres:"permissions"`
+}
+
+type PermissionDB struct {
+	Name        string `postgres:"name"`
+	Description string `postgres:"description"`
 }
 
 type BookDB struct {
-	ID          int       `postgres:"id"`
-	Title       string    `postgres:"title"`
-	Author      AuthorDB  `postgres:"author"`
-	Genre       GenreDB   `postgres:"genre"`
-	ISBN        string    `postgres:"isbn"`
-	Filename    string    `postgres:"filename"`
-	Description string    `postgres:"description"`
-	CreatedAt   time.Time `postgres:"createdAt"`
-	UpdatedAt   time.Time `postgres:"updatedAt"`
+	ID             int            `postgres:"id"`
+	Title          string         `postgres:"title"`
+	Authors        []BookAuthorDB `postgres:"authors"`
+	Genres         []GenreDB      `postgres:"genres"`
+	ISBN           string         `postgres:"isbn"`
+	Filename       string         `postgres:"filename"`
+	Description    string         `postgres:"description"`
+	CreatedAt      time.Time      `postgres:"createdAt"`
+	UpdatedAt      time.Time      `postgres:"updatedAt"`
+	Price          int64          `postgres:"price"`
+	Currency       string         `postgres:"currency"`
+	SalePrice      *int64         `postgres:"salePrice"`
+	SaleStartsAt   *time.Time     `postgres:"saleStartsAt"`
+	SaleEndsAt     *time.Time     `postgres:"saleEndsAt"`
+	EffectivePrice int64          `postgres:"effectivePrice"`
+	Rank           float64        `postgres:"rank"`
+	Snippet        string         `postgres:"snippet"`
+}
+
+type BookPriceDB struct {
+	Price          int64      `postgres:"price"`
+	Currency       string     `postgres:"currency"`
+	SalePrice      *int64     `postgres:"salePrice"`
+	SaleStartsAt   *time.Time `postgres:"saleStartsAt"`
+	SaleEndsAt     *time.Time `postgres:"saleEndsAt"`
+	EffectivePrice int64      `postgres:"effectivePrice"`
+	ValidFrom      time.Time  `postgres:"validFrom"`
+}
+
+type BookAuthorDB struct {
+	ID   int    `postgres:"id"`
+	Name string `postgres:"name"`
+	Role string `postgres:"role"`
 }
 
 type AuthorDB struct {
@@ -32,3 +160,107 @@ type GenreDB struct {
 	ID   int    `postgres:"id"`
 	Name string `postgres:"name"`
 }
+
+type CartItemDB struct {
+	BookId    int    `postgres:"bookId"`
+	Title     string `postgres:"title"`
+	Quantity  int    `postgres:"quantity"`
+	UnitPrice int64  `postgres:"unitPrice"`
+	Currency  string `postgres:"currency"`
+	GenreIds  []int  `postgres:"genreIds"`
+}
+
+type OrderDB struct {
+	ID         int            `postgres:"id"`
+	UserId     int            `postgres:"userId"`
+	Status     string         `postgres:"status"`
+	Subtotal   int64          `postgres:"subtotal"`
+	Discount   int64          `postgres:"discount"`
+	Total      int64          `postgres:"total"`
+	CouponCode string         `postgres:"couponCode"`
+	Currency   string         `postgres:"currency"`
+	Items      []*OrderItemDB `postgres:"items"`
+	CreatedAt  time.Time      `postgres:"createdAt"`
+	UpdatedAt  time.Time      `postgres:"updatedAt"`
+}
+
+type OrderItemDB struct {
+	ID        int           `postgres:"id"`
+	BookId    int           `postgres:"bookId"`
+	Title     string        `postgres:"title"`
+	Quantity  int           `postgres:"quantity"`
+	UnitPrice int64         `postgres:"unitPrice"`
+	Discounts []*DiscountDB `postgres:"discounts"`
+}
+
+type DiscountDB struct {
+	BookId      int    `postgres:"bookId"`
+	PromotionId int    `postgres:"promotionId"`
+	Description string `postgres:"description"`
+	Amount      int64  `postgres:"amount"`
+}
+
+// CreateOrderDB is the cart as it was priced; CreateOrder refuses it when the
+// cart changed in the meantime.
+type CreateOrderDB struct {
+	Items      []*CartItemDB
+	Discounts  []*DiscountDB
+	CouponCode string
+}
+
+type InventoryDB struct {
+	BookId            int    `postgres:"bookId"`
+	Title             string `postgres:"title"`
+	Tracked           bool   `postgres:"tracked"`
+	OnHand            int    `postgres:"onHand"`
+	Reserved          int    `postgres:"reserved"`
+	LowStockThreshold int    `postgres:"lowStockThreshold"`
+}
+
+type InventoryAdjustmentDB struct {
+	ID        int       `postgres:"id"`
+	BookId    int       `postgres:"bookId"`
+	Quantity  int       `postgres:"quantity"`
+	Reason    string    `postgres:"reason"`
+	Note      string    `postgres:"note"`
+	OrderId   int       `postgres:"orderId"`
+	UserId    int       `postgres:"userId"`
+	CreatedAt time.Time `postgres:"createdAt"`
+}
+
+type PaymentDB struct {
+	OrderId           int    `postgres:"orderId"`
+	Provider          string `postgres:"provider"`
+	ProviderPaymentId string `postgres:"providerPaymentId"`
+	Status            string `postgres:"status"`
+	Amount            int64  `postgres:"amount"`
+	Currency          string `postgres:"currency"`
+}
+
+type PaymentEventDB struct {
+	Provider          string `postgres:"provider"`
+	EventId           string `postgres:"eventId"`
+	Type              string `postgres:"type"`
+	ProviderPaymentId string `postgres:"providerPaymentId"`
+}
+
+type PromotionDB struct {
+	ID           int        `postgres:"id"`
+	Name         string     `postgres:"name"`
+	Code         string     `postgres:"code"`
+	Kind         string     `postgres:"kind"`
+	Value        int64      `postgres:"value"`
+	BuyQuantity  int        `postgres:"buyQuantity"`
+	GetQuantity  int        `postgres:"getQuantity"`
+	BookId       int        `postgres:"bookId"`
+	GenreId      int        `postgres:"genreId"`
+	StartsAt     *time.Time `postgres:"startsAt"`
+	EndsAt       *time.Time `postgres:"endsAt"`
+	UsageLimit   int        `postgres:"usageLimit"`
+	PerUserLimit int        `postgres:"perUserLimit"`
+	Active       bool       `postgres:"active"`
+	CreatedAt    time.Time  `postgres:"createdAt"`
+	UpdatedAt    time.Time  `postgres:"updatedAt"`
+	Used         int        `postgres:"used"`
+	UsedByUser   int        `postgres:"usedByUser"`
+}
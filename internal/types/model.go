@@ -1,6 +1,7 @@
 package types
 
 import (
+	"io"
 	"mime/multipart"
 	"time"
 
@@ -14,16 +15,186 @@ type User struct {
 	Email    string `json:"email"`
 	Phone    string `json:"phone"`
 	Role     string `json:"role"`
+	// EmailVerified is false until the user confirms the email.
+	EmailVerified bool `json:"emailVerified"`
 }
 
 type GetSessionIdByUsernameRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username        string        `json:"username"`
+	Password        string        `json:"password"`
+	UserAgent       string        `json:"-"`
+	IP              string        `json:"-"`
+	IdleTimeout     time.Duration `json:"-"`
+	AbsoluteTimeout time.Duration `json:"-"`
 }
 
+// GetSessionIdByUsernameResponse carries either a session or, for accounts
+// with two-factor authentication, a challenge to answer at /login/2fa.
 type GetSessionIdByUsernameResponse struct {
-	UserId    int    `json:"userId"`
-	SessionId string `json:"sessionId"`
+	UserId         int        `json:"userId"`
+	SessionId      string     `json:"sessionId,omitempty"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	ChallengeToken string     `json:"challengeToken,omitempty"`
+}
+
+// StartOIDCLoginResponse tells where to send the user to sign in with the
+// identity provider. State comes back to the callback with the code.
+type StartOIDCLoginResponse struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+}
+
+type CompleteOIDCLoginRequest struct {
+	Code      string
+	State     string
+	UserAgent string
+	IP        string
+}
+
+// TokenResponse carries an access token for the Authorization header and a
+// refresh token for POST /token/refresh, or a two-factor challenge to answer
+// at /token/2fa first.
+type TokenResponse struct {
+	AccessToken    string `json:"accessToken,omitempty"`
+	TokenType      string `json:"tokenType,omitempty"`
+	ExpiresIn      int    `json:"expiresIn,omitempty"`
+	RefreshToken   string `json:"refreshToken,omitempty"`
+	ChallengeToken string `json:"challengeToken,omitempty"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+	// Code is a code from the authenticator app or an unused recovery code.
+	Code string `json:"code"`
+
+	UserAgent string `json:"-"`
+	IP        string `json:"-"`
+}
+
+type APIKey struct {
+	ID int `json:"id"`
+	// Prefix is the start of the key, to tell keys apart.
+	Prefix     string     `json:"prefix"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+type ListAPIKeyResponse struct {
+	Items []*APIKey `json:"items"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+	// Scopes are the permissions the key may use, out of those of the role.
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreateAPIKeyResponse holds the key itself. It is not stored and cannot be
+// shown again.
+type CreateAPIKeyResponse struct {
+	ID  int    `json:"id"`
+	Key string `json:"key"`
+}
+
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Action    string    `json:"action"`
+	UserId    int       `json:"userId,omitempty"`
+	ActorId   int       `json:"actorId,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ListAuditEntryResponse struct {
+	EntriesCount int           `json:"entriesCount"`
+	Items        []*AuditEntry `json:"items"`
+	NextCursor   string        `json:"nextCursor,omitempty"`
+}
+
+type SetupTwoFactorResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type ConfirmTwoFactorResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type RequestPasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type Role struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type ListRoleResponse struct {
+	RolesCount int     `json:"rolesCount"`
+	Items      []*Role `json:"items"`
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type CreateRoleResponse struct {
+	ID int `json:"roleId"`
+}
+
+type UpdateRoleRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type ListPermissionResponse struct {
+	PermissionsCount int           `json:"permissionsCount"`
+	Items            []*Permission `json:"items"`
+}
+
+type Session struct {
+	ID         int       `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+type ListSessionResponse struct {
+	SessionsCount int        `json:"sessionsCount"`
+	Items         []*Session `json:"items"`
 }
 
 type CreateUserRequest struct {
@@ -55,6 +226,7 @@ type UpdateUserByIdRequest struct {
 type ListUserResponse struct {
 	UsersCount int     `json:"usersCount"`
 	Items      []*User `json:"items"`
+	NextCursor string  `json:"nextCursor,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -71,29 +243,53 @@ type Genre struct {
 	Name string `json:"name"`
 }
 
+type BookAuthor struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
 type Book struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Author      Author    `json:"author"`
-	Genre       Genre     `json:"genre"`
-	ISBN        string    `json:"isbn"`
-	Filename    string    `json:"filename"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID             int          `json:"id"`
+	Title          string       `json:"title"`
+	Authors        []BookAuthor `json:"authors"`
+	Genres         []Genre      `json:"genres"`
+	ISBN           string       `json:"isbn"`
+	Filename       string       `json:"filename"`
+	Description    string       `json:"description"`
+	Price          int64        `json:"price"`
+	Currency       string       `json:"currency"`
+	SalePrice      *int64       `json:"salePrice,omitempty"`
+	SaleStartsAt   *time.Time   `json:"saleStartsAt,omitempty"`
+	SaleEndsAt     *time.Time   `json:"saleEndsAt,omitempty"`
+	EffectivePrice int64        `json:"effectivePrice"`
+	CreatedAt      time.Time    `json:"createdAt"`
+	UpdatedAt      time.Time    `json:"updatedAt"`
+	Snippet        string       `json:"snippet,omitempty"`
 }
 
 type ListBookResponse struct {
 	BooksCount int     `json:"booksCount"`
 	Items      []*Book `json:"items"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+type BookAuthorRequest struct {
+	AuthorId int    `json:"authorId"`
+	Role     string `json:"role"`
 }
 
 type CreateBookRequest struct {
-	AuthorId    int    `json:"authorId"`
-	GenreId     int    `json:"genreId"`
-	Title       string `json:"title"`
-	ISBN        string `json:"isbn"`
-	Description string `json:"description"`
+	Authors      []BookAuthorRequest `json:"authors"`
+	GenreIds     []int               `json:"genreIds"`
+	Title        string              `json:"title"`
+	ISBN         string              `json:"isbn"`
+	Description  string              `json:"description"`
+	Price        int64               `json:"price"`
+	Currency     string              `json:"currency"`
+	SalePrice    *int64              `json:"salePrice"`
+	SaleStartsAt *time.Time          `json:"saleStartsAt"`
+	SaleEndsAt   *time.Time          `json:"saleEndsAt"`
 }
 
 type CreateBookResponse struct {
@@ -101,21 +297,43 @@ type CreateBookResponse struct {
 }
 
 type UpdateBookRequest struct {
-	AuthorId    int    `json:"authorId"`
-	GenreId     int    `json:"genreId"`
-	Title       string `json:"title"`
-	ISBN        string `json:"isbn"`
-	Description string `json:"description"`
+	Authors      []BookAuthorRequest `json:"authors"`
+	GenreIds     []int               `json:"genreIds"`
+	Title        string              `json:"title"`
+	ISBN         string              `json:"isbn"`
+	Description  string              `json:"description"`
+	Price        int64               `json:"price"`
+	Currency     string              `json:"currency"`
+	SalePrice    *int64              `json:"salePrice"`
+	SaleStartsAt *time.Time          `json:"saleStartsAt"`
+	SaleEndsAt   *time.Time          `json:"saleEndsAt"`
+}
+
+type BookPrice struct {
+	Price          int64      `json:"price"`
+	Currency       string     `json:"currency"`
+	SalePrice      *int64     `json:"salePrice,omitempty"`
+	SaleStartsAt   *time.Time `json:"saleStartsAt,omitempty"`
+	SaleEndsAt     *time.Time `json:"saleEndsAt,omitempty"`
+	EffectivePrice *int64     `json:"effectivePrice,omitempty"`
+	ValidFrom      time.Time  `json:"validFrom"`
+}
+
+type ListBookPriceResponse struct {
+	PricesCount int          `json:"pricesCount"`
+	Items       []*BookPrice `json:"items"`
 }
 
 type ListAuthorResponse struct {
 	AuthorsCount int       `json:"authorsCount"`
 	Items        []*Author `json:"items"`
+	NextCursor   string    `json:"nextCursor,omitempty"`
 }
 
 type ListGenreResponse struct {
 	GenresCount int      `json:"genresCount"`
 	Items       []*Genre `json:"items"`
+	NextCursor  string   `json:"nextCursor,omitempty"`
 }
 
 type CreateAuthorRequest struct {
@@ -142,20 +360,285 @@ type UpdateGenreRequest struct {
 	Name string `json:"name"`
 }
 
+type PageRequest struct {
+	Cursor string
+	Limit  int
+}
+
+type BookFilter struct {
+	AuthorIds     []int
+	GenreIds      []int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	HasFile       *bool
+	ISBNPrefix    string
+	TitleContains string
+	MinPrice      *int64
+	MaxPrice      *int64
+	Currency      string
+}
+
 type GetAllBooksRequest struct {
-	Filter  string
-	ID      string
+	Query   string
+	Filter  BookFilter
 	SortBy  string
 	OrderBy string
+	Cursor  string
+	Limit   int
 }
 
 type UploadFileByBookIdRequest struct {
 	ID         int
 	FileHeader *multipart.FileHeader
 	File       multipart.File
+	// Fill sets the fields the book lacks from the metadata of the file.
+	Fill bool
+}
+
+// BookMetadata is what an EPUB or PDF file says about the book in it.
+type BookMetadata struct {
+	Title       string   `json:"title,omitempty"`
+	Authors     []string `json:"authors,omitempty"`
+	ISBN        string   `json:"isbn,omitempty"`
+	Language    string   `json:"language,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+type CreateFileUploadRequest struct {
+	Filename string `json:"filename"`
+}
+
+// CreateFileUploadResponse tells where to PUT the file. Once it is there,
+// POST to /files/{id}/direct-uploads/{uploadId}/finalize.
+type CreateFileUploadResponse struct {
+	UploadID  string    `json:"uploadId"`
+	URL       string    `json:"url"`
+	Method    string    `json:"method"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CreateUploadRequest starts a resumable upload of Length bytes.
+type CreateUploadRequest struct {
+	Filename string `json:"filename"`
+	Length   int64  `json:"length"`
+}
+
+type UploadResponse struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// WriteUploadRequest is a chunk of a resumable upload that starts at
+// Offset.
+type WriteUploadRequest struct {
+	BookId   int
+	UploadID string
+	Offset   int64
+	Size     int64
+	Body     io.Reader
 }
 
 type GetFileByBookIdResponse struct {
-	Filename string
-	File     *minio.Object
+	Filename     string
+	File         *minio.Object
+	ETag         string
+	LastModified time.Time
+}
+
+type CartItem struct {
+	BookId    int         `json:"bookId"`
+	Title     string      `json:"title"`
+	Quantity  int         `json:"quantity"`
+	UnitPrice int64       `json:"unitPrice"`
+	Subtotal  int64       `json:"subtotal"`
+	Discount  int64       `json:"discount"`
+	Discounts []*Discount `json:"discounts,omitempty"`
+}
+
+type Cart struct {
+	ItemsCount int         `json:"itemsCount"`
+	Items      []*CartItem `json:"items"`
+	CouponCode string      `json:"couponCode,omitempty"`
+	Subtotal   int64       `json:"subtotal"`
+	Discount   int64       `json:"discount"`
+	Total      int64       `json:"total"`
+}
+
+// Discount explains how much a promotion took off a cart or order line.
+type Discount struct {
+	PromotionId int    `json:"promotionId,omitempty"`
+	Description string `json:"description"`
+	Amount      int64  `json:"amount"`
+}
+
+type ApplyCouponRequest struct {
+	Code string `json:"code"`
+}
+
+type AddCartItemRequest struct {
+	BookId   int `json:"bookId"`
+	Quantity int `json:"quantity"`
+}
+
+type UpdateCartItemRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+type OrderItem struct {
+	BookId    int         `json:"bookId"`
+	Title     string      `json:"title"`
+	Quantity  int         `json:"quantity"`
+	UnitPrice int64       `json:"unitPrice"`
+	Subtotal  int64       `json:"subtotal"`
+	Discount  int64       `json:"discount"`
+	Discounts []*Discount `json:"discounts,omitempty"`
+}
+
+type Order struct {
+	ID         int          `json:"id"`
+	UserId     int          `json:"userId"`
+	Status     string       `json:"status"`
+	Subtotal   int64        `json:"subtotal"`
+	Discount   int64        `json:"discount"`
+	Total      int64        `json:"total"`
+	Currency   string       `json:"currency"`
+	CouponCode string       `json:"couponCode,omitempty"`
+	Items      []*OrderItem `json:"items"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	UpdatedAt  time.Time    `json:"updatedAt"`
+}
+
+type ListOrderResponse struct {
+	OrdersCount int      `json:"ordersCount"`
+	Items       []*Order `json:"items"`
+	NextCursor  string   `json:"nextCursor,omitempty"`
+}
+
+type CreateOrderResponse struct {
+	ID int `json:"orderId"`
+}
+
+type PayOrderResponse struct {
+	PaymentId string `json:"paymentId"`
+	Status    string `json:"status"`
+}
+
+type UpdateOrderStatusRequest struct {
+	Status string `json:"status"`
+}
+
+type GetAllOrdersRequest struct {
+	UserId int
+	Status string
+	Cursor string
+	Limit  int
+}
+
+type Inventory struct {
+	BookId            int    `json:"bookId"`
+	Title             string `json:"title"`
+	Tracked           bool   `json:"tracked"`
+	OnHand            int    `json:"onHand"`
+	Reserved          int    `json:"reserved"`
+	Available         int    `json:"available"`
+	LowStockThreshold int    `json:"lowStockThreshold"`
+}
+
+type ListInventoryResponse struct {
+	ItemsCount int          `json:"itemsCount"`
+	Items      []*Inventory `json:"items"`
+}
+
+type UpdateInventoryRequest struct {
+	LowStockThreshold int `json:"lowStockThreshold"`
+}
+
+type InventoryAdjustment struct {
+	ID        int       `json:"id"`
+	BookId    int       `json:"bookId"`
+	Quantity  int       `json:"quantity"`
+	Reason    string    `json:"reason"`
+	Note      string    `json:"note,omitempty"`
+	OrderId   int       `json:"orderId,omitempty"`
+	UserId    int       `json:"userId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ListInventoryAdjustmentResponse struct {
+	AdjustmentsCount int                    `json:"adjustmentsCount"`
+	Items            []*InventoryAdjustment `json:"items"`
+	NextCursor       string                 `json:"nextCursor,omitempty"`
+}
+
+type CreateInventoryAdjustmentRequest struct {
+	Quantity int    `json:"quantity"`
+	Reason   string `json:"reason"`
+	Note     string `json:"note"`
+}
+
+type CreateInventoryAdjustmentResponse struct {
+	ID int `json:"adjustmentId"`
+}
+
+type Promotion struct {
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Code         string     `json:"code,omitempty"`
+	Kind         string     `json:"kind"`
+	Value        int64      `json:"value"`
+	BuyQuantity  int        `json:"buyQuantity,omitempty"`
+	GetQuantity  int        `json:"getQuantity,omitempty"`
+	BookId       int        `json:"bookId,omitempty"`
+	GenreId      int        `json:"genreId,omitempty"`
+	StartsAt     *time.Time `json:"startsAt,omitempty"`
+	EndsAt       *time.Time `json:"endsAt,omitempty"`
+	UsageLimit   int        `json:"usageLimit"`
+	PerUserLimit int        `json:"perUserLimit"`
+	Active       bool       `json:"active"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+type ListPromotionResponse struct {
+	PromotionsCount int          `json:"promotionsCount"`
+	Items           []*Promotion `json:"items"`
+	NextCursor      string       `json:"nextCursor,omitempty"`
+}
+
+type CreatePromotionRequest struct {
+	Name         string     `json:"name"`
+	Code         string     `json:"code"`
+	Kind         string     `json:"kind"`
+	Value        int64      `json:"value"`
+	BuyQuantity  int        `json:"buyQuantity"`
+	GetQuantity  int        `json:"getQuantity"`
+	BookId       int        `json:"bookId"`
+	GenreId      int        `json:"genreId"`
+	StartsAt     *time.Time `json:"startsAt"`
+	EndsAt       *time.Time `json:"endsAt"`
+	UsageLimit   int        `json:"usageLimit"`
+	PerUserLimit int        `json:"perUserLimit"`
+	Active       *bool      `json:"active"`
+}
+
+type CreatePromotionResponse struct {
+	ID int `json:"promotionId"`
+}
+
+type UpdatePromotionRequest struct {
+	Name         string     `json:"name"`
+	Code         string     `json:"code"`
+	Kind         string     `json:"kind"`
+	Value        int64      `json:"value"`
+	BuyQuantity  int        `json:"buyQuantity"`
+	GetQuantity  int        `json:"getQuantity"`
+	BookId       int        `json:"bookId"`
+	GenreId      int        `json:"genreId"`
+	StartsAt     *time.Time `json:"startsAt"`
+	EndsAt       *time.Time `json:"endsAt"`
+	UsageLimit   int        `json:"usageLimit"`
+	PerUserLimit int        `json:"perUserLimit"`
+	Active       *bool      `json:"active"`
 }
@@ -0,0 +1,13 @@
+DROP TRIGGER IF EXISTS genres_search_vector ON genres;
+
+DROP TRIGGER IF EXISTS authors_search_vector ON authors;
+
+DROP TRIGGER IF EXISTS books_search_vector ON books;
+
+DROP FUNCTION IF EXISTS books_search_vector_refresh();
+
+DROP FUNCTION IF EXISTS books_search_vector_update();
+
+DROP INDEX IF EXISTS books_search_vector_idx;
+
+ALTER TABLE books DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,40 @@
+ALTER TABLE books ADD COLUMN search_vector tsvector;
+
+CREATE FUNCTION books_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(NEW.isbn, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce((SELECT name FROM authors WHERE id = NEW.author_id), '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce((SELECT name FROM genres WHERE id = NEW.genre_id), '')), 'C') ||
+        setweight(to_tsvector('english', coalesce(NEW.description, '')), 'D');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_search_vector
+BEFORE INSERT OR UPDATE ON books
+FOR EACH ROW EXECUTE FUNCTION books_search_vector_update();
+
+CREATE FUNCTION books_search_vector_refresh() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'authors' THEN
+        UPDATE books SET title = title WHERE author_id = NEW.id;
+    ELSE
+        UPDATE books SET title = title WHERE genre_id = NEW.id;
+    END IF;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER authors_search_vector
+AFTER UPDATE OF name ON authors
+FOR EACH ROW EXECUTE FUNCTION books_search_vector_refresh();
+
+CREATE TRIGGER genres_search_vector
+AFTER UPDATE OF name ON genres
+FOR EACH ROW EXECUTE FUNCTION books_search_vector_refresh();
+
+UPDATE books SET title = title;
+
+CREATE INDEX books_search_vector_idx ON books USING GIN (search_vector);
@@ -0,0 +1,52 @@
+ALTER TABLE books ADD COLUMN author_id INT REFERENCES authors(id);
+
+ALTER TABLE books ADD COLUMN genre_id INT REFERENCES genres(id);
+
+UPDATE books b
+SET author_id = (SELECT ba.author_id
+                 FROM book_authors ba
+                 WHERE ba.book_id = b.id
+                 ORDER BY ba.role <> 'author', ba.position
+                 LIMIT 1),
+    genre_id = (SELECT bg.genre_id
+                FROM book_genres bg
+                WHERE bg.book_id = b.id
+                ORDER BY bg.position
+                LIMIT 1);
+
+DROP TRIGGER IF EXISTS book_genres_search_vector ON book_genres;
+
+DROP TRIGGER IF EXISTS book_authors_search_vector ON book_authors;
+
+DROP TABLE IF EXISTS book_genres;
+
+DROP TABLE IF EXISTS book_authors;
+
+ALTER TABLE books ALTER COLUMN author_id SET NOT NULL;
+
+ALTER TABLE books ALTER COLUMN genre_id SET NOT NULL;
+
+CREATE OR REPLACE FUNCTION books_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(NEW.isbn, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce((SELECT name FROM authors WHERE id = NEW.author_id), '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce((SELECT name FROM genres WHERE id = NEW.genre_id), '')), 'C') ||
+        setweight(to_tsvector('english', coalesce(NEW.description, '')), 'D');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION books_search_vector_refresh() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'authors' THEN
+        UPDATE books SET title = title WHERE author_id = NEW.id;
+    ELSE
+        UPDATE books SET title = title WHERE genre_id = NEW.id;
+    END IF;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+UPDATE books SET title = title;
@@ -0,0 +1,80 @@
+CREATE TABLE book_authors (
+    book_id   INT NOT NULL,
+    author_id INT NOT NULL,
+    role      TEXT NOT NULL DEFAULT 'author',
+    position  INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, author_id, role),
+    FOREIGN KEY (book_id)   REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (author_id) REFERENCES authors(id),
+    CHECK (role IN ('author', 'editor', 'translator', 'illustrator'))
+);
+
+CREATE INDEX book_authors_author_id_idx ON book_authors (author_id);
+
+CREATE TABLE book_genres (
+    book_id  INT NOT NULL,
+    genre_id INT NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, genre_id),
+    FOREIGN KEY (book_id)  REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (genre_id) REFERENCES genres(id)
+);
+
+CREATE INDEX book_genres_genre_id_idx ON book_genres (genre_id);
+
+INSERT INTO book_authors (book_id, author_id, role, position)
+SELECT id, author_id, 'author', 0
+FROM books;
+
+INSERT INTO book_genres (book_id, genre_id, position)
+SELECT id, genre_id, 0
+FROM books;
+
+CREATE OR REPLACE FUNCTION books_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(NEW.isbn, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce((
+            SELECT string_agg(a.name, ' ')
+            FROM book_authors ba
+            JOIN authors a ON a.id = ba.author_id
+            WHERE ba.book_id = NEW.id), '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce((
+            SELECT string_agg(g.name, ' ')
+            FROM book_genres bg
+            JOIN genres g ON g.id = bg.genre_id
+            WHERE bg.book_id = NEW.id), '')), 'C') ||
+        setweight(to_tsvector('english', coalesce(NEW.description, '')), 'D');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION books_search_vector_refresh() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'authors' THEN
+        UPDATE books SET title = title
+        WHERE id IN (SELECT book_id FROM book_authors WHERE author_id = NEW.id);
+    ELSIF TG_TABLE_NAME = 'genres' THEN
+        UPDATE books SET title = title
+        WHERE id IN (SELECT book_id FROM book_genres WHERE genre_id = NEW.id);
+    ELSIF TG_OP = 'DELETE' THEN
+        UPDATE books SET title = title WHERE id = OLD.book_id;
+    ELSE
+        UPDATE books SET title = title WHERE id = NEW.book_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_authors_search_vector
+AFTER INSERT OR UPDATE OR DELETE ON book_authors
+FOR EACH ROW EXECUTE FUNCTION books_search_vector_refresh();
+
+CREATE TRIGGER book_genres_search_vector
+AFTER INSERT OR UPDATE OR DELETE ON book_genres
+FOR EACH ROW EXECUTE FUNCTION books_search_vector_refresh();
+
+ALTER TABLE books DROP COLUMN author_id;
+
+ALTER TABLE books DROP COLUMN genre_id;
@@ -0,0 +1,11 @@
+CREATE TABLE cart_items (
+    user_id    INT NOT NULL,
+    book_id    INT NOT NULL,
+    quantity   INT NOT NULL CHECK (quantity > 0),
+    unit_price BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, book_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+);
@@ -0,0 +1,27 @@
+CREATE TABLE orders (
+    id         SERIAL PRIMARY KEY,
+    user_id    INT,
+    status     TEXT NOT NULL DEFAULT 'pending',
+    total      BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    CHECK (status IN ('pending', 'paid', 'shipped', 'delivered', 'cancelled', 'refunded'))
+);
+
+CREATE INDEX orders_user_id_idx ON orders (user_id);
+
+CREATE INDEX orders_status_idx ON orders (status);
+
+CREATE TABLE order_items (
+    id         SERIAL PRIMARY KEY,
+    order_id   INT NOT NULL,
+    book_id    INT,
+    title      TEXT NOT NULL,
+    quantity   INT NOT NULL,
+    unit_price BIGINT NOT NULL,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (book_id)  REFERENCES books(id) ON DELETE SET NULL
+);
+
+CREATE INDEX order_items_order_id_idx ON order_items (order_id);
@@ -0,0 +1,14 @@
+DROP TRIGGER IF EXISTS books_price_history ON books;
+
+DROP FUNCTION IF EXISTS books_price_history_insert();
+
+DROP TABLE IF EXISTS price_history;
+
+DROP FUNCTION IF EXISTS book_effective_price(BIGINT, BIGINT, TIMESTAMP, TIMESTAMP, TIMESTAMP);
+
+ALTER TABLE books
+    DROP COLUMN IF EXISTS sale_ends_at,
+    DROP COLUMN IF EXISTS sale_starts_at,
+    DROP COLUMN IF EXISTS sale_price,
+    DROP COLUMN IF EXISTS currency,
+    DROP COLUMN IF EXISTS price;
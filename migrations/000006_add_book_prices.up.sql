@@ -0,0 +1,68 @@
+ALTER TABLE books
+    ADD COLUMN price          BIGINT NOT NULL DEFAULT 0 CHECK (price >= 0),
+    ADD COLUMN currency       TEXT NOT NULL DEFAULT 'USD',
+    ADD COLUMN sale_price     BIGINT CHECK (sale_price >= 0),
+    ADD COLUMN sale_starts_at TIMESTAMP,
+    ADD COLUMN sale_ends_at   TIMESTAMP;
+
+-- book_effective_price is the sale price while the sale window is open and
+-- the regular price otherwise. An open-ended window has no start or no end.
+CREATE FUNCTION book_effective_price(price BIGINT,
+                                     sale_price BIGINT,
+                                     sale_starts_at TIMESTAMP,
+                                     sale_ends_at TIMESTAMP,
+                                     at TIMESTAMP) RETURNS BIGINT AS $$
+    SELECT CASE
+               WHEN sale_price IS NOT NULL
+                    AND (sale_starts_at IS NULL OR sale_starts_at <= at)
+                    AND (sale_ends_at IS NULL OR sale_ends_at > at)
+               THEN sale_price
+               ELSE price
+           END
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE TABLE price_history (
+    id             SERIAL PRIMARY KEY,
+    book_id        INT NOT NULL,
+    price          BIGINT NOT NULL,
+    currency       TEXT NOT NULL,
+    sale_price     BIGINT,
+    sale_starts_at TIMESTAMP,
+    sale_ends_at   TIMESTAMP,
+    valid_from     TIMESTAMP NOT NULL,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+);
+
+CREATE INDEX price_history_book_id_valid_from_idx ON price_history (book_id, valid_from);
+
+CREATE FUNCTION books_price_history_insert() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' OR
+       (NEW.price, NEW.currency, NEW.sale_price, NEW.sale_starts_at, NEW.sale_ends_at) IS DISTINCT FROM
+       (OLD.price, OLD.currency, OLD.sale_price, OLD.sale_starts_at, OLD.sale_ends_at) THEN
+        INSERT INTO price_history (book_id,
+                                   price,
+                                   currency,
+                                   sale_price,
+                                   sale_starts_at,
+                                   sale_ends_at,
+                                   valid_from)
+        VALUES (NEW.id,
+                NEW.price,
+                NEW.currency,
+                NEW.sale_price,
+                NEW.sale_starts_at,
+                NEW.sale_ends_at,
+                coalesce(NEW.updated_at, localtimestamp));
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_price_history
+AFTER INSERT OR UPDATE ON books
+FOR EACH ROW EXECUTE FUNCTION books_price_history_insert();
+
+INSERT INTO price_history (book_id, price, currency, valid_from)
+SELECT id, price, currency, coalesce(created_at, localtimestamp)
+FROM books;
@@ -0,0 +1,5 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS reserved;
+
+DROP TABLE IF EXISTS inventory_adjustments;
+
+DROP TABLE IF EXISTS book_inventory;
@@ -0,0 +1,34 @@
+-- Only physical copies are stocked. A book without a book_inventory row is
+-- sold as a download and never runs out.
+CREATE TABLE book_inventory (
+    book_id             INT PRIMARY KEY,
+    on_hand             INT NOT NULL DEFAULT 0,
+    reserved            INT NOT NULL DEFAULT 0,
+    low_stock_threshold INT NOT NULL DEFAULT 5,
+    updated_at          TIMESTAMP NOT NULL,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    CHECK (reserved >= 0),
+    CHECK (on_hand >= reserved),
+    CHECK (low_stock_threshold >= 0)
+);
+
+CREATE TABLE inventory_adjustments (
+    id         SERIAL PRIMARY KEY,
+    book_id    INT NOT NULL,
+    quantity   INT NOT NULL CHECK (quantity <> 0),
+    reason     TEXT NOT NULL,
+    note       TEXT,
+    order_id   INT,
+    user_id    INT,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (book_id)  REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE SET NULL,
+    FOREIGN KEY (user_id)  REFERENCES users(id) ON DELETE SET NULL,
+    CHECK (reason IN ('receipt', 'sale', 'damage', 'return', 'correction'))
+);
+
+CREATE INDEX inventory_adjustments_book_id_idx ON inventory_adjustments (book_id, id);
+
+-- how many copies checkout took from book_inventory.reserved, so paying or
+-- cancelling settles that and not the stock of books tracked later
+ALTER TABLE order_items ADD COLUMN reserved INT NOT NULL DEFAULT 0 CHECK (reserved >= 0);
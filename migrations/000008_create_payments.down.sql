@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS payment_events;
+
+DROP TABLE IF EXISTS payments;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,29 @@
+ALTER TABLE orders ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+
+CREATE TABLE payments (
+    id                  SERIAL PRIMARY KEY,
+    order_id            INT NOT NULL,
+    provider            TEXT NOT NULL,
+    provider_payment_id TEXT NOT NULL,
+    status              TEXT NOT NULL,
+    amount              BIGINT NOT NULL,
+    currency            TEXT NOT NULL,
+    created_at          TIMESTAMP NOT NULL,
+    updated_at          TIMESTAMP NOT NULL,
+    UNIQUE (provider, provider_payment_id),
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    CHECK (status IN ('authorized', 'captured', 'refunded', 'failed'))
+);
+
+CREATE INDEX payments_order_id_idx ON payments (order_id);
+
+-- payment_events remembers every webhook already handled, so a redelivered
+-- event is acknowledged without being applied twice.
+CREATE TABLE payment_events (
+    provider            TEXT NOT NULL,
+    event_id            TEXT NOT NULL,
+    type                TEXT NOT NULL,
+    provider_payment_id TEXT NOT NULL,
+    received_at         TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, event_id)
+);
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS promotion_redemptions;
+
+DROP TABLE IF EXISTS order_item_discounts;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS coupon_code,
+    DROP COLUMN IF EXISTS discount,
+    DROP COLUMN IF EXISTS subtotal;
+
+DROP TABLE IF EXISTS cart_coupons;
+
+DROP TABLE IF EXISTS promotions;
@@ -0,0 +1,62 @@
+-- A promotion without a code applies on its own; one with a code only once
+-- the customer enters it. book_id and genre_id narrow it to matching lines.
+CREATE TABLE promotions (
+    id             SERIAL PRIMARY KEY,
+    name           TEXT NOT NULL,
+    code           TEXT UNIQUE,
+    kind           TEXT NOT NULL,
+    value          BIGINT NOT NULL DEFAULT 0,
+    buy_quantity   INT NOT NULL DEFAULT 0,
+    get_quantity   INT NOT NULL DEFAULT 0,
+    book_id        INT,
+    genre_id       INT,
+    starts_at      TIMESTAMP,
+    ends_at        TIMESTAMP,
+    usage_limit    INT NOT NULL DEFAULT 0,
+    per_user_limit INT NOT NULL DEFAULT 0,
+    active         BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at     TIMESTAMP NOT NULL,
+    updated_at     TIMESTAMP NOT NULL,
+    FOREIGN KEY (book_id)  REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (genre_id) REFERENCES genres(id) ON DELETE CASCADE,
+    CHECK (kind IN ('percent', 'fixed', 'buy_x_get_y'))
+);
+
+CREATE TABLE cart_coupons (
+    user_id    INT PRIMARY KEY,
+    code       TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+ALTER TABLE orders
+    ADD COLUMN subtotal    BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN discount    BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN coupon_code TEXT;
+
+UPDATE orders SET subtotal = total;
+
+CREATE TABLE order_item_discounts (
+    id            SERIAL PRIMARY KEY,
+    order_item_id INT NOT NULL,
+    promotion_id  INT,
+    description   TEXT NOT NULL,
+    amount        BIGINT NOT NULL,
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id) ON DELETE CASCADE,
+    FOREIGN KEY (promotion_id)  REFERENCES promotions(id) ON DELETE SET NULL
+);
+
+CREATE INDEX order_item_discounts_order_item_id_idx ON order_item_discounts (order_item_id);
+
+CREATE TABLE promotion_redemptions (
+    id           SERIAL PRIMARY KEY,
+    promotion_id INT NOT NULL,
+    order_id     INT NOT NULL,
+    user_id      INT,
+    amount       BIGINT NOT NULL,
+    created_at   TIMESTAMP NOT NULL,
+    UNIQUE (promotion_id, order_id),
+    FOREIGN KEY (promotion_id) REFERENCES promotions(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_id)     REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id)      REFERENCES users(id) ON DELETE SET NULL
+);
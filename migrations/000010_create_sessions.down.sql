@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN session_id TEXT;
+
+UPDATE users u
+SET session_id = s.token
+FROM (SELECT DISTINCT ON (user_id) user_id, token
+      FROM sessions
+      ORDER BY user_id, last_seen_at DESC) s
+WHERE s.user_id = u.id;
+
+DROP TABLE IF EXISTS sessions;
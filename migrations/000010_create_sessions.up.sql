@@ -0,0 +1,29 @@
+CREATE TABLE sessions (
+    id              SERIAL PRIMARY KEY,
+    token           TEXT NOT NULL UNIQUE,
+    user_id         INT NOT NULL,
+    user_agent      TEXT NOT NULL DEFAULT '',
+    ip              TEXT NOT NULL DEFAULT '',
+    idle_timeout    INTERVAL NOT NULL,
+    created_at      TIMESTAMP NOT NULL,
+    last_seen_at    TIMESTAMP NOT NULL,
+    idle_expires_at TIMESTAMP NOT NULL,
+    expires_at      TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+
+INSERT INTO sessions (token, user_id, idle_timeout, created_at, last_seen_at, idle_expires_at, expires_at)
+SELECT session_id,
+       id,
+       interval '7 days',
+       localtimestamp,
+       localtimestamp,
+       localtimestamp + interval '7 days',
+       localtimestamp + interval '30 days'
+FROM users
+WHERE session_id IS NOT NULL
+  AND session_id <> '';
+
+ALTER TABLE users DROP COLUMN session_id;
@@ -0,0 +1,35 @@
+CREATE TABLE permissions (
+    name        TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO permissions (name, description)
+VALUES ('users:read', 'View user accounts'),
+       ('users:write', 'Edit and delete user accounts'),
+       ('roles:read', 'View roles and permissions'),
+       ('roles:write', 'Create, edit and delete roles'),
+       ('books:write', 'Create, edit and delete books and their files'),
+       ('authors:write', 'Create, edit and delete authors'),
+       ('genres:write', 'Create, edit and delete genres'),
+       ('prices:read', 'View the price history of books'),
+       ('orders:read', 'View orders of every user'),
+       ('orders:write', 'Change the status of orders'),
+       ('orders:refund', 'Refund orders'),
+       ('inventory:read', 'View stock and its history'),
+       ('inventory:write', 'Adjust stock'),
+       ('promotions:read', 'View promotions'),
+       ('promotions:write', 'Create, edit and delete promotions');
+
+CREATE TABLE role_permissions (
+    role_id    INT NOT NULL,
+    permission TEXT NOT NULL,
+    PRIMARY KEY (role_id, permission),
+    FOREIGN KEY (role_id)    REFERENCES roles(id) ON DELETE CASCADE,
+    FOREIGN KEY (permission) REFERENCES permissions(name) ON DELETE CASCADE
+);
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name
+FROM roles r
+CROSS JOIN permissions p
+WHERE r.name = 'admin';
@@ -0,0 +1,18 @@
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+-- accounts made before verification existed keep working
+UPDATE users
+SET email_verified_at = localtimestamp;
+
+CREATE TABLE email_verifications (
+    id         SERIAL PRIMARY KEY,
+    user_id    INT NOT NULL,
+    email      TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX email_verifications_user_id_idx ON email_verifications (user_id);
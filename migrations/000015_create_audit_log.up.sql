@@ -0,0 +1,20 @@
+CREATE TABLE audit_log (
+    id         SERIAL PRIMARY KEY,
+    action     TEXT NOT NULL,
+    user_id    INT,
+    actor_id   INT,
+    username   TEXT NOT NULL DEFAULT '',
+    ip         TEXT NOT NULL DEFAULT '',
+    details    TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id)  REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+INSERT INTO permissions (name, description)
+VALUES ('audit:read', 'View the audit log');
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, 'audit:read'
+FROM roles r
+WHERE r.name = 'admin';
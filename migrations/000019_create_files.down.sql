@@ -0,0 +1,7 @@
+UPDATE books
+SET filename = object_key
+WHERE object_key IS NOT NULL;
+
+ALTER TABLE books DROP COLUMN IF EXISTS object_key;
+
+DROP TABLE IF EXISTS files;
@@ -0,0 +1,19 @@
+CREATE TABLE files (
+    object_key TEXT PRIMARY KEY,
+    size       BIGINT,
+    ref_count  INT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE books ADD COLUMN object_key TEXT REFERENCES files(object_key);
+
+-- objects uploaded before keep their filename as the key
+INSERT INTO files (object_key, ref_count, created_at)
+SELECT filename, count(*), now()
+FROM books
+WHERE coalesce(filename, '') <> ''
+GROUP BY filename;
+
+UPDATE books
+SET object_key = filename
+WHERE coalesce(filename, '') <> '';
@@ -0,0 +1,18 @@
+CREATE TABLE uploads (
+    id                TEXT PRIMARY KEY,
+    book_id           INT NOT NULL,
+    filename          TEXT NOT NULL,
+    object_key        TEXT NOT NULL,
+    storage_upload_id TEXT NOT NULL UNIQUE,
+    length            BIGINT NOT NULL,
+    upload_offset     BIGINT NOT NULL,
+    parts             INT NOT NULL,
+    created_at        TIMESTAMP NOT NULL,
+    updated_at        TIMESTAMP NOT NULL,
+    -- the sha256 state of the bytes written so far, so the finished file
+    -- does not have to be read again to find its content key
+    hash_state        BYTEA NOT NULL DEFAULT '',
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+);
+
+CREATE INDEX uploads_updated_at_idx ON uploads (updated_at);
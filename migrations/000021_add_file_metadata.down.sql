@@ -0,0 +1,7 @@
+ALTER TABLE files
+    DROP COLUMN IF EXISTS title,
+    DROP COLUMN IF EXISTS authors,
+    DROP COLUMN IF EXISTS isbn,
+    DROP COLUMN IF EXISTS language,
+    DROP COLUMN IF EXISTS description,
+    DROP COLUMN IF EXISTS metadata_at;
@@ -0,0 +1,8 @@
+-- what the file says about the book; metadata_at is NULL until it was read
+ALTER TABLE files
+    ADD COLUMN title       TEXT,
+    ADD COLUMN authors     TEXT[],
+    ADD COLUMN isbn        TEXT,
+    ADD COLUMN language    TEXT,
+    ADD COLUMN description TEXT,
+    ADD COLUMN metadata_at TIMESTAMP;
@@ -0,0 +1,135 @@
+// Package bookmeta reads what EPUB and PDF files tell about the book in
+// them: the title, authors, ISBN, language and description.
+package bookmeta
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported file format")
+	ErrMalformed   = errors.New("malformed file")
+	ErrEncrypted   = errors.New("file is encrypted")
+)
+
+// Metadata holds the fields the file had. Missing fields are empty.
+type Metadata struct {
+	Title       string
+	Authors     []string
+	ISBN        string
+	Language    string
+	Description string
+}
+
+// Extract reads the metadata of the file in r, which is size bytes long.
+// The format is told by the first bytes of the file, not by its name.
+func Extract(r io.ReaderAt, size int64) (*Metadata, error) {
+	head := make([]byte, 5)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head[:n], []byte("%PDF-")):
+		return readPDF(r, size)
+	case bytes.HasPrefix(head[:n], []byte("PK\x03\x04")):
+		return readEPUB(r, size)
+	}
+
+	return nil, ErrUnsupported
+}
+
+var isbnPattern = regexp.MustCompile(`(?i)ISBN(?:-1[03])?:?\s*([0-9][0-9\- ]{8,15}[0-9X])`)
+
+// findISBN returns the first valid ISBN that follows an "ISBN" label in s.
+func findISBN(s string) string {
+	for _, m := range isbnPattern.FindAllStringSubmatch(s, -1) {
+		isbn := NormalizeISBN(m[1])
+		if isbn != "" {
+			return isbn
+		}
+	}
+
+	return ""
+}
+
+// NormalizeISBN returns the ISBN-10 or ISBN-13 in s without hyphens and
+// spaces, or "" when s is not a valid ISBN. A "urn:isbn:" or "isbn:"
+// prefix is dropped.
+func NormalizeISBN(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimPrefix(s, "urn:isbn:")
+	s = strings.TrimPrefix(s, "isbn:")
+
+	b := make([]byte, 0, 13)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			b = append(b, c)
+		case c == 'x':
+			b = append(b, 'X')
+		case c == '-' || c == ' ':
+		default:
+			return ""
+		}
+	}
+
+	switch {
+	case len(b) == 10 && validISBN10(b):
+		return string(b)
+	case len(b) == 13 && validISBN13(b):
+		return string(b)
+	}
+
+	return ""
+}
+
+func validISBN10(b []byte) bool {
+	sum := 0
+	for i, c := range b {
+		d := int(c - '0')
+		if c == 'X' {
+			if i != 9 {
+				return false
+			}
+			d = 10
+		}
+		sum += (10 - i) * d
+	}
+
+	return sum%11 == 0
+}
+
+func validISBN13(b []byte) bool {
+	sum := 0
+	for i, c := range b {
+		if c == 'X' {
+			return false
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+
+	return sum%10 == 0
+}
+
+// splitAuthors splits a list of names like "Jane Doe; John Roe".
+func splitAuthors(s string) []string {
+	var authors []string
+	for _, a := range strings.Split(s, ";") {
+		a = strings.TrimSpace(a)
+		if a != "" {
+			authors = append(authors, a)
+		}
+	}
+
+	return authors
+}
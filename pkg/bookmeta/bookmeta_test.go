@@ -0,0 +1,283 @@
+package bookmeta
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>The Go Programming Language</dc:title>
+    <dc:creator opf:role="aut">Alan A. A. Donovan</dc:creator>
+    <dc:creator>Brian W. Kernighan</dc:creator>
+    <dc:creator opf:role="edt">Some Editor</dc:creator>
+    <dc:identifier opf:scheme="UUID">urn:uuid:0b5b8d2c-1f6e-4f1e-9d33-3a3b9a0f6a11</dc:identifier>
+    <dc:identifier opf:scheme="ISBN">978-0-13-419044-0</dc:identifier>
+    <dc:language>en</dc:language>
+    <dc:description>&lt;p&gt;The authoritative resource &amp;amp; guide.&lt;/p&gt;</dc:description>
+  </metadata>
+</package>`
+
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+var testMetadata = &Metadata{
+	Title:       "The Go Programming Language",
+	Authors:     []string{"Alan A. A. Donovan", "Brian W. Kernighan"},
+	ISBN:        "9780134190440",
+	Language:    "en",
+	Description: "The authoritative resource & guide.",
+}
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, name := range []string{"mimetype", "META-INF/container.xml", "OEBPS/content.opf"} {
+		content, ok := files[name]
+		if !ok {
+			continue
+		}
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	return b.Bytes()
+}
+
+// buildPDF writes objects 1 to n with a cross-reference table.
+func buildPDF(objects []string, trailer string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f\r\n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n\r\n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n%s\nstartxref\n%d\n%%%%EOF\n", trailer, xref)
+
+	return b.Bytes()
+}
+
+// buildPDFStreams writes the catalog as object 1 and the info dictionary
+// as object 3 inside the object stream 2, with a PNG-predicted
+// cross-reference stream as object 4 and the length of the object
+// stream as object 5. parms are the decode parameters of the
+// cross-reference stream.
+func buildPDFStreams(catalog, info, parms string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+
+	offsets := make([]int, 6)
+
+	offsets[1] = b.Len()
+	fmt.Fprintf(&b, "1 0 obj\n%s\nendobj\n", catalog)
+
+	header := "3 0 "
+	objstm := deflate([]byte(header + info))
+	offsets[2] = b.Len()
+	fmt.Fprintf(&b, "2 0 obj\n<< /Type /ObjStm /N 1 /First %d /Length 5 0 R /Filter /FlateDecode >>\nstream\r\n", len(header))
+	b.Write(objstm)
+	b.WriteString("\nendstream\nendobj\n")
+
+	offsets[5] = b.Len()
+	fmt.Fprintf(&b, "5 0 obj\n%d\nendobj\n", len(objstm))
+
+	offsets[4] = b.Len()
+	rows := [][]byte{
+		{0, 0, 0, 0},
+		{1, byte(offsets[1] >> 8), byte(offsets[1]), 0},
+		{1, byte(offsets[2] >> 8), byte(offsets[2]), 0},
+		{2, 0, 2, 0},
+		{1, byte(offsets[4] >> 8), byte(offsets[4]), 0},
+		{1, byte(offsets[5] >> 8), byte(offsets[5]), 0},
+	}
+	var data []byte
+	prev := make([]byte, 4)
+	for _, row := range rows {
+		data = append(data, 2)
+		for i := range row {
+			data = append(data, row[i]-prev[i])
+		}
+		prev = row
+	}
+	xref := deflate(data)
+
+	fmt.Fprintf(&b, "4 0 obj\n<< /Type /XRef /Size 6 /W [1 2 1] /Root 1 0 R /Info 3 0 R /Filter /FlateDecode /DecodeParms << %s >> /Length %d >>\nstream\n", parms, len(xref))
+	b.Write(xref)
+	fmt.Fprintf(&b, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", offsets[4])
+
+	return b.Bytes()
+}
+
+// buildPDFXref writes a file with nothing but a cross-reference stream
+// holding data.
+func buildPDFXref(parms string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+
+	off := b.Len()
+	xref := deflate(data)
+	fmt.Fprintf(&b, "1 0 obj\n<< /Type /XRef /Size 2 /W [1 2 1] /Filter /FlateDecode /DecodeParms << %s >> /Length %d >>\nstream\n", parms, len(xref))
+	b.Write(xref)
+	fmt.Fprintf(&b, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", off)
+
+	return b.Bytes()
+}
+
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	zw.Write(data)
+	zw.Close()
+
+	return b.Bytes()
+}
+
+func utf16Hex(s string) string {
+	out := "<FEFF"
+	for _, u := range utf16.Encode([]rune(s)) {
+		out += fmt.Sprintf("%04X", u)
+	}
+
+	return out + ">"
+}
+
+func TestExtract(t *testing.T) {
+	info := fmt.Sprintf(`<< /Title (The Go \(Programming\)\040Language) /Author %s /Subject (The authoritative resource & guide.) /Keywords (go; ISBN: 978-0-13-419044-0) >>`,
+		utf16Hex("Alan A. A. Donovan; Brian W. Kernighan"))
+	catalog := `<< /Type /Catalog /Pages 3 0 R /Lang (en) >>`
+
+	pdfMetadata := *testMetadata
+	pdfMetadata.Title = "The Go (Programming) Language"
+
+	tests := map[string]struct {
+		file []byte
+		want *Metadata
+		err  error
+	}{
+		"case 01: epub": {
+			file: buildZip(t, map[string]string{
+				"mimetype":               "application/epub+zip",
+				"META-INF/container.xml": testContainer,
+				"OEBPS/content.opf":      testOPF,
+			}),
+			want: testMetadata,
+		},
+		"case 02: zip without container": {
+			file: buildZip(t, map[string]string{"mimetype": "application/zip"}),
+			err:  ErrUnsupported,
+		},
+		"case 03: pdf with xref table": {
+			file: buildPDF([]string{catalog, info, `<< /Type /Pages /Kids [] /Count 0 >>`}, `<< /Size 4 /Root 1 0 R /Info 2 0 R >>`),
+			want: &pdfMetadata,
+		},
+		"case 04: pdf with object streams": {
+			file: buildPDFStreams(catalog, info, "/Predictor 12 /Columns 4"),
+			want: &pdfMetadata,
+		},
+		"case 05: pdf without info": {
+			file: buildPDF([]string{`<< /Type /Catalog >>`}, `<< /Size 2 /Root 1 0 R >>`),
+			want: &Metadata{},
+		},
+		"case 06: encrypted pdf": {
+			file: buildPDF([]string{catalog, info}, `<< /Size 3 /Root 1 0 R /Info 2 0 R /Encrypt << /Filter /Standard >> >>`),
+			err:  ErrEncrypted,
+		},
+		"case 07: truncated pdf": {
+			file: []byte("%PDF-1.4\n1 0 obj\n<< /Title (x"),
+			err:  ErrMalformed,
+		},
+		"case 08: plain text": {
+			file: []byte("just some text"),
+			err:  ErrUnsupported,
+		},
+		"case 09: pdf with huge predictor columns": {
+			file: buildPDFStreams(catalog, info, "/Predictor 12 /Columns 4611686018427387904"),
+			err:  ErrMalformed,
+		},
+		"case 10: pdf with huge predictor rows and no data": {
+			file: buildPDFXref("/Predictor 12 /Columns 1099511627776", nil),
+			err:  ErrMalformed,
+		},
+		"case 11: pdf with predictor rows longer than the stream": {
+			file: buildPDFXref("/Predictor 12 /Columns 100000 /Colors 4", make([]byte, 1<<20)),
+			err:  ErrMalformed,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m, err := Extract(bytes.NewReader(tt.file), int64(len(tt.file)))
+			require.Equal(t, tt.err, err)
+			require.Equal(t, tt.want, m)
+		})
+	}
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := map[string]string{
+		"978-0-13-419044-0":          "9780134190440",
+		"urn:isbn:978-0-306-40615-7": "9780306406157",
+		"ISBN: 0-306-40615-2":        "0306406152",
+		"isbn:0-306-40615-2":         "0306406152",
+		"0-8044-2957-X":              "080442957X",
+		"978-0-13-419044-1":          "",
+		"0-306-40615-3":              "",
+		"12345":                      "",
+		"X-306-40615-2":              "",
+	}
+
+	for in, want := range tests {
+		t.Run(in, func(t *testing.T) {
+			require.Equal(t, want, NormalizeISBN(in))
+		})
+	}
+
+	require.Equal(t, "0306406152", findISBN("Printed in 2001. ISBN 0-306-40615-2, first edition"))
+}
+
+func FuzzExtract(f *testing.F) {
+	info := `<< /Title (Title) /Author (Author) /Keywords (ISBN 0-306-40615-2) >>`
+	catalog := `<< /Type /Catalog /Lang (en) >>`
+
+	f.Add(buildPDF([]string{catalog, info}, `<< /Size 3 /Root 1 0 R /Info 2 0 R >>`))
+	f.Add(buildPDFStreams(catalog, info, "/Predictor 12 /Columns 4"))
+	f.Add([]byte("%PDF-1.4\n1 0 obj\n<< /Title (x"))
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, content := range map[string]string{"META-INF/container.xml": testContainer, "OEBPS/content.opf": testOPF} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	f.Add(b.Bytes())
+
+	f.Fuzz(func(t *testing.T, file []byte) {
+		m, err := Extract(bytes.NewReader(file), int64(len(file)))
+		if err == nil && m == nil {
+			t.Fatal("no metadata and no error")
+		}
+	})
+}
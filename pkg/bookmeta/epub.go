@@ -0,0 +1,123 @@
+package bookmeta
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxOPFSize keeps a hostile package document from filling the memory.
+const maxOPFSize = 1 << 20
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// epubPackage is the OPF package document. Its Dublin Core elements are
+// matched by local name, so the dc prefix does not matter.
+type epubPackage struct {
+	Metadata struct {
+		Titles   []string `xml:"title"`
+		Creators []struct {
+			Name string `xml:",chardata"`
+			Role string `xml:"role,attr"`
+		} `xml:"creator"`
+		Identifiers []struct {
+			Value  string `xml:",chardata"`
+			Scheme string `xml:"scheme,attr"`
+		} `xml:"identifier"`
+		Languages    []string `xml:"language"`
+		Descriptions []string `xml:"description"`
+	} `xml:"metadata"`
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+func readEPUB(r io.ReaderAt, size int64) (*Metadata, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var container epubContainer
+	err = decodeZipXML(zr, "META-INF/container.xml", &container)
+	if err != nil {
+		// a zip file without a container is not an EPUB
+		return nil, ErrUnsupported
+	}
+
+	opf := ""
+	for _, rf := range container.Rootfiles {
+		if rf.MediaType == "" || rf.MediaType == "application/oebps-package+xml" {
+			opf = rf.FullPath
+			break
+		}
+	}
+	if opf == "" {
+		return nil, ErrMalformed
+	}
+
+	var pkg epubPackage
+	err = decodeZipXML(zr, path.Clean(opf), &pkg)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	md := pkg.Metadata
+	m := &Metadata{
+		Title:       first(md.Titles),
+		Language:    first(md.Languages),
+		Description: first(md.Descriptions),
+	}
+
+	for _, c := range md.Creators {
+		// EPUB 3 gives roles in refining meta elements, so a creator
+		// without a role counts as an author
+		name := strings.TrimSpace(c.Name)
+		if name != "" && (c.Role == "" || c.Role == "aut") {
+			m.Authors = append(m.Authors, name)
+		}
+	}
+
+	for _, id := range md.Identifiers {
+		isbn := NormalizeISBN(id.Value)
+		if isbn != "" {
+			m.ISBN = isbn
+			break
+		}
+	}
+
+	// descriptions often hold escaped HTML
+	m.Description = strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(m.Description, " ")))
+	m.Description = strings.Join(strings.Fields(m.Description), " ")
+
+	return m, nil
+}
+
+func decodeZipXML(zr *zip.Reader, name string, v any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return xml.NewDecoder(io.LimitReader(f, maxOPFSize)).Decode(v)
+}
+
+func first(s []string) string {
+	for _, v := range s {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,850 @@
+package bookmeta
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// The reader only follows the cross-reference data to the document info
+// dictionary and the catalog, so it reads a few small pieces of the file
+// however big the file is. These limits bound the pieces.
+const (
+	maxPDFObjectSize = 64 << 10
+	maxPDFXrefSize   = 8 << 20
+	maxPDFStreamSize = 16 << 20
+	maxPDFDepth      = 8
+)
+
+type (
+	pdfName   string
+	pdfString string
+	pdfDict   map[pdfName]any
+	pdfRef    struct{ num, gen int64 }
+)
+
+type xrefEntry struct {
+	// kind is 0 for a free object, 1 for an object at offset and 2 for
+	// the index-th object of the object stream numbered stream
+	kind   byte
+	offset int64
+	stream int64
+	index  int64
+}
+
+type pdfFile struct {
+	r       io.ReaderAt
+	size    int64
+	xref    map[int64]xrefEntry
+	trailer pdfDict
+	depth   int
+}
+
+func readPDF(r io.ReaderAt, size int64) (*Metadata, error) {
+	f := &pdfFile{r: r, size: size, xref: make(map[int64]xrefEntry)}
+
+	err := f.readXref()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := f.trailer["Encrypt"]; ok {
+		return nil, ErrEncrypted
+	}
+
+	m := &Metadata{}
+
+	info, err := f.resolve(f.trailer["Info"])
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := info.(pdfDict); ok {
+		m.Title = pdfText(d["Title"])
+		m.Authors = splitAuthors(pdfText(d["Author"]))
+		m.Description = pdfText(d["Subject"])
+		m.ISBN = findISBN(m.Description + "\n" + pdfText(d["Keywords"]))
+	}
+
+	root, err := f.resolve(f.trailer["Root"])
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := root.(pdfDict); ok {
+		m.Language = pdfText(d["Lang"])
+	}
+
+	return m, nil
+}
+
+// read returns up to n bytes at off, fewer at the end of the file.
+func (f *pdfFile) read(off, n int64) ([]byte, error) {
+	if off < 0 || off >= f.size {
+		return nil, ErrMalformed
+	}
+	n = min(n, f.size-off)
+
+	b := make([]byte, n)
+	k, err := f.r.ReadAt(b, off)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return b[:k], nil
+}
+
+// readXref reads the cross-reference sections from the last one back.
+// Entries of later sections win, so an entry is only set once.
+func (f *pdfFile) readXref() error {
+	tailSize := min(f.size, 2048)
+	tail, err := f.read(f.size-tailSize, tailSize)
+	if err != nil {
+		return err
+	}
+
+	i := bytes.LastIndex(tail, []byte("startxref"))
+	if i < 0 {
+		return ErrMalformed
+	}
+	l := &pdfLexer{b: tail[i+len("startxref"):]}
+	off, ok := l.int()
+	if !ok {
+		return ErrMalformed
+	}
+
+	seen := make(map[int64]bool)
+	for !seen[off] {
+		seen[off] = true
+
+		trailer, err := f.readXrefSection(off)
+		if err != nil {
+			return err
+		}
+		if f.trailer == nil {
+			f.trailer = trailer
+		}
+
+		// hybrid files keep the compressed objects in an extra stream
+		if stm, ok := trailer["XRefStm"].(int64); ok && !seen[stm] {
+			seen[stm] = true
+			_, err = f.readXrefSection(stm)
+			if err != nil {
+				return err
+			}
+		}
+
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		off = prev
+	}
+
+	return nil
+}
+
+func (f *pdfFile) setXref(num int64, e xrefEntry) {
+	if _, ok := f.xref[num]; !ok {
+		f.xref[num] = e
+	}
+}
+
+// readXrefSection reads a cross-reference table or stream at off and
+// returns its trailer dictionary.
+func (f *pdfFile) readXrefSection(off int64) (pdfDict, error) {
+	b, err := f.read(off, maxPDFXrefSize)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &pdfLexer{b: b}
+	l.skipSpace()
+	if !l.keyword("xref") {
+		return f.readXrefStream(off)
+	}
+
+	for {
+		if l.keyword("trailer") {
+			v, err := l.value()
+			if err != nil {
+				return nil, err
+			}
+			d, ok := v.(pdfDict)
+			if !ok {
+				return nil, ErrMalformed
+			}
+			return d, nil
+		}
+
+		start, ok1 := l.int()
+		count, ok2 := l.int()
+		if !ok1 || !ok2 {
+			return nil, ErrMalformed
+		}
+
+		for i := int64(0); i < count; i++ {
+			offset, ok1 := l.int()
+			_, ok2 := l.int()
+			if !ok1 || !ok2 {
+				return nil, ErrMalformed
+			}
+
+			switch l.token() {
+			case "n":
+				f.setXref(start+i, xrefEntry{kind: 1, offset: offset})
+			case "f":
+				f.setXref(start+i, xrefEntry{kind: 0})
+			default:
+				return nil, ErrMalformed
+			}
+		}
+	}
+}
+
+func (f *pdfFile) readXrefStream(off int64) (pdfDict, error) {
+	d, data, err := f.stream(off)
+	if err != nil {
+		return nil, err
+	}
+
+	if d["Type"] != pdfName("XRef") {
+		return nil, ErrMalformed
+	}
+
+	w := pdfInts(d["W"])
+	if len(w) != 3 {
+		return nil, ErrMalformed
+	}
+	for _, n := range w {
+		if n < 0 || n > 8 {
+			return nil, ErrMalformed
+		}
+	}
+	rowSize := int(w[0] + w[1] + w[2])
+
+	index := pdfInts(d["Index"])
+	if index == nil {
+		size, _ := d["Size"].(int64)
+		index = []int64{0, size}
+	}
+	if len(index)%2 != 0 {
+		return nil, ErrMalformed
+	}
+
+	field := func(row []byte, from, n int64) int64 {
+		var v int64
+		for _, c := range row[from : from+n] {
+			v = v<<8 | int64(c)
+		}
+		return v
+	}
+
+	pos := 0
+	for i := 0; i < len(index); i += 2 {
+		start, count := index[i], index[i+1]
+		for j := int64(0); j < count; j++ {
+			if pos+rowSize > len(data) {
+				return nil, ErrMalformed
+			}
+			row := data[pos : pos+rowSize]
+			pos += rowSize
+
+			kind := int64(1)
+			if w[0] > 0 {
+				kind = field(row, 0, w[0])
+			}
+			a, b := field(row, w[0], w[1]), field(row, w[0]+w[1], w[2])
+
+			switch kind {
+			case 0:
+				f.setXref(start+j, xrefEntry{kind: 0})
+			case 1:
+				f.setXref(start+j, xrefEntry{kind: 1, offset: a})
+			case 2:
+				f.setXref(start+j, xrefEntry{kind: 2, stream: a, index: b})
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// resolve returns the object v refers to, or v when it is no reference.
+func (f *pdfFile) resolve(v any) (any, error) {
+	ref, ok := v.(pdfRef)
+	if !ok {
+		return v, nil
+	}
+
+	return f.object(ref.num)
+}
+
+// object returns the object numbered num, or nil when there is none.
+func (f *pdfFile) object(num int64) (any, error) {
+	if f.depth >= maxPDFDepth {
+		return nil, ErrMalformed
+	}
+	f.depth++
+	defer func() { f.depth-- }()
+
+	e := f.xref[num]
+	switch e.kind {
+	case 1:
+		b, err := f.read(e.offset, maxPDFObjectSize)
+		if err != nil {
+			return nil, err
+		}
+
+		l := &pdfLexer{b: b}
+		if !l.objectHeader() {
+			return nil, ErrMalformed
+		}
+		return l.value()
+	case 2:
+		stm := f.xref[e.stream]
+		if stm.kind != 1 {
+			return nil, ErrMalformed
+		}
+
+		d, data, err := f.stream(stm.offset)
+		if err != nil {
+			return nil, err
+		}
+
+		n, _ := d["N"].(int64)
+		firstOff, _ := d["First"].(int64)
+		if firstOff < 0 || firstOff > int64(len(data)) {
+			return nil, ErrMalformed
+		}
+
+		l := &pdfLexer{b: data[:firstOff]}
+		for i := int64(0); i < n; i++ {
+			objNum, ok1 := l.int()
+			objOff, ok2 := l.int()
+			if !ok1 || !ok2 {
+				return nil, ErrMalformed
+			}
+
+			if objNum == num {
+				if objOff < 0 || firstOff+objOff > int64(len(data)) {
+					return nil, ErrMalformed
+				}
+				return (&pdfLexer{b: data[firstOff+objOff:]}).value()
+			}
+		}
+		return nil, ErrMalformed
+	}
+
+	return nil, nil
+}
+
+// stream reads the stream object at off and returns its dictionary and
+// decoded data.
+func (f *pdfFile) stream(off int64) (pdfDict, []byte, error) {
+	b, err := f.read(off, maxPDFObjectSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l := &pdfLexer{b: b}
+	if !l.objectHeader() {
+		return nil, nil, ErrMalformed
+	}
+
+	v, err := l.value()
+	if err != nil {
+		return nil, nil, err
+	}
+	d, ok := v.(pdfDict)
+	if !ok {
+		return nil, nil, ErrMalformed
+	}
+
+	if !l.keyword("stream") {
+		return nil, nil, ErrMalformed
+	}
+	// the data starts after the end of the line that follows the keyword
+	start := l.pos
+	if start < len(b) && b[start] == '\r' {
+		start++
+	}
+	if start < len(b) && b[start] == '\n' {
+		start++
+	}
+
+	length, err := f.resolve(d["Length"])
+	if err != nil {
+		return nil, nil, err
+	}
+	n, ok := length.(int64)
+	if !ok || n < 0 || n > maxPDFStreamSize {
+		return nil, nil, ErrMalformed
+	}
+
+	raw, err := f.read(off+int64(start), n)
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(raw)) != n {
+		return nil, nil, ErrMalformed
+	}
+
+	data, err := pdfDecode(d, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return d, data, nil
+}
+
+// pdfDecode undoes the filters of a stream. Object and cross-reference
+// streams only use Flate, so that is all it knows.
+func pdfDecode(d pdfDict, data []byte) ([]byte, error) {
+	var filters []any
+	switch v := d["Filter"].(type) {
+	case nil:
+		return data, nil
+	case pdfName:
+		filters = []any{v}
+	case []any:
+		filters = v
+	}
+	if len(filters) != 1 || filters[0] != pdfName("FlateDecode") {
+		return nil, ErrUnsupported
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	defer zr.Close()
+
+	data, err = io.ReadAll(io.LimitReader(zr, maxPDFStreamSize))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	parms, _ := d["DecodeParms"].(pdfDict)
+	if arr, ok := d["DecodeParms"].([]any); ok && len(arr) == 1 {
+		parms, _ = arr[0].(pdfDict)
+	}
+	predictor, _ := parms["Predictor"].(int64)
+	if predictor < 10 {
+		return data, nil
+	}
+
+	columns, ok := parms["Columns"].(int64)
+	if !ok {
+		columns = 1
+	}
+	colors, ok := parms["Colors"].(int64)
+	if !ok {
+		colors = 1
+	}
+	bits, ok := parms["BitsPerComponent"].(int64)
+	if !ok {
+		bits = 8
+	}
+
+	// bounded so that the row size cannot overflow
+	if columns < 1 || columns > maxPDFStreamSize || colors < 1 || colors > 32 {
+		return nil, ErrMalformed
+	}
+	switch bits {
+	case 1, 2, 4, 8, 16:
+	default:
+		return nil, ErrMalformed
+	}
+
+	return unpredictPNG(data, int(columns*colors*bits+7)/8, int(max(colors*bits/8, 1)))
+}
+
+// unpredictPNG undoes the PNG row filters, where each row starts with
+// the number of its filter.
+func unpredictPNG(data []byte, rowSize, bpp int) ([]byte, error) {
+	if rowSize <= 0 || rowSize >= len(data) || len(data)%(rowSize+1) != 0 {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data)/(rowSize+1)*rowSize)
+	prev := make([]byte, rowSize)
+	for len(data) > 0 {
+		filter, row := data[0], data[1:rowSize+1]
+		data = data[rowSize+1:]
+
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+
+			switch filter {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			default:
+				return nil, ErrMalformed
+			}
+		}
+
+		out = append(out, row...)
+		prev = row
+	}
+
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func pdfInts(v any) []int64 {
+	arr, ok := v.([]any)
+	if !ok {
+		return nil
+	}
+
+	ints := make([]int64, 0, len(arr))
+	for _, e := range arr {
+		n, ok := e.(int64)
+		if !ok {
+			return nil
+		}
+		ints = append(ints, n)
+	}
+
+	return ints
+}
+
+// pdfText decodes a text string, which is UTF-16BE or UTF-8 after a byte
+// order mark and PDFDocEncoding otherwise. PDFDocEncoding is read as
+// Latin-1, which it matches for the letters that matter.
+func pdfText(v any) string {
+	s, ok := v.(pdfString)
+	if !ok {
+		return ""
+	}
+	b := []byte(s)
+
+	var text string
+	switch {
+	case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		u := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		text = string(utf16.Decode(u))
+	case bytes.HasPrefix(b, []byte{0xef, 0xbb, 0xbf}):
+		text = string(b[3:])
+	default:
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		text = string(r)
+	}
+
+	return strings.TrimSpace(text)
+}
+
+// pdfLexer parses PDF objects from b.
+type pdfLexer struct {
+	b   []byte
+	pos int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.b) && l.b[l.pos] != '\r' && l.b[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// token returns the next run of regular characters.
+func (l *pdfLexer) token() string {
+	l.skipSpace()
+	start := l.pos
+	for l.pos < len(l.b) && !isPDFSpace(l.b[l.pos]) && !isPDFDelim(l.b[l.pos]) {
+		l.pos++
+	}
+
+	return string(l.b[start:l.pos])
+}
+
+// keyword consumes the token kw if it comes next.
+func (l *pdfLexer) keyword(kw string) bool {
+	save := l.pos
+	if l.token() == kw {
+		return true
+	}
+	l.pos = save
+
+	return false
+}
+
+func (l *pdfLexer) int() (int64, bool) {
+	save := l.pos
+	n, err := strconv.ParseInt(l.token(), 10, 64)
+	if err != nil {
+		l.pos = save
+		return 0, false
+	}
+
+	return n, true
+}
+
+// objectHeader consumes "num gen obj".
+func (l *pdfLexer) objectHeader() bool {
+	_, ok1 := l.int()
+	_, ok2 := l.int()
+
+	return ok1 && ok2 && l.keyword("obj")
+}
+
+func (l *pdfLexer) value() (any, error) {
+	l.skipSpace()
+	if l.pos >= len(l.b) {
+		return nil, ErrMalformed
+	}
+
+	switch c := l.b[l.pos]; {
+	case c == '/':
+		l.pos++
+		return pdfName(l.name()), nil
+	case c == '(':
+		return l.literal()
+	case c == '<' && l.pos+1 < len(l.b) && l.b[l.pos+1] == '<':
+		return l.dict()
+	case c == '<':
+		return l.hex()
+	case c == '[':
+		return l.array()
+	}
+
+	tok := l.token()
+	switch tok {
+	case "":
+		return nil, ErrMalformed
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+
+	n, err := strconv.ParseInt(tok, 10, 64)
+	if err != nil {
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		return f, nil
+	}
+
+	// an integer may start a reference "num gen R"
+	save := l.pos
+	if gen, ok := l.int(); ok && l.keyword("R") {
+		return pdfRef{num: n, gen: gen}, nil
+	}
+	l.pos = save
+
+	return n, nil
+}
+
+func (l *pdfLexer) name() string {
+	var b []byte
+	for l.pos < len(l.b) && !isPDFSpace(l.b[l.pos]) && !isPDFDelim(l.b[l.pos]) {
+		c := l.b[l.pos]
+		if c == '#' && l.pos+2 < len(l.b) {
+			if v, err := strconv.ParseUint(string(l.b[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		l.pos++
+	}
+
+	return string(b)
+}
+
+func (l *pdfLexer) literal() (any, error) {
+	l.pos++
+
+	var b []byte
+	depth := 0
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		l.pos++
+
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return pdfString(b), nil
+			}
+			depth--
+		case '\\':
+			if l.pos >= len(l.b) {
+				return nil, ErrMalformed
+			}
+			c = l.b[l.pos]
+			l.pos++
+
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// a backslash at the end of a line continues the string
+				if l.pos < len(l.b) && l.b[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.b) && l.b[l.pos] >= '0' && l.b[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.b[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				}
+			}
+		}
+
+		b = append(b, c)
+	}
+
+	return nil, ErrMalformed
+}
+
+func (l *pdfLexer) hex() (any, error) {
+	l.pos++
+
+	var digits []byte
+	for l.pos < len(l.b) {
+		c := l.b[l.pos]
+		l.pos++
+
+		switch {
+		case c == '>':
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			b := make([]byte, len(digits)/2)
+			for i := range b {
+				v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+				b[i] = byte(v)
+			}
+			return pdfString(b), nil
+		case isPDFSpace(c):
+		case strings.IndexByte("0123456789abcdefABCDEF", c) >= 0:
+			digits = append(digits, c)
+		default:
+			return nil, ErrMalformed
+		}
+	}
+
+	return nil, ErrMalformed
+}
+
+func (l *pdfLexer) dict() (any, error) {
+	l.pos += 2
+
+	d := make(pdfDict)
+	for {
+		l.skipSpace()
+		if l.pos+1 < len(l.b) && l.b[l.pos] == '>' && l.b[l.pos+1] == '>' {
+			l.pos += 2
+			return d, nil
+		}
+
+		key, err := l.value()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return nil, ErrMalformed
+		}
+
+		v, err := l.value()
+		if err != nil {
+			return nil, err
+		}
+		d[name] = v
+	}
+}
+
+func (l *pdfLexer) array() (any, error) {
+	l.pos++
+
+	arr := []any{}
+	for {
+		l.skipSpace()
+		if l.pos < len(l.b) && l.b[l.pos] == ']' {
+			l.pos++
+			return arr, nil
+		}
+
+		v, err := l.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+}
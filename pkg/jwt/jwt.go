@@ -0,0 +1,221 @@
+// Package jwt signs and verifies RS256 JSON Web Tokens and reads the JSON
+// Web Key Sets that publish their public keys.
+package jwt
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+const AlgRS256 = "RS256"
+
+// Leeway is the clock difference allowed when checking exp.
+const Leeway = time.Minute
+
+var (
+	ErrMalformed     = errors.New("malformed token")
+	ErrAlgorithm     = errors.New("unsupported token algorithm")
+	ErrUnknownKey    = errors.New("unknown token key")
+	ErrBadSignature  = errors.New("bad token signature")
+	ErrExpired       = errors.New("token is expired")
+	ErrWrongIssuer   = errors.New("token has the wrong issuer")
+	ErrWrongAudience = errors.New("token has the wrong audience")
+)
+
+var encoding = base64.RawURLEncoding
+
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Audience is a list of audiences that is also read from a single string,
+// as the spec allows either.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = Audience{one}
+		return nil
+	}
+
+	var many []string
+	err := json.Unmarshal(data, &many)
+	if err != nil {
+		return err
+	}
+
+	*a = many
+	return nil
+}
+
+// Claims are the registered claims. Embed it to add others.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Validate checks the issuer, that audience is among the audiences and that
+// the token has not expired at now.
+func (c Claims) Validate(issuer, audience string, now time.Time) error {
+	if c.Issuer != issuer {
+		return ErrWrongIssuer
+	}
+
+	if !slices.Contains(c.Audience, audience) {
+		return ErrWrongAudience
+	}
+
+	if c.ExpiresAt == 0 || now.Add(-Leeway).Unix() >= c.ExpiresAt {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// Sign returns claims as a token signed with key and named kid.
+func Sign(claims any, kid string, key *rsa.PrivateKey) (string, error) {
+	header, err := json.Marshal(Header{Alg: AlgRS256, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + encoding.EncodeToString(sig), nil
+}
+
+// Parse verifies the signature of token with the key that keys returns for
+// its kid and decodes the payload into claims. It does not validate the
+// claims.
+func Parse(token string, keys func(kid string) (*rsa.PublicKey, error), claims any) (*Header, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	data, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var header Header
+	err = json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if header.Alg != AlgRS256 {
+		return nil, ErrAlgorithm
+	}
+
+	key, err := keys(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return &header, nil
+}
+
+// JWK is an RSA public key in JSON Web Key form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type KeySet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewJWK(kid string, key *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: AlgRS256,
+		Kid: kid,
+		N:   encoding.EncodeToString(key.N.Bytes()),
+		E:   encoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func (k JWK) PublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, ErrAlgorithm
+	}
+
+	n, err := encoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := encoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// Key returns the public key named kid.
+func (s KeySet) Key(kid string) (*rsa.PublicKey, error) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k.PublicKey()
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
@@ -0,0 +1,86 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignParse(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keys := KeySet{Keys: []JWK{NewJWK("k1", &key.PublicKey), NewJWK("k2", &other.PublicKey)}}
+
+	claims := Claims{Issuer: "https://issuer", Subject: "42", Audience: Audience{"bookstore"}, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	token, err := Sign(claims, "k1", key)
+	require.NoError(t, err)
+
+	wrongKey, err := Sign(claims, "k2", key)
+	require.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + encoding.EncodeToString([]byte(`{"sub":"1"}`)) + "." + parts[2]
+
+	tests := map[string]struct {
+		token string
+		err   error
+	}{
+		"case 01: success": {
+			token: token,
+			err:   nil,
+		},
+		"case 02: signed with another key": {
+			token: wrongKey,
+			err:   ErrBadSignature,
+		},
+		"case 03: tampered payload": {
+			token: tampered,
+			err:   ErrBadSignature,
+		},
+		"case 04: malformed": {
+			token: "a.b",
+			err:   ErrMalformed,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got Claims
+			_, err := Parse(tt.token, keys.Key, &got)
+			require.Equal(t, tt.err, err)
+			if tt.err == nil {
+				require.Equal(t, claims, got)
+			}
+		})
+	}
+
+	_, err = Parse(token, KeySet{}.Key, &Claims{})
+	require.Equal(t, ErrUnknownKey, err)
+}
+
+func TestClaims_Validate(t *testing.T) {
+	now := time.Now()
+	claims := Claims{Issuer: "https://issuer", Audience: Audience{"a", "bookstore"}, ExpiresAt: now.Add(time.Minute).Unix()}
+
+	require.NoError(t, claims.Validate("https://issuer", "bookstore", now))
+	require.Equal(t, ErrWrongIssuer, claims.Validate("https://other", "bookstore", now))
+	require.Equal(t, ErrWrongAudience, claims.Validate("https://issuer", "other", now))
+	require.Equal(t, ErrExpired, claims.Validate("https://issuer", "bookstore", now.Add(time.Hour)))
+}
+
+func TestAudience_UnmarshalJSON(t *testing.T) {
+	var c Claims
+	require.NoError(t, json.Unmarshal([]byte(`{"aud":"bookstore"}`), &c))
+	require.Equal(t, Audience{"bookstore"}, c.Audience)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"aud":["a","b"]}`), &c))
+	require.Equal(t, Audience{"a", "b"}, c.Audience)
+}
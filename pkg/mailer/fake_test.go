@@ -0,0 +1,22 @@
+package mailer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake_Send(t *testing.T) {
+	var f Fake
+	msg := Message{To: "foo@example.com", Subject: "Hello", Body: "Hi"}
+
+	err := f.Send(context.Background(), msg)
+	require.NoError(t, err)
+	require.Equal(t, []Message{msg}, f.Sent())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, f.Send(ctx, msg))
+	require.Len(t, f.Sent(), 1)
+}
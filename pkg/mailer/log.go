@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Log does not deliver anything. It writes each message to a file in dir, or
+// to the log when dir is empty, for local runs.
+type Log struct {
+	mu   sync.Mutex
+	from string
+	dir  string
+	n    int
+}
+
+func NewLog(from, dir string) *Log {
+	return &Log{
+		from: from,
+		dir:  dir,
+	}
+}
+
+func (l *Log) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.n++
+
+	if l.dir == "" {
+		log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	err := os.MkdirAll(l.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), l.n)
+	return os.WriteFile(filepath.Join(l.dir, name), format(l.from, msg), 0o600)
+}
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog_Send(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLog("shop@example.com", dir)
+
+	msg := Message{
+		To:      "foo@example.com\r\nBcc: bar@example.com",
+		Subject: "Hello",
+		Body:    "line 1\nline 2",
+	}
+
+	err := l.Send(context.Background(), msg)
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+
+	text := string(data)
+	require.Contains(t, text, "From: shop@example.com\r\n")
+	require.Contains(t, text, "To: foo@example.comBcc: bar@example.com\r\n")
+	require.Contains(t, text, "Subject: Hello\r\n")
+	require.True(t, strings.HasSuffix(text, "\r\n\r\nline 1\r\nline 2"))
+}
+
+func TestNewMailer(t *testing.T) {
+	tests := map[string]struct {
+		provider string
+		err      bool
+	}{
+		"case 01: default": {provider: "", err: false},
+		"case 02: log":     {provider: "log", err: false},
+		"case 03: smtp":    {provider: "smtp", err: false},
+		"case 04: unknown": {provider: "pigeon", err: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewMailer(Config{Provider: tt.provider})
+			require.Equal(t, tt.err, err != nil)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+)
+
+type Config struct {
+	Provider string `yaml:"provider"`
+	From     string `yaml:"from"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// Dir is where the log mailer writes messages; they go to the log when
+	// it is empty.
+	Dir string `yaml:"dir"`
+}
+
+// IMailer sends plain text emails.
+type IMailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+func NewMailer(cfg Config) (IMailer, error) {
+	switch cfg.Provider {
+	case "", "log":
+		return NewLog(cfg.From, cfg.Dir), nil
+	case "smtp":
+		return NewSMTP(cfg), nil
+	}
+
+	return nil, fmt.Errorf("unknown mailer %q", cfg.Provider)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/url"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -11,15 +13,50 @@ import (
 
 type Client struct {
 	client     *minio.Client
+	core       *minio.Core
 	bucketName string
 }
 
+// Upload is a multipart upload that was started and not completed.
+type Upload struct {
+	Filename  string
+	UploadID  string
+	Initiated time.Time
+}
+
 type IClient interface {
 	GetFile(ctx context.Context, filename string) (*minio.Object, error)
 	PutFile(ctx context.Context, filename string, reader io.Reader) error
 	DeleteFile(ctx context.Context, filename string) error
+	StatFile(ctx context.Context, filename string) (minio.ObjectInfo, error)
+	CopyFile(ctx context.Context, src, dst string) error
+	// PresignGet returns a URL that downloads the object until expiry.
+	// params may override response headers, such as
+	// response-content-disposition.
+	PresignGet(ctx context.Context, filename string, expiry time.Duration, params url.Values) (string, error)
+	// PresignPut returns a URL that uploads the object until expiry.
+	PresignPut(ctx context.Context, filename string, expiry time.Duration) (string, error)
+	// ListFiles returns the objects whose names start with prefix.
+	ListFiles(ctx context.Context, prefix string) ([]minio.ObjectInfo, error)
+
+	// Multipart uploads put a large object together from parts that are
+	// sent one by one. Every part but the last has to be at least
+	// MinPartSize.
+	CreateMultipartUpload(ctx context.Context, filename string) (string, error)
+	PutPart(ctx context.Context, filename, uploadId string, part int, reader io.Reader, size int64) error
+	CompleteMultipartUpload(ctx context.Context, filename, uploadId string) error
+	AbortMultipartUpload(ctx context.Context, filename, uploadId string) error
+	// ListMultipartUploads returns the uploads under prefix that were not
+	// completed or aborted.
+	ListMultipartUploads(ctx context.Context, prefix string) ([]Upload, error)
 }
 
+const (
+	MinPartSize = 5 << 20
+	MaxPartSize = 5 << 30
+	MaxParts    = 10000
+)
+
 type Config struct {
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
@@ -48,6 +85,7 @@ func NewClient(cfg Config) (IClient, error) {
 
 	return &Client{
 		client:     client,
+		core:       &minio.Core{Client: client},
 		bucketName: cfg.Bucket,
 	}, nil
 }
@@ -70,3 +108,100 @@ func (m *Client) PutFile(ctx context.Context, filename string, reader io.Reader)
 func (m *Client) DeleteFile(ctx context.Context, filename string) error {
 	return m.client.RemoveObject(ctx, m.bucketName, filename, minio.RemoveObjectOptions{})
 }
+
+func (m *Client) StatFile(ctx context.Context, filename string) (minio.ObjectInfo, error) {
+	return m.client.StatObject(ctx, m.bucketName, filename, minio.StatObjectOptions{})
+}
+
+func (m *Client) CopyFile(ctx context.Context, src, dst string) error {
+	_, err := m.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: m.bucketName, Object: dst},
+		minio.CopySrcOptions{Bucket: m.bucketName, Object: src})
+	return err
+}
+
+func (m *Client) PresignGet(ctx context.Context, filename string, expiry time.Duration, params url.Values) (string, error) {
+	u, err := m.client.PresignedGetObject(ctx, m.bucketName, filename, expiry, params)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+func (m *Client) PresignPut(ctx context.Context, filename string, expiry time.Duration) (string, error) {
+	u, err := m.client.PresignedPutObject(ctx, m.bucketName, filename, expiry)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+func (m *Client) ListFiles(ctx context.Context, prefix string) ([]minio.ObjectInfo, error) {
+	var res []minio.ObjectInfo
+	for obj := range m.client.ListObjects(ctx, m.bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+
+		res = append(res, obj)
+	}
+
+	return res, nil
+}
+
+func (m *Client) CreateMultipartUpload(ctx context.Context, filename string) (string, error) {
+	return m.core.NewMultipartUpload(ctx, m.bucketName, filename, minio.PutObjectOptions{
+		ContentType: "application/octet-stream"})
+}
+
+func (m *Client) PutPart(ctx context.Context, filename, uploadId string, part int, reader io.Reader, size int64) error {
+	_, err := m.core.PutObjectPart(ctx, m.bucketName, filename, uploadId, part, reader, size, minio.PutObjectPartOptions{})
+	return err
+}
+
+// CompleteMultipartUpload joins the parts that were put, in order.
+func (m *Client) CompleteMultipartUpload(ctx context.Context, filename, uploadId string) error {
+	var parts []minio.CompletePart
+	marker := 0
+	for {
+		res, err := m.core.ListObjectParts(ctx, m.bucketName, filename, uploadId, marker, 1000)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range res.ObjectParts {
+			parts = append(parts, minio.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag})
+		}
+
+		if !res.IsTruncated {
+			break
+		}
+		marker = res.NextPartNumberMarker
+	}
+
+	_, err := m.core.CompleteMultipartUpload(ctx, m.bucketName, filename, uploadId, parts, minio.PutObjectOptions{})
+	return err
+}
+
+func (m *Client) AbortMultipartUpload(ctx context.Context, filename, uploadId string) error {
+	return m.core.AbortMultipartUpload(ctx, m.bucketName, filename, uploadId)
+}
+
+func (m *Client) ListMultipartUploads(ctx context.Context, prefix string) ([]Upload, error) {
+	var res []Upload
+	for u := range m.client.ListIncompleteUploads(ctx, m.bucketName, prefix, true) {
+		if u.Err != nil {
+			return nil, u.Err
+		}
+
+		res = append(res, Upload{
+			Filename:  u.Key,
+			UploadID:  u.UploadID,
+			Initiated: u.Initiated,
+		})
+	}
+
+	return res, nil
+}
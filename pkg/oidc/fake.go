@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sabirov8872/bookstore/pkg/jwt"
+)
+
+const fakeKeyID = "fake"
+
+// Fake is a stand-in OpenID provider for tests and local runs. Serve it
+// with net/http and use its URL as the issuer. It signs in User without
+// asking, but checks the client and PKCE like a real provider.
+type Fake struct {
+	ClientID     string
+	ClientSecret string
+	User         Identity
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	codes map[string]fakeCode
+}
+
+type fakeCode struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+func NewFake(clientID, clientSecret string, user Identity) (*Fake, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Fake{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		User:         user,
+		key:          key,
+		codes:        make(map[string]fakeCode),
+	}, nil
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	issuer := "http://" + r.Host
+
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, discovery{
+			Issuer:                issuer,
+			AuthorizationEndpoint: issuer + "/authorize",
+			TokenEndpoint:         issuer + "/token",
+			JwksURI:               issuer + "/jwks",
+		})
+	case "/jwks":
+		writeJSON(w, jwt.KeySet{Keys: []jwt.JWK{jwt.NewJWK(fakeKeyID, &f.key.PublicKey)}})
+	case "/authorize":
+		f.authorize(w, r)
+	case "/token":
+		f.token(w, r, issuer)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *Fake) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != f.ClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	f.mu.Lock()
+	f.codes[code] = fakeCode{
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	f.mu.Unlock()
+
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (f *Fake) token(w http.ResponseWriter, r *http.Request, issuer string) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != f.ClientID || secret != f.ClientSecret {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+
+	f.mu.Lock()
+	c, ok := f.codes[r.PostFormValue("code")]
+	delete(f.codes, r.PostFormValue("code"))
+	f.mu.Unlock()
+
+	if !ok || c.redirectURI != r.PostFormValue("redirect_uri") || c.challenge != Challenge(r.PostFormValue("code_verifier")) {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	idToken, err := jwt.Sign(idTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    issuer,
+			Subject:   f.User.Subject,
+			Audience:  jwt.Audience{f.ClientID},
+			ExpiresAt: now.Add(5 * time.Minute).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		Nonce:             c.nonce,
+		Email:             f.User.Email,
+		EmailVerified:     f.User.EmailVerified,
+		Name:              f.User.Name,
+		PreferredUsername: f.User.Username,
+	}, fakeKeyID, f.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]string{
+		"access_token": "fake",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
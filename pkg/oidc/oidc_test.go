@@ -0,0 +1,96 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider_Exchange(t *testing.T) {
+	user := Identity{Subject: "staff-1", Email: "jane@example.com", EmailVerified: true, Name: "Jane", Username: "jane"}
+	fake, err := NewFake("bookstore", "secret", user)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	p := NewProvider(Config{
+		Issuer:       srv.URL,
+		ClientID:     "bookstore",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/login/oidc/callback",
+	})
+
+	// login follows the redirect of the provider and returns the code
+	login := func(t *testing.T, verifier, nonce string) string {
+		authURL, err := p.AuthCodeURL(context.Background(), "state-1", nonce, Challenge(verifier))
+		require.NoError(t, err)
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(authURL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+
+		location, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "state-1", location.Query().Get("state"))
+
+		return location.Query().Get("code")
+	}
+
+	verifier, err := NewVerifier()
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		verifier string
+		nonce    string
+		err      bool
+	}{
+		"case 01: success": {
+			verifier: verifier,
+			nonce:    "nonce-1",
+			err:      false,
+		},
+		"case 02: wrong verifier": {
+			verifier: "other",
+			nonce:    "nonce-1",
+			err:      true,
+		},
+		"case 03: wrong nonce": {
+			verifier: verifier,
+			nonce:    "nonce-2",
+			err:      true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			code := login(t, verifier, "nonce-1")
+
+			identity, err := p.Exchange(context.Background(), code, tt.verifier, tt.nonce)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			want := user
+			want.Issuer = srv.URL
+			require.Equal(t, &want, identity)
+
+			_, err = p.Exchange(context.Background(), code, tt.verifier, tt.nonce)
+			require.Error(t, err, "a code works once")
+		})
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	require.Nil(t, NewProvider(Config{}))
+}
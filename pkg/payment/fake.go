@@ -0,0 +1,168 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+const (
+	// FakeDeclinedAmount is the one amount the fake provider refuses to
+	// authorize, so declines can be tried out.
+	FakeDeclinedAmount = 13
+
+	FakeSignatureHeader = "X-Fake-Signature"
+)
+
+// Fake is an in-memory provider for tests and local runs. Payment ids are
+// derived from the idempotency key and webhooks are signed with HMAC-SHA256,
+// so the same calls always give the same results.
+type Fake struct {
+	mu       sync.Mutex
+	secret   string
+	payments map[string]*Payment
+}
+
+func NewFake(secret string) *Fake {
+	return &Fake{
+		secret:   secret,
+		payments: make(map[string]*Payment),
+	}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) Authorize(ctx context.Context, req AuthorizeRequest) (*Payment, error) {
+	if req.Amount <= 0 {
+		return nil, ErrBadAmount
+	}
+
+	if req.Amount == FakeDeclinedAmount {
+		return nil, ErrDeclined
+	}
+
+	sum := sha256.Sum256([]byte(req.IdempotencyKey))
+	id := "fake_" + hex.EncodeToString(sum[:12])
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[id]
+	if !ok {
+		p = &Payment{
+			ID:       id,
+			Status:   StatusAuthorized,
+			Amount:   req.Amount,
+			Currency: req.Currency,
+		}
+		f.payments[id] = p
+	}
+
+	res := *p
+	return &res, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, paymentId string, amount int64) (*Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if amount <= 0 || amount > p.Amount {
+		return nil, ErrBadAmount
+	}
+
+	switch p.Status {
+	case StatusAuthorized:
+		p.Status = StatusCaptured
+		p.Amount = amount
+	case StatusCaptured:
+	default:
+		return nil, ErrDeclined
+	}
+
+	res := *p
+	return &res, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, paymentId string, amount int64) (*Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if p.Status != StatusCaptured {
+		return nil, ErrDeclined
+	}
+
+	if amount <= 0 || p.Refunded+amount > p.Amount {
+		return nil, ErrBadAmount
+	}
+
+	p.Refunded += amount
+	if p.Refunded == p.Amount {
+		p.Status = StatusRefunded
+	}
+
+	res := *p
+	return &res, nil
+}
+
+func (f *Fake) Void(ctx context.Context, paymentId string) (*Payment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[paymentId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	switch p.Status {
+	case StatusAuthorized:
+		p.Status = StatusVoided
+	case StatusVoided:
+	default:
+		return nil, ErrDeclined
+	}
+
+	res := *p
+	return &res, nil
+}
+
+func (f *Fake) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	signature, err := hex.DecodeString(header.Get(FakeSignatureHeader))
+	if err != nil || !hmac.Equal(signature, f.sign(payload)) {
+		return nil, ErrBadSignature
+	}
+
+	var event Event
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// Sign returns the FakeSignatureHeader value for a webhook payload.
+func (f *Fake) Sign(payload []byte) string {
+	return hex.EncodeToString(f.sign(payload))
+}
+
+func (f *Fake) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(f.secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
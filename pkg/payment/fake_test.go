@@ -0,0 +1,129 @@
+package payment
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake_Authorize(t *testing.T) {
+	f := NewFake("secret")
+
+	tests := map[string]struct {
+		req AuthorizeRequest
+		err error
+	}{
+		"case 01: success": {
+			req: AuthorizeRequest{OrderId: 1, Amount: 1000, Currency: "USD", IdempotencyKey: "order-1"},
+			err: nil,
+		},
+		"case 02: declined": {
+			req: AuthorizeRequest{OrderId: 2, Amount: FakeDeclinedAmount, Currency: "USD", IdempotencyKey: "order-2"},
+			err: ErrDeclined,
+		},
+		"case 03: bad amount": {
+			req: AuthorizeRequest{OrderId: 3, Amount: 0, Currency: "USD", IdempotencyKey: "order-3"},
+			err: ErrBadAmount,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := f.Authorize(context.Background(), tt.req)
+			require.Equal(t, tt.err, err)
+		})
+	}
+}
+
+func TestFake_CaptureRefund(t *testing.T) {
+	f := NewFake("secret")
+	ctx := context.Background()
+
+	req := AuthorizeRequest{OrderId: 1, Amount: 1000, Currency: "USD", IdempotencyKey: "order-1"}
+	first, err := f.Authorize(ctx, req)
+	require.NoError(t, err)
+
+	again, err := f.Authorize(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, again.ID)
+
+	p, err := f.Capture(ctx, first.ID, 1000)
+	require.NoError(t, err)
+	require.Equal(t, StatusCaptured, p.Status)
+
+	_, err = f.Refund(ctx, first.ID, 2000)
+	require.Equal(t, ErrBadAmount, err)
+
+	p, err = f.Refund(ctx, first.ID, 1000)
+	require.NoError(t, err)
+	require.Equal(t, StatusRefunded, p.Status)
+
+	_, err = f.Capture(ctx, "missing", 1000)
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestFake_Void(t *testing.T) {
+	f := NewFake("secret")
+	ctx := context.Background()
+
+	voided, err := f.Authorize(ctx, AuthorizeRequest{OrderId: 1, Amount: 1000, Currency: "USD", IdempotencyKey: "order-1"})
+	require.NoError(t, err)
+
+	captured, err := f.Authorize(ctx, AuthorizeRequest{OrderId: 2, Amount: 1000, Currency: "USD", IdempotencyKey: "order-2"})
+	require.NoError(t, err)
+
+	_, err = f.Capture(ctx, captured.ID, 1000)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		id     string
+		status string
+		err    error
+	}{
+		"case 01: authorized": {
+			id:     voided.ID,
+			status: StatusVoided,
+			err:    nil,
+		},
+		"case 02: already captured": {
+			id:  captured.ID,
+			err: ErrDeclined,
+		},
+		"case 03: not found": {
+			id:  "missing",
+			err: ErrNotFound,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p, err := f.Void(ctx, tt.id)
+			require.Equal(t, tt.err, err)
+			if tt.err == nil {
+				require.Equal(t, tt.status, p.Status)
+			}
+		})
+	}
+
+	_, err = f.Capture(ctx, voided.ID, 1000)
+	require.Equal(t, ErrDeclined, err)
+}
+
+func TestFake_VerifyWebhook(t *testing.T) {
+	f := NewFake("secret")
+	payload := []byte(`{"id":"evt_1","type":"payment.captured","paymentId":"fake_1","orderId":1,"amount":1000}`)
+
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, f.Sign(payload))
+
+	event, err := f.VerifyWebhook(payload, header)
+	require.NoError(t, err)
+	require.Equal(t, &Event{ID: "evt_1", Type: EventCaptured, PaymentId: "fake_1", OrderId: 1, Amount: 1000}, event)
+
+	header.Set(FakeSignatureHeader, NewFake("other").Sign(payload))
+
+	_, err = f.VerifyWebhook(payload, header)
+	require.Equal(t, ErrBadSignature, err)
+}
@@ -0,0 +1,79 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusRefunded   = "refunded"
+	StatusVoided     = "voided"
+	StatusFailed     = "failed"
+
+	EventCaptured = "payment.captured"
+	EventFailed   = "payment.failed"
+	EventRefunded = "payment.refunded"
+)
+
+var (
+	ErrDeclined     = errors.New("payment declined")
+	ErrNotFound     = errors.New("payment not found")
+	ErrBadAmount    = errors.New("bad payment amount")
+	ErrBadSignature = errors.New("bad webhook signature")
+)
+
+type Config struct {
+	Provider      string `yaml:"provider"`
+	WebhookSecret string `yaml:"webhookSecret"`
+}
+
+// IProvider is implemented by every payment processor. Amounts are in minor
+// units of the currency.
+type IProvider interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Payment, error)
+	Capture(ctx context.Context, paymentId string, amount int64) (*Payment, error)
+	Refund(ctx context.Context, paymentId string, amount int64) (*Payment, error)
+	// Void lets go of an authorization that was not captured.
+	Void(ctx context.Context, paymentId string) (*Payment, error)
+	VerifyWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+type AuthorizeRequest struct {
+	OrderId  int
+	Amount   int64
+	Currency string
+	// IdempotencyKey makes retried authorizations return the first payment.
+	IdempotencyKey string
+}
+
+type Payment struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Amount   int64  `json:"amount"`
+	Refunded int64  `json:"refunded"`
+	Currency string `json:"currency"`
+}
+
+// Event is a verified webhook notification. Providers may deliver the same
+// event more than once.
+type Event struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	PaymentId string `json:"paymentId"`
+	OrderId   int    `json:"orderId"`
+	Amount    int64  `json:"amount"`
+}
+
+func NewProvider(cfg Config) (IProvider, error) {
+	switch cfg.Provider {
+	case "", "fake":
+		return NewFake(cfg.WebhookSecret), nil
+	}
+
+	return nil, fmt.Errorf("unknown payment provider %q", cfg.Provider)
+}
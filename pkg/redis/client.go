@@ -15,7 +15,9 @@ type Config struct {
 type IClient interface {
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
 	Get(ctx context.Context, key string) (string, error)
+	Incr(ctx context.Context, key string, expiration time.Duration) (int64, error)
 	Del(ctx context.Context, keys []string) error
+	DelByPattern(ctx context.Context, pattern string) error
 }
 
 type Client struct {
@@ -47,6 +49,24 @@ func (c *Client) Get(ctx context.Context, key string) (string, error) {
 	return c.client.Get(ctx, key).Result()
 }
 
+// Incr increments a counter. The expiration starts when the counter is
+// created and is not extended by later increments.
+func (c *Client) Incr(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	n, err := c.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if n == 1 {
+		err = c.client.Expire(ctx, key, expiration).Err()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
+
 func (c *Client) Del(ctx context.Context, keys []string) error {
 	for _, key := range keys {
 		if err := c.client.Del(ctx, key).Err(); err != nil {
@@ -56,3 +76,14 @@ func (c *Client) Del(ctx context.Context, keys []string) error {
 
 	return nil
 }
+
+func (c *Client) DelByPattern(ctx context.Context, pattern string) error {
+	iter := c.client.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		if err := c.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+
+	return iter.Err()
+}
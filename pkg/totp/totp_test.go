@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	tests := map[string]struct {
+		unix int64
+		code string
+	}{
+		"case 01": {unix: 59, code: "287082"},
+		"case 02": {unix: 1111111109, code: "081804"},
+		"case 03": {unix: 1111111111, code: "050471"},
+		"case 04": {unix: 1234567890, code: "005924"},
+		"case 05": {unix: 2000000000, code: "279037"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			require.NoError(t, err)
+			require.Equal(t, tt.code, code)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	tests := map[string]struct {
+		code string
+		at   time.Time
+		ok   bool
+	}{
+		"case 01: current step":   {code: "081804", at: now, ok: true},
+		"case 02: one step late":  {code: "081804", at: now.Add(Period), ok: true},
+		"case 03: two steps late": {code: "081804", at: now.Add(2 * Period), ok: false},
+		"case 04: wrong code":     {code: "123456", at: now, ok: false},
+		"case 05: wrong length":   {code: "81804", at: now, ok: false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, tt.at)
+			require.Equal(t, tt.ok, ok)
+			if ok {
+				require.Equal(t, Step(now), step)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	require.Len(t, secret, 32)
+
+	uri := URI("Bookstore", "foo@example.com", secret)
+	require.True(t, strings.HasPrefix(uri, "otpauth://totp/Bookstore:foo@example.com?"))
+	require.Contains(t, uri, "secret="+secret)
+	require.Contains(t, uri, "issuer=Bookstore")
+}